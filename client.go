@@ -1,184 +1,674 @@
 package connqc
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"net"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/hamba/logger/v2"
 	lctx "github.com/hamba/logger/v2/ctx"
-	"github.com/nitrado/connqc/tcp"
-	"github.com/nitrado/connqc/udp"
+	"github.com/nitrado/connqc/stats"
+	"github.com/nitrado/connqc/transport"
+
+	// Register the TCP and UDP transports, the other transports
+	// are registered by importing their packages.
+	_ "github.com/nitrado/connqc/tcp"
+	_ "github.com/nitrado/connqc/udp"
 )
 
+// ErrUnsupportedProtocol is returned when a client is run with a protocol
+// for which no transport is registered.
+var ErrUnsupportedProtocol = errors.New("unsupported protocol")
+
+// errMalformed is returned for malformed responses read from packet connections.
+var errMalformed = errors.New("malformed response")
+
+// TrackingStats contains the counters of the probes a client awaits a response for.
+type TrackingStats struct {
+	// InFlight is the number of probes awaiting a response.
+	InFlight int
+	// Expired is the number of probes considered lost after the probe timeout.
+	Expired uint64
+	// Evicted is the number of probes considered lost as the maximum
+	// number of in-flight probes was reached.
+	Evicted uint64
+	// Abandoned is the number of probes considered lost as their
+	// connection was dropped or the drain timeout was reached.
+	Abandoned uint64
+}
+
+type trackingStats struct {
+	inFlight  atomic.Int64
+	expired   atomic.Uint64
+	evicted   atomic.Uint64
+	abandoned atomic.Uint64
+}
+
 // Client attempts to hold a connection with a server, sending probe messages at a configured interval.
 type Client struct {
 	backoff      time.Duration
 	sendInterval time.Duration
-	readTimeout  time.Duration
+	sendPattern  Pattern
+	sendJitter   time.Duration
+	idleTimeout  time.Duration
+	probeTimeout time.Duration
+	maxInFlight  int
+	drainTimeout time.Duration
 	writeTimeout time.Duration
+	secret       []byte
+
+	stats           *stats.Stats
+	summaryInterval time.Duration
+	tracking        trackingStats
+	report          *reportObserver
+	count           uint64
+
+	dialer     transport.Dialer
+	transports map[string]transport.Transport
+	observers  []Observer
 
 	log *logger.Logger
 }
 
-// NewClient returns a client.
-func NewClient(backoff, sendInterval, readTimeout, writeTimeout time.Duration, log *logger.Logger) *Client {
+// NewClient returns a client, or an error if the options are invalid.
+//
+// The connection to the server is re-established if no response has been
+// received for the idle timeout while probes are outstanding.
+func NewClient(log *logger.Logger, opts ...Option) (*Client, error) {
+	cfg := newConfig(opts)
+	if err := cfg.validateClient(); err != nil {
+		return nil, err
+	}
+
+	st := stats.New(cfg.statsWindows...)
+	report := newReportObserver()
+	observers := append([]Observer{logObserver{log: log}, statsObserver{stats: st}, report}, cfg.observers...)
+
 	return &Client{
-		backoff:      backoff,
-		sendInterval: sendInterval,
-		readTimeout:  readTimeout,
-		writeTimeout: writeTimeout,
-		log:          log,
+		backoff:      cfg.backoff,
+		sendInterval: cfg.sendInterval,
+		sendPattern:  cfg.sendPattern,
+		sendJitter:   cfg.sendJitter,
+		idleTimeout:  cfg.idleTimeout,
+		probeTimeout: cfg.probeTimeout,
+		maxInFlight:  cfg.maxInFlight,
+		drainTimeout: cfg.drainTimeout,
+		writeTimeout: cfg.writeTimeout,
+		secret:       cfg.secret,
+
+		stats:           st,
+		summaryInterval: cfg.summaryInterval,
+		report:          report,
+		count:           cfg.count,
+
+		dialer:     cfg.dialer,
+		transports: cfg.transports,
+		observers:  observers,
+
+		log: log,
+	}, nil
+}
+
+// Stats returns the statistics summaries of the client for all configured windows.
+func (c *Client) Stats() []stats.Summary {
+	return c.stats.Summaries(time.Now())
+}
+
+// Report returns the report of the probes sent so far, summarising the whole run.
+func (c *Client) Report() Report {
+	return c.report.Report(time.Now())
+}
+
+// Tracking returns the counters of the probes the client awaits a response for.
+func (c *Client) Tracking() TrackingStats {
+	return TrackingStats{
+		InFlight:  int(c.tracking.inFlight.Load()),
+		Expired:   c.tracking.expired.Load(),
+		Evicted:   c.tracking.evicted.Load(),
+		Abandoned: c.tracking.abandoned.Load(),
+	}
+}
+
+// emit passes the event to all observers.
+func (c *Client) emit(e Event) {
+	for _, o := range c.observers {
+		o.Observe(e)
 	}
 }
 
-// Run sends probe messages to the server continuously.
+// Run sends probe messages to the server continuously, connecting with the
+// transport configured or registered under the protocol name.
 // If the connection fails, it retries at the configured backoff interval.
-func (c *Client) Run(ctx context.Context, protocol, addr string) {
-	var (
-		conn net.Conn
-		err  error
-		idx  int
-	)
-	for {
-		log := c.log.With(lctx.Str("protocol", protocol), lctx.Str("addr", addr), lctx.Int("reconnect", idx))
-		idx++
-
-		switch protocol {
-		case "tcp":
-			conn, err = tcp.Connect(addr)
-		case "udp":
-			conn, err = udp.Connect(addr)
-		default:
-			log.Error("Unexpected protocol")
-			return
-		}
+//
+// Only the tcp and udp transports are registered by this package. The
+// other transports are registered by importing their packages, such as
+// github.com/nitrado/connqc/tls, quic, unix or ws.
+//
+// Run blocks until the context is cancelled, returning nil once outstanding
+// probes have been drained and all goroutines have stopped, or an error if
+// the client cannot be run at all. If a count is configured, Run also returns
+// once the count has been sent and the outcome of all probes is known.
+func (c *Client) Run(ctx context.Context, protocol, addr string) error {
+	t, ok := c.transports[protocol]
+	if !ok {
+		t, ok = transport.Lookup(protocol)
+	}
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnsupportedProtocol, protocol)
+	}
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	summaryCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	if c.summaryInterval > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			c.logSummaries(summaryCtx)
+		}()
+	}
+
+	budget := &probeBudget{limit: c.count}
+	for idx := 0; ; idx++ {
+		d := &proxyRecorder{Dialer: c.dialer}
+		conn, err := t.Dial(ctx, d, addr)
 		if err != nil {
-			log.Error("Could not connect to server", lctx.Err(err))
+			c.emit(ConnectFailed{Protocol: protocol, Addr: addr, Reconnect: idx, Err: err})
 
 			select {
 			case <-ctx.Done():
-				return
+				return nil
 			case <-time.After(c.backoff):
 				continue
 			}
 		}
 
-		if err = c.handleConn(ctx, conn); err != nil {
-			log.Error("Connection error", lctx.Err(err))
+		err = c.handleConn(ctx, conn, budget, protocol, addr, d.proxy)
+		c.emit(Disconnected{Protocol: protocol, Addr: addr, Reconnect: idx, Err: err})
+
+		if ctx.Err() != nil || budget.Spent() {
+			return nil
+		}
+		if err == nil {
+			continue
 		}
 
+		// Back off after connection errors, such as rejected TLS handshakes,
+		// which would otherwise be retried in a tight loop.
 		select {
 		case <-ctx.Done():
-			return
-		default:
+			return nil
+		case <-time.After(c.backoff):
 		}
 	}
 }
 
-type expectation struct {
-	timestamp time.Time
-	probe     Probe
-}
+// logSummaries logs a summary of the statistics of every window at the summary interval.
+func (c *Client) logSummaries(ctx context.Context) {
+	ticker := time.NewTicker(c.summaryInterval)
+	defer ticker.Stop()
 
-func (c *Client) handleConn(ctx context.Context, conn net.Conn) error { //nolint:funlen // Simplify readability.
-	defer func() { _ = conn.Close() }()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		for _, sum := range c.Stats() {
+			c.log.Info("Summary",
+				lctx.Duration("window", sum.Window),
+				lctx.Uint64("sent", sum.Sent),
+				lctx.Uint64("received", sum.Received),
+				lctx.Uint64("lost", sum.Lost),
+				lctx.Float64("loss", sum.Loss()),
+				lctx.Uint64("reordered", sum.Reordered),
+				lctx.Uint64("duplicated", sum.Duplicated),
+				lctx.Uint64("late", sum.Late),
+				lctx.Uint64("corrupted", sum.Corrupted),
+				lctx.Duration("rtt_min", sum.RTT.Min),
+				lctx.Duration("rtt_avg", sum.RTT.Avg),
+				lctx.Duration("rtt_max", sum.RTT.Max),
+				lctx.Duration("rtt_stddev", sum.RTT.StdDev),
+				lctx.Duration("rtt_p50", sum.RTT.P50),
+				lctx.Duration("rtt_p90", sum.RTT.P90),
+				lctx.Duration("rtt_p99", sum.RTT.P99),
+				lctx.Duration("jitter", sum.Jitter),
+			)
+		}
+	}
+}
 
+func (c *Client) handleConn(ctx context.Context, conn net.Conn, budget *probeBudget, protocol, addr string, proxy time.Duration) error { //nolint:funlen,cyclop // Simplify readability.
 	readCh := make(chan readResponse)
-	go c.readLoop(conn, readCh)
+	done := make(chan struct{})
+	readDone := make(chan struct{})
+	go func() {
+		defer close(readDone)
+
+		c.readLoop(conn, readCh, done)
+	}()
+
+	// Closing the connection unblocks the read loop, which is
+	// waited for to not outlive the connection.
+	defer func() {
+		close(done)
+		_ = conn.Close()
+		<-readDone
+	}()
+
+	enc := NewEncoder(conn, codecOpts(c.secret)...)
+
+	version, caps, err := c.handshake(ctx, conn, enc, readCh)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil
+		}
+		return fmt.Errorf("handshake: %w", err)
+	}
+	connected := Connected{Protocol: protocol, Addr: addr, Version: version, Capabilities: caps, Proxy: proxy}
+	if ht, ok := conn.(transport.HandshakeTimer); ok {
+		connected.Handshake = ht.HandshakeDuration()
+	}
+	if r, ok := conn.(transport.Resumer); ok {
+		connected.Resumed = r.Resumed()
+	}
+	c.emit(connected)
 
-	enc := NewEncoder(conn)
+	// Probes are sent on an absolute timeline, so the time spent
+	// handling responses does not delay the following probes.
+	sched := newScheduler(c.sendPattern, c.sendInterval, c.sendJitter, time.Now())
+	sendTimer := time.NewTimer(sched.Sleep(time.Now()))
+	defer sendTimer.Stop()
 
-	id := uint64(1)
-	var expect []expectation
+	// The expiry timer fires when the earliest outstanding probe times out.
+	expiry := time.NewTimer(c.probeTimeout)
+	expiry.Stop()
+	defer expiry.Stop()
+
+	var (
+		id          = uint64(1)
+		out         = newOutstanding(c.maxInFlight)
+		armed       bool
+		resetExpiry = func() {
+			if next, ok := out.Next(); ok {
+				expiry.Reset(time.Until(next))
+			}
+		}
+
+		// Once the context is cancelled, no more probes are sent while
+		// the outstanding probes drain until the drain timer fires.
+		// Once the probe budget is spent, they drain until they are
+		// answered or lost.
+		sending = !budget.Spent()
+		doneCh  = ctx.Done()
+		drainCh <-chan time.Time
+	)
+	// Probes still in flight can no longer be answered once the connection
+	// is dropped or the drain timeout is reached, so they are lost.
+	defer func() {
+		now := time.Now()
+		for _, exp := range out.Abandon() {
+			c.tracking.abandoned.Add(1)
+			c.emit(ProbeLost{ID: exp.probe.ID, Data: exp.probe.Data, Time: now, Abandoned: true})
+		}
+		c.tracking.inFlight.Store(0)
+	}()
 	for {
+		var sendCh <-chan time.Time
+		switch {
+		case sending:
+			sendCh = sendTimer.C
+		case out.Len() == 0:
+			return nil
+		}
+
 		select {
-		case <-ctx.Done():
+		case <-doneCh:
+			if c.drainTimeout <= 0 {
+				return nil
+			}
+			sending, doneCh, drainCh = false, nil, time.After(c.drainTimeout)
+		case <-drainCh:
 			return nil
-		case <-time.After(c.sendInterval):
+		case <-sendCh:
+			sched.Wait()
 			_ = conn.SetWriteDeadline(time.Now().Add(c.writeTimeout))
 
-			p := Probe{
-				ID:   id,
-				Data: fmt.Sprintf("Hello %d", id),
+			data := fmt.Sprintf("Hello %d", id)
+			p := TimedProbe{
+				ID:         id,
+				ClientSend: time.Now(),
+				Checksum:   Checksum(data),
+				Data:       data,
 			}
 			if err := enc.Encode(p); err != nil {
 				return fmt.Errorf("writing message: %w", err)
 			}
 
-			c.log.Info("Message sent", lctx.Interface("probe", p))
-
 			id++
-			expect = append(expect, expectation{timestamp: time.Now(), probe: p})
+			sending = !budget.Use()
+			lateness, skipped := sched.Sent(p.ClientSend)
+			sendTimer.Reset(sched.Sleep(time.Now()))
+
+			evicted := out.Add(p, p.ClientSend.Add(c.probeTimeout))
+			if out.Len() == 1 || len(evicted) > 0 {
+				resetExpiry()
+			}
+			c.tracking.inFlight.Store(int64(out.Len()))
+			// The connection is considered idle when no response has been
+			// received for the idle timeout since the first unanswered probe.
+			if !armed {
+				_ = conn.SetReadDeadline(time.Now().Add(c.idleTimeout))
+				armed = true
+			}
+
+			c.emit(ProbeSent{ID: p.ID, Data: p.Data, Time: p.ClientSend, Lateness: lateness, Skipped: skipped})
+			for _, exp := range evicted {
+				c.tracking.evicted.Add(1)
+				c.emit(ProbeLost{ID: exp.probe.ID, Data: exp.probe.Data, Time: p.ClientSend, Evicted: true})
+			}
+		case <-expiry.C:
+			now := time.Now()
+			for _, exp := range out.Expire(now) {
+				c.tracking.expired.Add(1)
+				c.emit(ProbeLost{ID: exp.probe.ID, Data: exp.probe.Data, Time: now})
+			}
+			c.tracking.inFlight.Store(int64(out.Len()))
+			resetExpiry()
 		case resp, ok := <-readCh:
 			if !ok {
 				return nil
 			}
+			if reason, ok := rejectReason(resp.err); ok {
+				c.emit(ProbeRejected{Reason: reason, Time: resp.timestamp})
+				continue
+			}
 			if resp.err != nil {
 				return fmt.Errorf("reading response: %w", resp.err)
 			}
 
-			var (
-				exp   expectation
-				found bool
-			)
-			for {
-				if len(expect) == 0 {
-					break
-				}
-				exp, expect = expect[0], expect[1:]
-				if exp.probe.ID == resp.probe.ID {
-					found = true
-					break
-				}
-
-				c.log.Warn("Message dropped",
-					lctx.Str("error", "unexpected ID"),
-					lctx.Uint64("expected_id", resp.probe.ID),
-					lctx.Uint64("id", exp.probe.ID),
-					lctx.Str("data", exp.probe.Data),
-				)
+			p, ok := resp.msg.(TimedProbe)
+			if !ok {
+				c.log.Error("Unexpected message", lctx.Str("type", fmt.Sprintf("%T", resp.msg)))
+				continue
 			}
+
+			// Responses are verified before they are recorded, so forged or
+			// replayed responses cannot answer probes or mark them duplicated.
+			sent, found := out.Lookup(p.ID)
 			if !found {
-				c.log.Error("No expectation found")
+				c.log.Error("No expectation found", lctx.Uint64("id", p.ID))
+				continue
+			}
+			if reason := c.verify(sent.probe, p); reason != "" {
+				c.emit(ProbeRejected{ID: p.ID, Reason: reason, Time: resp.timestamp})
 				continue
 			}
 
-			c.log.Info("Message received",
-				lctx.Uint64("id", exp.probe.ID),
-				lctx.Str("data", exp.probe.Data),
-				lctx.Duration("took", resp.timestamp.Sub(exp.timestamp)),
-			)
+			exp, arr, distance, _ := out.Receive(p.ID)
+			c.tracking.inFlight.Store(int64(out.Len()))
+
+			if out.Len() > 0 {
+				_ = conn.SetReadDeadline(time.Now().Add(c.idleTimeout))
+			} else {
+				_ = conn.SetReadDeadline(time.Time{})
+				armed = false
+			}
+
+			c.received(caps, exp, arr, distance, p, resp.timestamp)
 		}
 	}
 }
 
+// probeBudget limits the number of probes sent over a run.
+// A zero limit is unlimited.
+type probeBudget struct {
+	limit uint64
+	sent  uint64
+}
+
+// Use records a sent probe, returning true if the budget is spent.
+func (b *probeBudget) Use() bool {
+	b.sent++
+	return b.Spent()
+}
+
+// Spent reports whether no more probes should be sent.
+func (b *probeBudget) Spent() bool {
+	return b.limit > 0 && b.sent >= b.limit
+}
+
+// received reports a response to a known probe.
+func (c *Client) received(caps Capability, exp expectation, arr Arrival, distance uint64, p TimedProbe, at time.Time) {
+	rtt := at.Sub(exp.probe.ClientSend)
+
+	switch arr {
+	case ArrivalDuplicate:
+		c.emit(ProbeDuplicated{ID: exp.probe.ID, Time: at})
+		return
+	case ArrivalLate:
+		c.emit(ProbeLate{ID: exp.probe.ID, Time: at, RTT: rtt})
+		return
+	}
+
+	if p.Corrupted() {
+		c.emit(ProbeCorrupted{
+			ID:           exp.probe.ID,
+			Data:         p.Data,
+			ExpectedData: exp.probe.Data,
+			Checksum:     p.Checksum,
+			Time:         at,
+		})
+		return
+	}
+
+	e := ProbeReceived{
+		ID:      exp.probe.ID,
+		Data:    exp.probe.Data,
+		Time:    at,
+		RTT:     rtt,
+		Arrival: arr,
+	}
+	if arr == ArrivalReordered {
+		e.ReorderDistance = distance
+	}
+	// Servers in echo mode send probes back as is when they are read along with
+	// other input, in which case they do not carry the server timestamps.
+	if caps.Has(CapTimestamps) && validTimestamps(p) {
+		e.Timestamps = true
+		e.Upstream = p.ServerRecv.Sub(exp.probe.ClientSend)
+		e.Server = p.ServerSend.Sub(p.ServerRecv)
+		e.Downstream = at.Sub(p.ServerSend)
+	}
+	c.emit(e)
+}
+
+// validTimestamps determines if the server timestamps of the response are set and ordered.
+func validTimestamps(p TimedProbe) bool {
+	return !p.ServerRecv.IsZero() && !p.ServerSend.IsZero() && !p.ServerSend.Before(p.ServerRecv)
+}
+
+// verify checks an authenticated response against the probe that was sent,
+// returning the reason for rejecting it, if any.
+//
+// The client send time acts as a nonce. As only responses to the probes
+// still remembered are accepted, old responses cannot be replayed, while
+// genuinely late responses are still classified as such.
+func (c *Client) verify(sent, got TimedProbe) string {
+	if c.secret == nil || got.ClientSend.Equal(sent.ClientSend) {
+		return ""
+	}
+	return "nonce mismatch"
+}
+
+// handshake announces the client to the server, returning the negotiated version and capabilities.
+//
+// Servers that only echo messages send the hello back, in which
+// case no capabilities are available.
+func (c *Client) handshake(
+	ctx context.Context,
+	conn net.Conn,
+	enc Encoder,
+	readCh <-chan readResponse,
+) (uint16, Capability, error) {
+	_ = conn.SetWriteDeadline(time.Now().Add(c.writeTimeout))
+	_ = conn.SetReadDeadline(time.Now().Add(c.idleTimeout))
+	defer func() { _ = conn.SetReadDeadline(time.Time{}) }()
+
+	hello := Hello{Version: ProtocolVersion, Capabilities: SupportedCapabilities}
+	if err := enc.Encode(hello); err != nil {
+		return 0, 0, fmt.Errorf("writing hello: %w", err)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return 0, 0, ctx.Err()
+		case resp, ok := <-readCh:
+			if !ok {
+				return 0, 0, errors.New("connection closed")
+			}
+			if reason, ok := rejectReason(resp.err); ok {
+				c.emit(ProbeRejected{Reason: reason, Time: resp.timestamp})
+				continue
+			}
+			if resp.err != nil {
+				return 0, 0, fmt.Errorf("reading hello response: %w", resp.err)
+			}
+
+			switch v := resp.msg.(type) {
+			case HelloAck:
+				return v.Version, v.Capabilities, nil
+			case Hello:
+				return 0, 0, nil
+			default:
+				return 0, 0, fmt.Errorf("unexpected hello response: %T", resp.msg)
+			}
+		}
+	}
+}
+
+// rejectReason returns the reason for rejecting a response that could not
+// be read, if the connection can continue after it.
+func rejectReason(err error) (string, bool) {
+	switch {
+	case errors.Is(err, ErrUnauthenticated):
+		return "unauthenticated", true
+	case errors.Is(err, errMalformed):
+		return "malformed", true
+	default:
+		return "", false
+	}
+}
+
+// proxyRecorder records the proxy connect time of the connections it dials.
+type proxyRecorder struct {
+	transport.Dialer
+
+	proxy time.Duration
+}
+
+func (d *proxyRecorder) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	conn, err := d.Dialer.DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	if pt, ok := conn.(transport.ProxyTimer); ok {
+		d.proxy += pt.ProxyDuration()
+	}
+	return conn, nil
+}
+
 type readResponse struct {
 	timestamp time.Time
-	probe     Probe
+	msg       Message
 	err       error
 }
 
-func (c *Client) readLoop(conn net.Conn, ch chan readResponse) {
+// readLoop reads messages from the connection until reading fails
+// or done is closed.
+func (c *Client) readLoop(conn net.Conn, ch chan<- readResponse, done <-chan struct{}) {
 	defer close(ch)
 
-	dec := NewDecoder(conn)
-	for {
-		_ = conn.SetReadDeadline(time.Now().Add(c.readTimeout))
+	send := func(resp readResponse) bool {
+		select {
+		case ch <- resp:
+			return true
+		case <-done:
+			return false
+		}
+	}
+
+	// Packet connections can drop a malformed packet and carry on with the next,
+	// while streams cannot be resynchronised after malformed input.
+	if sc, ok := conn.(streamConn); ok && !sc.Stream() {
+		c.readPackets(conn, send)
+		return
+	}
 
+	dec := NewDecoder(conn, codecOpts(c.secret)...)
+	for {
 		msg, err := dec.Decode()
+		if errors.Is(err, ErrUnauthenticated) {
+			if !send(readResponse{timestamp: time.Now(), err: err}) {
+				return
+			}
+			continue
+		}
 		if err != nil {
-			ch <- readResponse{err: err}
+			send(readResponse{err: err})
 			return
 		}
 
-		p, ok := msg.(Probe)
-		if !ok {
-			ch <- readResponse{err: fmt.Errorf("message not a probe: %T", msg)}
-			continue
+		if !send(readResponse{timestamp: time.Now(), msg: msg}) {
+			return
 		}
+	}
+}
 
-		ch <- readResponse{timestamp: time.Now(), probe: p}
+// maxPacketSize is the size of the largest packet read from packet connections.
+const maxPacketSize = 1<<16 - 1
+
+// readPackets reads the messages of the packets from the connection until
+// reading fails or sending a response fails.
+//
+// Malformed messages are dropped along with the rest of the packet.
+func (c *Client) readPackets(conn net.Conn, send func(readResponse) bool) {
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			send(readResponse{err: err})
+			return
+		}
+		timestamp := time.Now()
+
+		dec := NewDecoder(bytes.NewReader(buf[:n]), codecOpts(c.secret)...)
+		for {
+			msg, err := dec.Decode()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil && !errors.Is(err, ErrUnauthenticated) {
+				err = fmt.Errorf("%w: %w", errMalformed, err)
+			}
+			if !send(readResponse{timestamp: timestamp, msg: msg, err: err}) {
+				return
+			}
+			if errors.Is(err, errMalformed) {
+				break
+			}
+		}
 	}
 }
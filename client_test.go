@@ -0,0 +1,1022 @@
+package connqc_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hamba/logger/v2"
+	"github.com/nitrado/connqc"
+	"github.com/nitrado/connqc/memory"
+	"github.com/nitrado/connqc/transport"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/goleak"
+)
+
+func TestNewClient_ValidatesOptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    []connqc.Option
+		wantErr string
+	}{
+		{
+			name:    "negative backoff",
+			opts:    []connqc.Option{connqc.WithBackoff(-time.Second)},
+			wantErr: "backoff must not be negative, got -1s",
+		},
+		{
+			name:    "negative send interval",
+			opts:    []connqc.Option{connqc.WithSendInterval(-time.Second)},
+			wantErr: "send interval must be greater than zero, got -1s",
+		},
+		{
+			name:    "unsupported send pattern",
+			opts:    []connqc.Option{connqc.WithSendPattern(connqc.Pattern(42))},
+			wantErr: "unsupported send pattern Pattern(42)",
+		},
+		{
+			name:    "send jitter exceeding half the interval",
+			opts:    []connqc.Option{connqc.WithSendJitter(600 * time.Millisecond)},
+			wantErr: "send jitter must be between zero and half the send interval, got 600ms",
+		},
+		{
+			name:    "zero probe timeout",
+			opts:    []connqc.Option{connqc.WithProbeTimeout(0)},
+			wantErr: "probe timeout must be greater than zero, got 0s",
+		},
+		{
+			name:    "zero max in-flight probes",
+			opts:    []connqc.Option{connqc.WithMaxInFlight(0)},
+			wantErr: "max in-flight probes must be greater than zero, got 0",
+		},
+		{
+			name:    "no stats windows",
+			opts:    []connqc.Option{connqc.WithStatsWindows()},
+			wantErr: "at least one stats window is required",
+		},
+		{
+			name:    "zero replay window",
+			opts:    []connqc.Option{connqc.WithSecret([]byte("secret")), connqc.WithReplayWindow(0)},
+			wantErr: "replay window must be greater than zero, got 0s",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			log := logger.New(io.Discard, logger.LogfmtFormat(), logger.Error)
+
+			_, err := connqc.NewClient(log, test.opts...)
+
+			assert.EqualError(t, err, test.wantErr)
+		})
+	}
+}
+
+func TestClient_RunReturnsErrorOnUnsupportedProtocol(t *testing.T) {
+	verifyNoLeaks(t)
+
+	client := newTestClient(t)
+
+	err := client.Run(t.Context(), "carrier-pigeon", "127.0.0.1:0")
+
+	assert.ErrorIs(t, err, connqc.ErrUnsupportedProtocol)
+}
+
+func TestClient_RunStopsWhenServerIsUnreachable(t *testing.T) {
+	verifyNoLeaks(t)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := ln.Addr().String()
+	_ = ln.Close()
+
+	client := newTestClient(t, connqc.WithBackoff(10*time.Millisecond))
+
+	ctx, cancel := context.WithTimeout(t.Context(), 50*time.Millisecond)
+	defer cancel()
+
+	err = client.Run(ctx, "tcp", addr)
+
+	assert.NoError(t, err)
+}
+
+func TestClient_RunStopsDuringHandshake(t *testing.T) {
+	verifyNoLeaks(t)
+
+	// The listener accepts connections, but never answers.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = ln.Close() })
+
+	client := newTestClient(t)
+
+	ctx, cancel := context.WithTimeout(t.Context(), 50*time.Millisecond)
+	defer cancel()
+
+	err = client.Run(ctx, "tcp", ln.Addr().String())
+
+	assert.NoError(t, err)
+}
+
+func TestClient_RunNotifiesObservers(t *testing.T) {
+	verifyNoLeaks(t)
+
+	addr := newTestTCPServer(t)
+
+	events := make(chan connqc.Event, 100)
+	obs := connqc.ObserverFunc(func(e connqc.Event) {
+		select {
+		case events <- e:
+		default:
+		}
+	})
+
+	client := newTestClient(t,
+		connqc.WithSendInterval(10*time.Millisecond),
+		connqc.WithObserver(obs),
+	)
+
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+	errCh := make(chan error, 1)
+	go func() { errCh <- client.Run(ctx, "tcp", addr) }()
+
+	var got []connqc.Event
+	timeout := time.After(5 * time.Second)
+	for len(got) < 3 {
+		select {
+		case e := <-events:
+			got = append(got, e)
+		case <-timeout:
+			require.FailNow(t, "timed out waiting for events")
+		}
+	}
+
+	require.IsType(t, connqc.Connected{}, got[0])
+	connected := got[0].(connqc.Connected)
+	assert.Equal(t, "tcp", connected.Protocol)
+	assert.Equal(t, addr, connected.Addr)
+	assert.Equal(t, connqc.ProtocolVersion, connected.Version)
+	assert.Equal(t, connqc.CapTimestamps, connected.Capabilities)
+
+	require.IsType(t, connqc.ProbeSent{}, got[1])
+	sent := got[1].(connqc.ProbeSent)
+	assert.Equal(t, uint64(1), sent.ID)
+
+	require.IsType(t, connqc.ProbeReceived{}, got[2])
+	received := got[2].(connqc.ProbeReceived)
+	assert.Equal(t, uint64(1), received.ID)
+	assert.Equal(t, sent.Data, received.Data)
+	assert.Equal(t, connqc.ArrivalInOrder, received.Arrival)
+	assert.True(t, received.Timestamps)
+	assert.Positive(t, received.RTT)
+
+	cancel()
+	require.NoError(t, <-errCh)
+}
+
+func TestClient_RunUsesRegisteredTransport(t *testing.T) {
+	verifyNoLeaks(t)
+
+	registerPipe.Do(func() {
+		transport.Register("test-pipe", pipeTransport{})
+	})
+
+	received := make(chan connqc.ProbeReceived, 1)
+	obs := connqc.ObserverFunc(func(e connqc.Event) {
+		if v, ok := e.(connqc.ProbeReceived); ok {
+			select {
+			case received <- v:
+			default:
+			}
+		}
+	})
+	client := newTestClient(t,
+		connqc.WithSendInterval(10*time.Millisecond),
+		connqc.WithObserver(obs),
+	)
+
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+	errCh := make(chan error, 1)
+	go func() { errCh <- client.Run(ctx, "test-pipe", "pipe") }()
+
+	select {
+	case got := <-received:
+		assert.Equal(t, uint64(1), got.ID)
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "timed out waiting for the probe to be received")
+	}
+
+	cancel()
+	require.NoError(t, <-errCh)
+}
+
+func TestClient_RunOverMemoryTransport(t *testing.T) {
+	verifyNoLeaks(t)
+
+	log := logger.New(io.Discard, logger.LogfmtFormat(), logger.Error)
+	srv, err := connqc.NewServer(log)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+	srvErrCh := make(chan error, 1)
+	go func() { srvErrCh <- memory.Transport{}.Listen(ctx, t.Name(), srv) }()
+	require.Eventually(t, func() bool { return memory.Listening(t.Name()) }, time.Second, time.Millisecond)
+
+	received := make(chan connqc.ProbeReceived, 3)
+	obs := connqc.ObserverFunc(func(e connqc.Event) {
+		if v, ok := e.(connqc.ProbeReceived); ok {
+			select {
+			case received <- v:
+			default:
+			}
+		}
+	})
+	client := newTestClient(t,
+		connqc.WithSendInterval(time.Millisecond),
+		connqc.WithObserver(obs),
+	)
+
+	errCh := make(chan error, 1)
+	go func() { errCh <- client.Run(ctx, "memory", t.Name()) }()
+
+	for want := uint64(1); want <= 3; want++ {
+		select {
+		case got := <-received:
+			assert.Equal(t, want, got.ID)
+			assert.Equal(t, connqc.ArrivalInOrder, got.Arrival)
+			assert.True(t, got.Timestamps)
+		case <-time.After(5 * time.Second):
+			require.FailNow(t, "timed out waiting for the probe to be received")
+		}
+	}
+
+	cancel()
+	require.NoError(t, <-errCh)
+	require.NoError(t, <-srvErrCh)
+}
+
+func TestClient_RunOverTCPWithSecretInEchoMode(t *testing.T) {
+	verifyNoLeaks(t)
+
+	secret := []byte("secret")
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = ln.Close() })
+
+	log := logger.New(io.Discard, logger.LogfmtFormat(), logger.Error)
+	srv, err := connqc.NewServer(log, connqc.WithSecret(secret))
+	require.NoError(t, err)
+
+	srvDone := make(chan struct{})
+	go func() {
+		defer close(srvDone)
+
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer func() { _ = conn.Close() }()
+
+		srv.Serve(&streamPacketConn{Conn: conn})
+	}()
+
+	received := make(chan connqc.ProbeReceived, 10)
+	obs := connqc.ObserverFunc(func(e connqc.Event) {
+		if v, ok := e.(connqc.ProbeReceived); ok {
+			select {
+			case received <- v:
+			default:
+			}
+		}
+	})
+	client := newTestClient(t,
+		connqc.WithSecret(secret),
+		connqc.WithSendInterval(time.Millisecond),
+		connqc.WithObserver(obs),
+	)
+
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+	errCh := make(chan error, 1)
+	go func() { errCh <- client.Run(ctx, "tcp", ln.Addr().String()) }()
+
+	for want := uint64(1); want <= 10; want++ {
+		select {
+		case got := <-received:
+			assert.Equal(t, want, got.ID)
+			assert.True(t, got.Timestamps)
+		case <-time.After(5 * time.Second):
+			require.FailNow(t, "timed out waiting for the probe to be received")
+		}
+	}
+
+	cancel()
+	require.NoError(t, <-errCh)
+	<-srvDone
+
+	stats := srv.Stats()
+	assert.Zero(t, stats.Malformed)
+	assert.Zero(t, stats.Unauthenticated)
+}
+
+func TestClient_RunIgnoresTimestampsOfCoalescedProbesInEchoMode(t *testing.T) {
+	verifyNoLeaks(t)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = ln.Close() })
+
+	log := logger.New(io.Discard, logger.LogfmtFormat(), logger.Error)
+	srv, err := connqc.NewServer(log)
+	require.NoError(t, err)
+
+	srvDone := make(chan struct{})
+	go func() {
+		defer close(srvDone)
+
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer func() { _ = conn.Close() }()
+
+		srv.Serve(&coalescingPacketConn{streamPacketConn: streamPacketConn{Conn: conn}})
+	}()
+
+	received := make(chan connqc.ProbeReceived, 10)
+	obs := connqc.ObserverFunc(func(e connqc.Event) {
+		if v, ok := e.(connqc.ProbeReceived); ok {
+			select {
+			case received <- v:
+			default:
+			}
+		}
+	})
+	client := newTestClient(t,
+		connqc.WithSendInterval(10*time.Millisecond),
+		connqc.WithObserver(obs),
+	)
+
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+	errCh := make(chan error, 1)
+	go func() { errCh <- client.Run(ctx, "tcp", ln.Addr().String()) }()
+
+	for want := uint64(1); want <= 2; want++ {
+		select {
+		case got := <-received:
+			assert.Equal(t, want, got.ID)
+			assert.Positive(t, got.RTT)
+			assert.False(t, got.Timestamps)
+			assert.Zero(t, got.Upstream)
+			assert.Zero(t, got.Server)
+			assert.Zero(t, got.Downstream)
+		case <-time.After(5 * time.Second):
+			require.FailNow(t, "timed out waiting for the probe to be received")
+		}
+	}
+
+	cancel()
+	require.NoError(t, <-errCh)
+	<-srvDone
+}
+
+func TestClient_RunDrainsOutstandingProbes(t *testing.T) {
+	verifyNoLeaks(t)
+
+	addr := newDelayedTestServer(t, 100*time.Millisecond)
+
+	events := make(chan connqc.Event, 100)
+	obs := connqc.ObserverFunc(func(e connqc.Event) {
+		select {
+		case events <- e:
+		default:
+		}
+	})
+
+	client := newTestClient(t,
+		connqc.WithObserver(obs),
+		connqc.WithDrainTimeout(time.Second),
+	)
+
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+	errCh := make(chan error, 1)
+	go func() { errCh <- client.Run(ctx, "tcp", addr) }()
+
+	timeout := time.After(5 * time.Second)
+	for {
+		var e connqc.Event
+		select {
+		case e = <-events:
+		case <-timeout:
+			require.FailNow(t, "timed out waiting for the probe to be sent")
+		}
+		if _, ok := e.(connqc.ProbeSent); ok {
+			break
+		}
+	}
+
+	cancel()
+	require.NoError(t, <-errCh)
+
+	close(events)
+	var got []connqc.Event
+	for e := range events {
+		got = append(got, e)
+	}
+	require.Len(t, got, 2)
+	assert.IsType(t, connqc.ProbeReceived{}, got[0])
+	assert.IsType(t, connqc.Disconnected{}, got[1])
+}
+
+func TestClient_RunStopsAfterCount(t *testing.T) {
+	verifyNoLeaks(t)
+
+	log := logger.New(io.Discard, logger.LogfmtFormat(), logger.Error)
+	srv, err := connqc.NewServer(log)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+	srvErrCh := make(chan error, 1)
+	go func() { srvErrCh <- memory.Transport{}.Listen(ctx, t.Name(), srv) }()
+	require.Eventually(t, func() bool { return memory.Listening(t.Name()) }, time.Second, time.Millisecond)
+
+	client := newTestClient(t,
+		connqc.WithSendInterval(time.Millisecond),
+		connqc.WithCount(5),
+	)
+
+	errCh := make(chan error, 1)
+	go func() { errCh <- client.Run(ctx, "memory", t.Name()) }()
+
+	select {
+	case err = <-errCh:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "client did not stop after the count")
+	}
+
+	got := client.Report()
+	assert.Equal(t, uint64(5), got.Totals.Sent)
+	assert.Equal(t, uint64(5), got.Totals.Received)
+	assert.Zero(t, got.Totals.Lost)
+	assert.Positive(t, got.Totals.RTT.Max)
+	assert.Zero(t, got.Reconnects)
+	assert.Zero(t, got.LongestOutage)
+
+	cancel()
+	require.NoError(t, <-srvErrCh)
+}
+
+func TestClient_RunEvictsProbesBeyondMaxInFlight(t *testing.T) {
+	verifyNoLeaks(t)
+
+	addr := newDelayedTestServer(t, time.Minute)
+
+	evicted := make(chan connqc.ProbeLost, 100)
+	obs := connqc.ObserverFunc(func(e connqc.Event) {
+		if v, ok := e.(connqc.ProbeLost); ok && v.Evicted {
+			evicted <- v
+		}
+	})
+
+	client := newTestClient(t,
+		connqc.WithSendInterval(5*time.Millisecond),
+		connqc.WithIdleTimeout(time.Minute),
+		connqc.WithProbeTimeout(time.Minute),
+		connqc.WithMaxInFlight(5),
+		connqc.WithDrainTimeout(0),
+		connqc.WithObserver(obs),
+	)
+
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+	errCh := make(chan error, 1)
+	go func() { errCh <- client.Run(ctx, "tcp", addr) }()
+
+	for i := 1; i <= 3; i++ {
+		select {
+		case v := <-evicted:
+			assert.Equal(t, uint64(i), v.ID)
+		case <-time.After(5 * time.Second):
+			require.FailNow(t, "timed out waiting for the probe to be evicted")
+		}
+	}
+
+	got := client.Tracking()
+	assert.Equal(t, 5, got.InFlight)
+	assert.GreaterOrEqual(t, got.Evicted, uint64(3))
+	assert.Zero(t, got.Expired)
+
+	cancel()
+	require.NoError(t, <-errCh)
+	assert.Zero(t, client.Tracking().InFlight)
+}
+
+func TestClient_RunStopsAfterDrainTimeout(t *testing.T) {
+	verifyNoLeaks(t)
+
+	addr := newDelayedTestServer(t, time.Second)
+
+	var (
+		mu      sync.Mutex
+		sentIDs []uint64
+		lostIDs []uint64
+		sent    = make(chan struct{}, 1)
+	)
+	obs := connqc.ObserverFunc(func(e connqc.Event) {
+		switch v := e.(type) {
+		case connqc.ProbeSent:
+			mu.Lock()
+			sentIDs = append(sentIDs, v.ID)
+			mu.Unlock()
+
+			select {
+			case sent <- struct{}{}:
+			default:
+			}
+		case connqc.ProbeLost:
+			assert.True(t, v.Abandoned)
+
+			mu.Lock()
+			lostIDs = append(lostIDs, v.ID)
+			mu.Unlock()
+		}
+	})
+
+	client := newTestClient(t,
+		connqc.WithSendInterval(10*time.Millisecond),
+		connqc.WithIdleTimeout(5*time.Second),
+		connqc.WithObserver(obs),
+		connqc.WithProbeTimeout(5*time.Second),
+		connqc.WithDrainTimeout(50*time.Millisecond),
+	)
+
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+	errCh := make(chan error, 1)
+	go func() { errCh <- client.Run(ctx, "tcp", addr) }()
+
+	select {
+	case <-sent:
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "timed out waiting for the probe to be sent")
+	}
+
+	start := time.Now()
+	cancel()
+	require.NoError(t, <-errCh)
+
+	assert.Less(t, time.Since(start), 500*time.Millisecond)
+	// The probes left unanswered by the drain timeout are lost.
+	mu.Lock()
+	defer mu.Unlock()
+	assert.NotEmpty(t, lostIDs)
+	assert.Equal(t, sentIDs, lostIDs)
+	assert.Equal(t, uint64(len(lostIDs)), client.Tracking().Abandoned)
+	assert.Equal(t, uint64(len(lostIDs)), client.Report().Totals.Lost)
+}
+
+func TestClient_RunReconnectsAfterMalformedResponseOnStream(t *testing.T) {
+	verifyNoLeaks(t)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = ln.Close() })
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer func() { _ = conn.Close() }()
+
+		dec := connqc.NewDecoder(conn)
+		if _, err = dec.Decode(); err != nil {
+			return
+		}
+		_ = connqc.NewEncoder(conn).Encode(connqc.HelloAck{Version: connqc.ProtocolVersion})
+		if _, err = dec.Decode(); err != nil {
+			return
+		}
+		_, _ = conn.Write([]byte("XYZ"))
+		_, _ = io.Copy(io.Discard, conn)
+	}()
+
+	events := make(chan connqc.Event, 100)
+	client := newTestClient(t,
+		connqc.WithSendInterval(10*time.Millisecond),
+		connqc.WithObserver(connqc.ObserverFunc(func(e connqc.Event) {
+			select {
+			case events <- e:
+			default:
+			}
+		})),
+	)
+
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+	errCh := make(chan error, 1)
+	go func() { errCh <- client.Run(ctx, "tcp", ln.Addr().String()) }()
+
+	// The stream cannot be resynchronised, so the client reconnects
+	// instead of rejecting the response.
+	timeout := time.After(5 * time.Second)
+loop:
+	for {
+		select {
+		case e := <-events:
+			switch v := e.(type) {
+			case connqc.ProbeRejected:
+				assert.Failf(t, "unexpected rejected response", "reason %q", v.Reason)
+			case connqc.Disconnected:
+				assert.ErrorContains(t, v.Err, "unsupported message type")
+				break loop
+			}
+		case <-timeout:
+			require.FailNow(t, "timed out waiting for the client to disconnect")
+		}
+	}
+
+	cancel()
+	require.NoError(t, <-errCh)
+}
+
+func TestClient_RunVerifiesResponsesBeforeRecordingThem(t *testing.T) {
+	verifyNoLeaks(t)
+
+	secret := []byte("secret")
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = ln.Close() })
+
+	// The server answers every probe with a response carrying the wrong nonce
+	// before the genuine response.
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer func() { _ = conn.Close() }()
+
+		enc := connqc.NewEncoder(conn, connqc.WithHMAC(secret), connqc.AsServer())
+		dec := connqc.NewDecoder(conn, connqc.WithHMAC(secret), connqc.AsServer())
+		for {
+			msg, err := dec.Decode()
+			if err != nil {
+				return
+			}
+
+			switch v := msg.(type) {
+			case connqc.Hello:
+				_ = enc.Encode(connqc.HelloAck{Version: connqc.ProtocolVersion})
+			case connqc.TimedProbe:
+				forged := v
+				forged.ClientSend = v.ClientSend.Add(-time.Millisecond)
+				_ = enc.Encode(forged)
+				_ = enc.Encode(v)
+			}
+		}
+	}()
+
+	events := make(chan connqc.Event, 100)
+	client := newTestClient(t,
+		connqc.WithSecret(secret),
+		connqc.WithSendInterval(10*time.Millisecond),
+		connqc.WithObserver(connqc.ObserverFunc(func(e connqc.Event) {
+			switch v := e.(type) {
+			case connqc.ProbeRejected:
+				if v.ID == 1 {
+					events <- e
+				}
+			case connqc.ProbeReceived:
+				if v.ID == 1 {
+					events <- e
+				}
+			case connqc.ProbeDuplicated:
+				if v.ID == 1 {
+					events <- e
+				}
+			}
+		})),
+	)
+
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+	errCh := make(chan error, 1)
+	go func() { errCh <- client.Run(ctx, "tcp", ln.Addr().String()) }()
+
+	var got []connqc.Event
+	for range 2 {
+		select {
+		case e := <-events:
+			got = append(got, e)
+		case <-time.After(5 * time.Second):
+			require.FailNow(t, "timed out waiting for the responses")
+		}
+	}
+
+	require.IsType(t, connqc.ProbeRejected{}, got[0])
+	assert.Equal(t, "nonce mismatch", got[0].(connqc.ProbeRejected).Reason)
+	require.IsType(t, connqc.ProbeReceived{}, got[1])
+	assert.Equal(t, uint64(1), got[1].(connqc.ProbeReceived).ID)
+
+	cancel()
+	require.NoError(t, <-errCh)
+}
+
+func TestClient_RunClassifiesLateAuthenticatedResponses(t *testing.T) {
+	verifyNoLeaks(t)
+
+	secret := []byte("secret")
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = ln.Close() })
+
+	// The server answers the first probe well after the probe timeout and replay window.
+	srvDone := make(chan struct{})
+	go func() {
+		defer close(srvDone)
+
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer func() { _ = conn.Close() }()
+
+		enc := connqc.NewEncoder(conn, connqc.WithHMAC(secret), connqc.AsServer())
+		dec := connqc.NewDecoder(conn, connqc.WithHMAC(secret), connqc.AsServer())
+		for {
+			msg, err := dec.Decode()
+			if err != nil {
+				return
+			}
+
+			switch v := msg.(type) {
+			case connqc.Hello:
+				_ = enc.Encode(connqc.HelloAck{Version: connqc.ProtocolVersion})
+			case connqc.TimedProbe:
+				if v.ID == 1 {
+					time.Sleep(100 * time.Millisecond)
+				}
+				_ = enc.Encode(v)
+			}
+		}
+	}()
+
+	events := make(chan connqc.Event, 100)
+	client := newTestClient(t,
+		connqc.WithSecret(secret),
+		connqc.WithReplayWindow(10*time.Millisecond),
+		connqc.WithSendInterval(10*time.Millisecond),
+		connqc.WithProbeTimeout(20*time.Millisecond),
+		connqc.WithObserver(connqc.ObserverFunc(func(e connqc.Event) {
+			switch v := e.(type) {
+			case connqc.ProbeRejected:
+				events <- e
+			case connqc.ProbeLate:
+				if v.ID == 1 {
+					events <- e
+				}
+			}
+		})),
+	)
+
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+	errCh := make(chan error, 1)
+	go func() { errCh <- client.Run(ctx, "tcp", ln.Addr().String()) }()
+
+	select {
+	case e := <-events:
+		require.IsType(t, connqc.ProbeLate{}, e)
+		assert.GreaterOrEqual(t, e.(connqc.ProbeLate).RTT, 100*time.Millisecond)
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "timed out waiting for the late response")
+	}
+
+	cancel()
+	require.NoError(t, <-errCh)
+	<-srvDone
+}
+
+func TestClient_RunRejectsReflectedProbes(t *testing.T) {
+	verifyNoLeaks(t)
+
+	secret := []byte("secret")
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = ln.Close() })
+
+	// The server reflects every probe back to the client as it was
+	// sent, carrying a valid HMAC, before the genuine response.
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer func() { _ = conn.Close() }()
+
+		enc := connqc.NewEncoder(conn, connqc.WithHMAC(secret), connqc.AsServer())
+		reflect := connqc.NewEncoder(conn, connqc.WithHMAC(secret))
+		dec := connqc.NewDecoder(conn, connqc.WithHMAC(secret), connqc.AsServer())
+		for {
+			msg, err := dec.Decode()
+			if err != nil {
+				return
+			}
+
+			switch v := msg.(type) {
+			case connqc.Hello:
+				_ = enc.Encode(connqc.HelloAck{Version: connqc.ProtocolVersion})
+			case connqc.TimedProbe:
+				_ = reflect.Encode(v)
+				v.ServerRecv = time.Now()
+				v.ServerSend = time.Now()
+				_ = enc.Encode(v)
+			}
+		}
+	}()
+
+	events := make(chan connqc.Event, 100)
+	client := newTestClient(t,
+		connqc.WithSecret(secret),
+		connqc.WithSendInterval(10*time.Millisecond),
+		connqc.WithObserver(connqc.ObserverFunc(func(e connqc.Event) {
+			switch v := e.(type) {
+			case connqc.ProbeRejected:
+				events <- e
+			case connqc.ProbeReceived:
+				if v.ID == 1 {
+					events <- e
+				}
+			}
+		})),
+	)
+
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+	errCh := make(chan error, 1)
+	go func() { errCh <- client.Run(ctx, "tcp", ln.Addr().String()) }()
+
+	var got []connqc.Event
+	for range 2 {
+		select {
+		case e := <-events:
+			got = append(got, e)
+		case <-time.After(5 * time.Second):
+			require.FailNow(t, "timed out waiting for the responses")
+		}
+	}
+
+	require.IsType(t, connqc.ProbeRejected{}, got[0])
+	assert.Equal(t, "unauthenticated", got[0].(connqc.ProbeRejected).Reason)
+	require.IsType(t, connqc.ProbeReceived{}, got[1])
+	assert.Equal(t, uint64(1), got[1].(connqc.ProbeReceived).ID)
+
+	cancel()
+	require.NoError(t, <-errCh)
+}
+
+// coalescingPacketConn reads the probes following the hello two at a time,
+// as if the client coalesced them into a single write.
+type coalescingPacketConn struct {
+	streamPacketConn
+
+	reads int
+}
+
+func (c *coalescingPacketConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	n, addr, err := c.streamPacketConn.ReadFrom(p)
+	c.reads++
+	if err != nil || c.reads == 1 {
+		return n, addr, err
+	}
+
+	m, _, err := c.streamPacketConn.ReadFrom(p[n:])
+	return n + m, addr, err
+}
+
+func newTestTCPServer(t *testing.T) string {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = ln.Close() })
+
+	log := logger.New(io.Discard, logger.LogfmtFormat(), logger.Error)
+	srv, err := connqc.NewServer(log, connqc.WithMode(connqc.ModeMessage))
+	require.NoError(t, err)
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+
+			go srv.Serve(&streamPacketConn{Conn: conn})
+		}
+	}()
+
+	return ln.Addr().String()
+}
+
+func newTestClient(t *testing.T, opts ...connqc.Option) *connqc.Client {
+	t.Helper()
+
+	log := logger.New(io.Discard, logger.LogfmtFormat(), logger.Error)
+	opts = append([]connqc.Option{connqc.WithSummaryInterval(0)}, opts...)
+	client, err := connqc.NewClient(log, opts...)
+	require.NoError(t, err)
+
+	return client
+}
+
+// newDelayedTestServer starts a server answering the handshake immediately,
+// while delaying the response to every probe.
+func newDelayedTestServer(t *testing.T, delay time.Duration) string {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				defer func() { _ = conn.Close() }()
+
+				enc := connqc.NewEncoder(conn)
+				dec := connqc.NewDecoder(conn)
+				for {
+					msg, err := dec.Decode()
+					if err != nil {
+						return
+					}
+
+					switch v := msg.(type) {
+					case connqc.Hello:
+						_ = enc.Encode(connqc.HelloAck{Version: connqc.ProtocolVersion, Capabilities: connqc.CapTimestamps})
+					case connqc.TimedProbe:
+						time.AfterFunc(delay, func() {
+							v.ServerRecv, v.ServerSend = time.Now(), time.Now()
+							_ = enc.Encode(v)
+						})
+					}
+				}
+			}()
+		}
+	}()
+
+	return ln.Addr().String()
+}
+
+var registerPipe sync.Once
+
+// pipeTransport serves every dialed connection over an in-memory pipe.
+type pipeTransport struct{}
+
+func (pipeTransport) Dial(context.Context, transport.Dialer, string) (net.Conn, error) {
+	log := logger.New(io.Discard, logger.LogfmtFormat(), logger.Error)
+	srv, err := connqc.NewServer(log, connqc.WithMode(connqc.ModeMessage))
+	if err != nil {
+		return nil, err
+	}
+
+	srvConn, conn := net.Pipe()
+	go func() {
+		defer func() { _ = srvConn.Close() }()
+
+		srv.Serve(&streamPacketConn{Conn: srvConn})
+	}()
+	return conn, nil
+}
+
+func (pipeTransport) Listen(context.Context, string, transport.Handler) error {
+	return errors.New("not supported")
+}
+
+// verifyNoLeaks fails the test if goroutines started during the test are
+// still running once the test and its cleanups are done.
+func verifyNoLeaks(t *testing.T) {
+	t.Helper()
+
+	opt := goleak.IgnoreCurrent()
+	t.Cleanup(func() { goleak.VerifyNone(t, opt) })
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ettle/strcase"
+	"github.com/hamba/logger/v2"
+	"github.com/nitrado/connqc"
+	"github.com/urfave/cli/v2"
+)
+
+// checkStatus is the status of a check, whose value is the exit
+// code of the Nagios plugin API.
+type checkStatus int
+
+// Check statuses.
+const (
+	checkOK checkStatus = iota
+	checkWarning
+	checkCritical
+	checkUnknown
+)
+
+// String returns the string representation of the status.
+func (s checkStatus) String() string {
+	switch s {
+	case checkOK:
+		return "OK"
+	case checkWarning:
+		return "WARNING"
+	case checkCritical:
+		return "CRITICAL"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// MarshalText encodes the status as its string representation.
+func (s checkStatus) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// worse returns the worse of the statuses, critical being the worst.
+func worse(a, b checkStatus) checkStatus {
+	rank := func(s checkStatus) int {
+		switch s {
+		case checkCritical:
+			return 3
+		case checkUnknown:
+			return 2
+		default:
+			return int(s)
+		}
+	}
+	if rank(b) > rank(a) {
+		return b
+	}
+	return a
+}
+
+var (
+	warningThresholdFlags = thresholdFlags{
+		loss:   flagWarningLoss,
+		p99:    flagWarningP99,
+		jitter: flagWarningJitter,
+		outage: flagWarningOutage,
+	}
+	criticalThresholdFlags = thresholdFlags{
+		loss:   flagCriticalLoss,
+		p99:    flagCriticalP99,
+		jitter: flagCriticalJitter,
+		outage: flagCriticalOutage,
+	}
+)
+
+// checkThresholdsFlags returns the command flags of the thresholds with the given level.
+func checkThresholdsFlags(level string, flags thresholdFlags) []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:    flags.loss,
+			Usage:   fmt.Sprintf("The loss above which the check is %s, as a fraction or percentage such as '0.5%%'", level),
+			EnvVars: []string{strcase.ToSNAKE(flags.loss)},
+		},
+		&cli.DurationFlag{
+			Name:    flags.p99,
+			Usage:   fmt.Sprintf("The 99th percentile round-trip time above which the check is %s", level),
+			EnvVars: []string{strcase.ToSNAKE(flags.p99)},
+		},
+		&cli.DurationFlag{
+			Name:    flags.jitter,
+			Usage:   fmt.Sprintf("The jitter above which the check is %s", level),
+			EnvVars: []string{strcase.ToSNAKE(flags.jitter)},
+		},
+		&cli.DurationFlag{
+			Name:    flags.outage,
+			Usage:   fmt.Sprintf("The longest outage above which the check is %s", level),
+			EnvVars: []string{strcase.ToSNAKE(flags.outage)},
+		},
+	}
+}
+
+// checkResult is the result of checking a protocol.
+type checkResult struct {
+	Protocol string      `json:"protocol"`
+	Status   checkStatus `json:"status"`
+	Sent     uint64      `json:"sent"`
+	Received uint64      `json:"received"`
+	Lost     uint64      `json:"lost"`
+	Loss     float64     `json:"loss"`
+	RTTMin   float64     `json:"rtt_min_ms"`
+	RTTAvg   float64     `json:"rtt_avg_ms"`
+	RTTMax   float64     `json:"rtt_max_ms"`
+	RTTP99   float64     `json:"rtt_p99_ms"`
+	Jitter   float64     `json:"jitter_ms"`
+	Outage   float64     `json:"outage_ms"`
+	Breaches []string    `json:"breaches,omitempty"`
+	Error    string      `json:"error,omitempty"`
+}
+
+// checkOutput is the output of a check.
+type checkOutput struct {
+	Status  checkStatus   `json:"status"`
+	Addr    string        `json:"addr"`
+	Results []checkResult `json:"results"`
+}
+
+func runCheck(c *cli.Context) error {
+	format := c.String(flagFormat)
+	if format != flagFormatJSON && format != flagFormatNagios {
+		return exitError{code: int(checkUnknown), err: fmt.Errorf("unsupported format: %s", format)}
+	}
+
+	warning, err := thresholds(c, warningThresholdFlags)
+	if err != nil {
+		return exitError{code: int(checkUnknown), err: err}
+	}
+	critical, err := thresholds(c, criticalThresholdFlags)
+	if err != nil {
+		return exitError{code: int(checkUnknown), err: err}
+	}
+	tOpts, err := transportOpts(c)
+	if err != nil {
+		return exitError{code: int(checkUnknown), err: err}
+	}
+
+	out := checkOutput{Addr: c.String(flagAddr)}
+	for _, protocol := range c.StringSlice(flagProtocol) {
+		res := checkProtocol(c, protocol, tOpts, warning, critical)
+		out.Status = worse(out.Status, res.Status)
+		out.Results = append(out.Results, res)
+	}
+
+	switch format {
+	case flagFormatJSON:
+		_ = json.NewEncoder(c.App.Writer).Encode(out)
+	default:
+		printNagios(c.App.Writer, out, warning, critical)
+	}
+
+	if out.Status == checkOK {
+		return nil
+	}
+	return exitError{code: int(out.Status)}
+}
+
+// checkProtocol sends a burst of probes over the protocol,
+// returning the result evaluated against the thresholds.
+func checkProtocol(c *cli.Context, protocol string, tOpts []connqc.Option, warning, critical []threshold) checkResult {
+	res := checkResult{Protocol: protocol}
+
+	ctx, cancel := context.WithTimeout(c.Context, c.Duration(flagTimeout))
+	defer cancel()
+
+	// Errors caused by giving up on the check do not explain its failure.
+	var lastErr error
+	obs := connqc.ObserverFunc(func(e connqc.Event) {
+		if ctx.Err() != nil {
+			return
+		}
+		switch v := e.(type) {
+		case connqc.ConnectFailed:
+			lastErr = v.Err
+		case connqc.Disconnected:
+			if v.Err != nil {
+				lastErr = v.Err
+			}
+		}
+	})
+
+	opts := append(authOpts(c),
+		connqc.WithSendInterval(c.Duration(flagSendInterval)),
+		connqc.WithProbeTimeout(c.Duration(flagProbeTimeout)),
+		connqc.WithCount(c.Uint64(flagCount)),
+		connqc.WithDrainTimeout(0),
+		connqc.WithSummaryInterval(0),
+		connqc.WithObserver(obs),
+	)
+	opts = append(opts, tOpts...)
+	log := logger.New(io.Discard, logger.LogfmtFormat(), logger.Error)
+	client, err := connqc.NewClient(log, opts...)
+	if err != nil {
+		res.Status, res.Error = checkUnknown, err.Error()
+		return res
+	}
+
+	if err = client.Run(ctx, protocol, c.String(flagAddr)); err != nil {
+		res.Status, res.Error = checkUnknown, err.Error()
+		return res
+	}
+
+	report := client.Report()
+	sum := report.Totals
+	res.Sent, res.Received, res.Lost = sum.Sent, sum.Received, sum.Lost
+	res.Loss = sum.Loss()
+	res.RTTMin, res.RTTAvg, res.RTTMax = ms(sum.RTT.Min), ms(sum.RTT.Avg), ms(sum.RTT.Max)
+	res.RTTP99, res.Jitter, res.Outage = ms(sum.RTT.P99), ms(sum.Jitter), ms(report.LongestOutage)
+
+	if sum.Received == 0 {
+		res.Status, res.Error = checkCritical, "no responses received"
+		if lastErr != nil {
+			res.Error += ": " + lastErr.Error()
+		}
+		return res
+	}
+
+	if breaches := checkThresholds(critical, report); len(breaches) > 0 {
+		res.Status = checkCritical
+		for _, b := range breaches {
+			res.Breaches = append(res.Breaches, b.String())
+		}
+		return res
+	}
+	if breaches := checkThresholds(warning, report); len(breaches) > 0 {
+		res.Status = checkWarning
+		for _, b := range breaches {
+			res.Breaches = append(res.Breaches, b.String())
+		}
+	}
+	return res
+}
+
+// printNagios prints the output in the Nagios plugin format,
+// with the statistics of each protocol as performance data.
+func printNagios(w io.Writer, out checkOutput, warning, critical []threshold) {
+	msgs := make([]string, 0, len(out.Results))
+	var perf []string
+	for _, res := range out.Results {
+		switch {
+		case res.Error != "":
+			msgs = append(msgs, fmt.Sprintf("%s: %s", res.Protocol, res.Error))
+		case len(res.Breaches) > 0:
+			msgs = append(msgs, fmt.Sprintf("%s: %s", res.Protocol, strings.Join(res.Breaches, ", ")))
+		default:
+			msgs = append(msgs, fmt.Sprintf("%s: %d/%d received, %s loss, rtt avg %.3fms",
+				res.Protocol, res.Received, res.Sent, formatPercent(res.Loss), res.RTTAvg,
+			))
+		}
+		if res.Status == checkUnknown {
+			continue
+		}
+
+		perf = append(perf,
+			perfData(res.Protocol+"_loss", 100*res.Loss, "%", "loss", 100, warning, critical),
+			perfData(res.Protocol+"_rtt_avg", res.RTTAvg, "ms", "", 0, nil, nil),
+			perfData(res.Protocol+"_rtt_p99", res.RTTP99, "ms", "rtt p99", 0, warning, critical),
+			perfData(res.Protocol+"_jitter", res.Jitter, "ms", "jitter", 0, warning, critical),
+			perfData(res.Protocol+"_outage", res.Outage, "ms", "longest outage", 0, warning, critical),
+		)
+	}
+
+	_, _ = fmt.Fprintf(w, "CONNQC %s - %s", out.Status, strings.Join(msgs, "; "))
+	if len(perf) > 0 {
+		_, _ = fmt.Fprintf(w, " | %s", strings.Join(perf, " "))
+	}
+	_, _ = fmt.Fprintln(w)
+}
+
+// perfData formats a Nagios performance data value, with the warning and critical
+// levels of the metric's thresholds. A zero max omits the maximum value.
+func perfData(label string, v float64, uom, metric string, maxV float64, warning, critical []threshold) string {
+	level := func(ts []threshold) string {
+		for _, t := range ts {
+			if t.metric != metric {
+				continue
+			}
+			if uom == "%" {
+				return formatFloat(100 * t.limit)
+			}
+			return formatFloat(ms(time.Duration(t.limit)))
+		}
+		return ""
+	}
+
+	data := fmt.Sprintf("%s=%s%s;%s;%s;0", label, formatFloat(math.Round(v*1000)/1000), uom, level(warning), level(critical))
+	if maxV > 0 {
+		data += ";" + formatFloat(maxV)
+	}
+	return data
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// ms returns the duration in milliseconds.
+func ms(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
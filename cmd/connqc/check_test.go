@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWorse(t *testing.T) {
+	tests := []struct {
+		a, b checkStatus
+		want checkStatus
+	}{
+		{a: checkOK, b: checkOK, want: checkOK},
+		{a: checkOK, b: checkWarning, want: checkWarning},
+		{a: checkWarning, b: checkOK, want: checkWarning},
+		{a: checkWarning, b: checkUnknown, want: checkUnknown},
+		{a: checkUnknown, b: checkWarning, want: checkUnknown},
+		{a: checkUnknown, b: checkCritical, want: checkCritical},
+		{a: checkCritical, b: checkUnknown, want: checkCritical},
+		{a: checkCritical, b: checkOK, want: checkCritical},
+	}
+
+	for _, test := range tests {
+		t.Run(test.a.String()+"_"+test.b.String(), func(t *testing.T) {
+			assert.Equal(t, test.want, worse(test.a, test.b))
+		})
+	}
+}
+
+func TestPerfData(t *testing.T) {
+	warning := []threshold{
+		{metric: "loss", limit: 0.01},
+		{metric: "rtt p99", limit: float64(20 * time.Millisecond)},
+	}
+	critical := []threshold{
+		{metric: "loss", limit: 0.05},
+		{metric: "rtt p99", limit: float64(50500 * time.Microsecond)},
+	}
+
+	tests := []struct {
+		name   string
+		label  string
+		v      float64
+		uom    string
+		metric string
+		maxV   float64
+		want   string
+	}{
+		{
+			name:   "percent with max",
+			label:  "udp_loss",
+			v:      2.5,
+			uom:    "%",
+			metric: "loss",
+			maxV:   100,
+			want:   "udp_loss=2.5%;1;5;0;100",
+		},
+		{
+			name:   "milliseconds",
+			label:  "udp_rtt_p99",
+			v:      12.3456,
+			uom:    "ms",
+			metric: "rtt p99",
+			want:   "udp_rtt_p99=12.346ms;20;50.5;0",
+		},
+		{
+			name:   "without thresholds",
+			label:  "udp_jitter",
+			v:      1,
+			uom:    "ms",
+			metric: "jitter",
+			want:   "udp_jitter=1ms;;;0",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := perfData(test.label, test.v, test.uom, test.metric, test.maxV, warning, critical)
+
+			assert.Equal(t, test.want, got)
+		})
+	}
+}
+
+func TestPrintNagios(t *testing.T) {
+	warning := []threshold{{metric: "loss", limit: 0.01}}
+	critical := []threshold{{metric: "rtt p99", limit: float64(100 * time.Millisecond)}}
+
+	tests := []struct {
+		name string
+		out  checkOutput
+		want string
+	}{
+		{
+			name: "ok",
+			out: checkOutput{
+				Status: checkOK,
+				Results: []checkResult{
+					{Protocol: "udp", Status: checkOK, Sent: 10, Received: 10, RTTAvg: 1.25, RTTP99: 2, Jitter: 0.5},
+				},
+			},
+			want: "CONNQC OK - udp: 10/10 received, 0% loss, rtt avg 1.250ms" +
+				" | udp_loss=0%;1;;0;100 udp_rtt_avg=1.25ms;;;0 udp_rtt_p99=2ms;;100;0 udp_jitter=0.5ms;;;0 udp_outage=0ms;;;0\n",
+		},
+		{
+			name: "breach and unknown",
+			out: checkOutput{
+				Status: checkUnknown,
+				Results: []checkResult{
+					{
+						Protocol: "tcp",
+						Status:   checkWarning,
+						Sent:     10,
+						Received: 9,
+						Lost:     1,
+						Loss:     0.1,
+						Outage:   1000,
+						Breaches: []string{"loss 10% exceeds warning-loss=1% by 9%"},
+					},
+					{Protocol: "quic", Status: checkUnknown, Error: "unsupported protocol"},
+				},
+			},
+			want: "CONNQC UNKNOWN - tcp: loss 10% exceeds warning-loss=1% by 9%; quic: unsupported protocol" +
+				" | tcp_loss=10%;1;;0;100 tcp_rtt_avg=0ms;;;0 tcp_rtt_p99=0ms;;100;0 tcp_jitter=0ms;;;0 tcp_outage=1000ms;;;0\n",
+		},
+		{
+			name: "only unknown",
+			out: checkOutput{
+				Status:  checkUnknown,
+				Results: []checkResult{{Protocol: "quic", Status: checkUnknown, Error: "unsupported protocol"}},
+			},
+			want: "CONNQC UNKNOWN - quic: unsupported protocol\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			printNagios(&buf, test.out, warning, critical)
+
+			assert.Equal(t, test.want, buf.String())
+		})
+	}
+}
+
+func TestCheckOutput_JSON(t *testing.T) {
+	out := checkOutput{
+		Status: checkCritical,
+		Addr:   "127.0.0.1:8123",
+		Results: []checkResult{
+			{
+				Protocol: "udp",
+				Status:   checkCritical,
+				Sent:     10,
+				Received: 8,
+				Lost:     2,
+				Loss:     0.2,
+				RTTMin:   1,
+				RTTAvg:   1.5,
+				RTTMax:   3,
+				RTTP99:   2.9,
+				Jitter:   0.25,
+				Outage:   40,
+				Breaches: []string{"loss 20% exceeds critical-loss=5% by 15%"},
+			},
+			{Protocol: "tcp", Status: checkUnknown, Error: "connection refused"},
+		},
+	}
+
+	b, err := json.Marshal(out)
+	require.NoError(t, err)
+
+	want := `{
+		"status": "CRITICAL",
+		"addr": "127.0.0.1:8123",
+		"results": [
+			{
+				"protocol": "udp",
+				"status": "CRITICAL",
+				"sent": 10,
+				"received": 8,
+				"lost": 2,
+				"loss": 0.2,
+				"rtt_min_ms": 1,
+				"rtt_avg_ms": 1.5,
+				"rtt_max_ms": 3,
+				"rtt_p99_ms": 2.9,
+				"jitter_ms": 0.25,
+				"outage_ms": 40,
+				"breaches": ["loss 20% exceeds critical-loss=5% by 15%"]
+			},
+			{
+				"protocol": "tcp",
+				"status": "UNKNOWN",
+				"sent": 0,
+				"received": 0,
+				"lost": 0,
+				"loss": 0,
+				"rtt_min_ms": 0,
+				"rtt_avg_ms": 0,
+				"rtt_max_ms": 0,
+				"rtt_p99_ms": 0,
+				"jitter_ms": 0,
+				"outage_ms": 0,
+				"error": "connection refused"
+			}
+		]
+	}`
+	assert.JSONEq(t, want, string(b))
+}
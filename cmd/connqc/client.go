@@ -2,7 +2,10 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"time"
 
 	"github.com/hamba/cmd/v2"
 	lctx "github.com/hamba/logger/v2/ctx"
@@ -11,8 +14,7 @@ import (
 )
 
 func runClient(c *cli.Context) error {
-	ctx, cancel := context.WithCancel(c.Context)
-	defer cancel()
+	ctx := c.Context
 
 	log, err := cmd.NewLogger(c)
 	if err != nil {
@@ -20,26 +22,110 @@ func runClient(c *cli.Context) error {
 	}
 
 	protocol := c.String(flagProtocol)
-	if protocol != flagProtocolTCP && protocol != flagProtocolUDP {
-		return fmt.Errorf("unsupported protocol: %s", protocol)
+
+	log = log.With(lctx.Str("protocol", protocol))
+
+	idleTimeout := c.Duration(flagIdleTimeout)
+	if c.IsSet(flagReadTimeout) {
+		log.Warn("The read-timeout flag is deprecated, use idle-timeout instead")
+		if !c.IsSet(flagIdleTimeout) {
+			idleTimeout = c.Duration(flagReadTimeout)
+		}
+	}
+
+	var windows []time.Duration
+	for _, v := range c.StringSlice(flagStatsWindows) {
+		w, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("parsing stats window %q: %w", v, err)
+		}
+		windows = append(windows, w)
 	}
 
-	backoff := c.Duration(flagConnBackoff)
-	sendInterval := c.Duration(flagSendInterval)
-	readTimeout := c.Duration(flagReadTimeout)
-	writeTimeout := c.Duration(flagWriteTimeout)
+	var pattern connqc.Pattern
+	switch c.String(flagSendPattern) {
+	case flagSendPatternFixed:
+		pattern = connqc.PatternFixed
+	case flagSendPatternJitter:
+		pattern = connqc.PatternJitter
+	case flagSendPatternPoisson:
+		pattern = connqc.PatternPoisson
+	default:
+		return fmt.Errorf("unsupported pattern: %s", c.String(flagSendPattern))
+	}
 
-	log = log.With(lctx.Str("protocol", protocol))
+	opts := append(authOpts(c),
+		connqc.WithBackoff(c.Duration(flagConnBackoff)),
+		connqc.WithSendInterval(c.Duration(flagSendInterval)),
+		connqc.WithSendPattern(pattern),
+		connqc.WithSendJitter(c.Duration(flagSendJitter)),
+		connqc.WithIdleTimeout(idleTimeout),
+		connqc.WithWriteTimeout(c.Duration(flagWriteTimeout)),
+		connqc.WithProbeTimeout(c.Duration(flagProbeTimeout)),
+		connqc.WithMaxInFlight(c.Int(flagMaxInFlight)),
+		connqc.WithCount(c.Uint64(flagCount)),
+		connqc.WithDrainTimeout(c.Duration(flagDrainTimeout)),
+		connqc.WithStatsWindows(windows...),
+		connqc.WithSummaryInterval(c.Duration(flagSummaryInterval)),
+	)
+	tOpts, err := transportOpts(c)
+	if err != nil {
+		return err
+	}
+	opts = append(opts, tOpts...)
+
+	ts, err := thresholds(c, maxThresholdFlags)
+	if err != nil {
+		return err
+	}
+
+	client, err := connqc.NewClient(log, opts...)
+	if err != nil {
+		return err
+	}
+
+	stop := context.AfterFunc(ctx, func() {
+		log.Info("Shutting down")
+	})
+	defer stop()
 
-	client := connqc.NewClient(backoff, sendInterval, readTimeout, writeTimeout, log)
-	go func() {
-		client.Run(ctx, protocol, c.String(flagAddr))
-		cancel()
-	}()
+	if d := c.Duration(flagDuration); d > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d)
+		defer cancel()
+	}
 
-	<-ctx.Done()
+	if err = client.Run(ctx, protocol, c.String(flagAddr)); err != nil {
+		return err
+	}
 
-	log.Info("Shutting down")
+	report := client.Report()
+	printReport(c.App.Writer, c.String(flagAddr), report)
 
-	return nil
+	if report.Totals.Received == 0 {
+		return errors.New("no responses received")
+	}
+	return printBreaches(c.App.Writer, checkThresholds(ts, report))
+}
+
+// printReport prints the report of a run, like the summary printed by ping.
+func printReport(w io.Writer, addr string, r connqc.Report) {
+	sum := r.Totals
+
+	_, _ = fmt.Fprintf(w, "\n--- %s connqc statistics ---\n", addr)
+	_, _ = fmt.Fprintf(w, "%d probes sent, %d received", sum.Sent, sum.Received)
+	if sum.Duplicated > 0 {
+		_, _ = fmt.Fprintf(w, ", +%d duplicates", sum.Duplicated)
+	}
+	if sum.Corrupted > 0 {
+		_, _ = fmt.Fprintf(w, ", %d corrupted", sum.Corrupted)
+	}
+	_, _ = fmt.Fprintf(w, ", %.4g%% loss, %d reconnects, longest outage %s\n",
+		100*sum.Loss(), r.Reconnects, r.LongestOutage.Round(time.Millisecond))
+
+	if sum.Received == 0 {
+		return
+	}
+	_, _ = fmt.Fprintf(w, "rtt min/avg/max/mdev = %.3f/%.3f/%.3f/%.3f ms\n",
+		ms(sum.RTT.Min), ms(sum.RTT.Avg), ms(sum.RTT.Max), ms(sum.RTT.StdDev))
 }
@@ -2,35 +2,135 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"os/signal"
 	"runtime/debug"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/ettle/strcase"
 	"github.com/hamba/cmd/v2"
 	_ "github.com/joho/godotenv/autoload"
+	"github.com/nitrado/connqc/transport"
+	"github.com/nitrado/connqc/ws"
 	"github.com/urfave/cli/v2"
+
+	// Register the transports that are not registered by the connqc package.
+	_ "github.com/nitrado/connqc/quic"
+	_ "github.com/nitrado/connqc/tls"
+	_ "github.com/nitrado/connqc/unix"
 )
 
 const (
 	flagProtocol    = "protocol"
 	flagProtocolTCP = "tcp"
 	flagProtocolUDP = "udp"
-	flagAddr        = "addr"
+	flagProtocolTLS = "tls"
+	flagProtocolWS  = "ws"
+	flagProtocolWSS = "wss"
+
+	flagProtocolQUIC       = "quic"
+	flagProtocolQUICStream = "quic-stream"
+	flagAddr               = "addr"
 
+	flagMode         = "mode"
+	flagModeEcho     = "echo"
+	flagModeMessage  = "message"
 	flagBufferSize   = "buffer-size"
 	flagReadTimeout  = "read-timeout"
 	flagWriteTimeout = "write-timeout"
+	flagIdleTimeout  = "idle-timeout"
+	flagDrainTimeout = "drain-timeout"
+	flagProbeTimeout = "probe-timeout"
+	flagMaxInFlight  = "max-in-flight"
+	flagCount        = "count"
+	flagDuration     = "duration"
+	flagMaxLoss      = "max-loss"
+	flagMaxP99       = "max-p99"
+	flagMaxJitter    = "max-jitter"
+	flagMaxOutage    = "max-outage"
+
+	flagFormat         = "format"
+	flagFormatJSON     = "json"
+	flagFormatNagios   = "nagios"
+	flagTimeout        = "timeout"
+	flagWarningLoss    = "warning-loss"
+	flagWarningP99     = "warning-p99"
+	flagWarningJitter  = "warning-jitter"
+	flagWarningOutage  = "warning-outage"
+	flagCriticalLoss   = "critical-loss"
+	flagCriticalP99    = "critical-p99"
+	flagCriticalJitter = "critical-jitter"
+	flagCriticalOutage = "critical-outage"
+
+	flagConnBackoff        = "backoff"
+	flagSendInterval       = "interval"
+	flagSendPattern        = "pattern"
+	flagSendPatternFixed   = "fixed"
+	flagSendPatternJitter  = "jitter"
+	flagSendPatternPoisson = "poisson"
+	flagSendJitter         = "jitter"
+
+	flagStatsWindows    = "stats-windows"
+	flagSummaryInterval = "summary-interval"
+
+	flagSecret       = "secret"
+	flagReplayWindow = "replay-window"
+
+	flagUDPCookies = "udp-cookies"
+
+	flagTLSCert            = "tls-cert"
+	flagTLSKey             = "tls-key"
+	flagTLSCA              = "tls-ca"
+	flagTLSClientCA        = "tls-client-ca"
+	flagInsecure           = "insecure"
+	flagGenerateSelfSigned = "generate-self-signed"
 
-	flagConnBackoff  = "backoff"
-	flagSendInterval = "interval"
+	flagWSPath = "ws-path"
+
+	flagProxy = "proxy"
 )
 
 var version = "¯\\_(ツ)_/¯"
 
+// clientTransportFlags are the flags configuring the transports of the client commands.
+var clientTransportFlags = cmd.Flags{
+	&cli.StringFlag{
+		Name:    flagTLSCert,
+		Usage:   "The client certificate file presented to TLS servers requiring client certificates",
+		EnvVars: []string{strcase.ToSNAKE(flagTLSCert)},
+	},
+	&cli.StringFlag{
+		Name:    flagTLSKey,
+		Usage:   "The key file of the client certificate",
+		EnvVars: []string{strcase.ToSNAKE(flagTLSKey)},
+	},
+	&cli.StringFlag{
+		Name:    flagTLSCA,
+		Usage:   "The CA certificates file used to verify TLS servers instead of the system roots",
+		EnvVars: []string{strcase.ToSNAKE(flagTLSCA)},
+	},
+	&cli.BoolFlag{
+		Name:    flagInsecure,
+		Usage:   "Skip the verification of TLS server certificates",
+		EnvVars: []string{strcase.ToSNAKE(flagInsecure)},
+	},
+	&cli.StringFlag{
+		Name:    flagWSPath,
+		Usage:   "The HTTP path on which WebSocket connections are upgraded",
+		Value:   ws.DefaultPath,
+		EnvVars: []string{strcase.ToSNAKE(flagWSPath)},
+	},
+	&cli.StringFlag{
+		Name:    flagProxy,
+		Usage:   "The URL of the proxy to connect through. Supported schemes: 'http', 'socks5'",
+		EnvVars: []string{strcase.ToSNAKE(flagProxy)},
+	},
+}
+
 var commands = []*cli.Command{
 	{
 		Name:  "client",
@@ -39,7 +139,7 @@ var commands = []*cli.Command{
 			&cli.StringFlag{
 				Name: flagProtocol,
 				Usage: fmt.Sprintf(
-					"The protocol for the connection. Supported protocols: '%s', '%s'", flagProtocolTCP, flagProtocolUDP,
+					"The protocol for the connection. Supported protocols: %s", supportedProtocols(),
 				),
 				Value:   flagProtocolTCP,
 				EnvVars: []string{strcase.ToSNAKE(flagProtocol)},
@@ -56,43 +156,151 @@ var commands = []*cli.Command{
 				Value:   time.Second,
 				EnvVars: []string{strcase.ToSNAKE(flagConnBackoff)},
 			},
+			&cli.Uint64Flag{
+				Name:    flagCount,
+				Usage:   "The number of probe messages to send before printing a summary and exiting. Zero sends until interrupted",
+				EnvVars: []string{strcase.ToSNAKE(flagCount)},
+			},
+			&cli.DurationFlag{
+				Name:    flagDuration,
+				Usage:   "The duration to send probe messages for before printing a summary and exiting. Zero sends until interrupted",
+				EnvVars: []string{strcase.ToSNAKE(flagDuration)},
+			},
+			&cli.StringFlag{
+				Name: flagMaxLoss,
+				Usage: fmt.Sprintf(
+					"The maximum loss of the run, as a fraction or percentage such as '0.5%%'. Breaches exit with code %d",
+					exitCodeBreach,
+				),
+				EnvVars: []string{strcase.ToSNAKE(flagMaxLoss)},
+			},
+			&cli.DurationFlag{
+				Name:    flagMaxP99,
+				Usage:   fmt.Sprintf("The maximum 99th percentile round-trip time of the run. Breaches exit with code %d", exitCodeBreach),
+				EnvVars: []string{strcase.ToSNAKE(flagMaxP99)},
+			},
+			&cli.DurationFlag{
+				Name:    flagMaxJitter,
+				Usage:   fmt.Sprintf("The maximum jitter of the run. Breaches exit with code %d", exitCodeBreach),
+				EnvVars: []string{strcase.ToSNAKE(flagMaxJitter)},
+			},
+			&cli.DurationFlag{
+				Name:    flagMaxOutage,
+				Usage:   fmt.Sprintf("The maximum outage during the run. Breaches exit with code %d", exitCodeBreach),
+				EnvVars: []string{strcase.ToSNAKE(flagMaxOutage)},
+			},
 			&cli.DurationFlag{
 				Name:    flagSendInterval,
 				Usage:   "The interval at which to send probe messages to the server",
 				Value:   time.Second,
 				EnvVars: []string{strcase.ToSNAKE(flagSendInterval)},
 			},
+			&cli.StringFlag{
+				Name: flagSendPattern,
+				Usage: fmt.Sprintf(
+					"The pattern in which probe messages are spaced. Supported patterns: '%s', '%s', '%s'",
+					flagSendPatternFixed, flagSendPatternJitter, flagSendPatternPoisson,
+				),
+				Value:   flagSendPatternFixed,
+				EnvVars: []string{strcase.ToSNAKE(flagSendPattern)},
+			},
 			&cli.DurationFlag{
-				Name:    flagReadTimeout,
-				Usage:   "The duration after which the client should timeout when reading from a connection",
+				Name:    flagSendJitter,
+				Usage:   "The maximum random offset of each probe message from its interval with the jitter pattern",
+				EnvVars: []string{strcase.ToSNAKE(flagSendJitter)},
+			},
+			&cli.DurationFlag{
+				Name:    flagProbeTimeout,
+				Usage:   "The duration after which a probe message without response is considered lost",
 				Value:   2 * time.Second,
+				EnvVars: []string{strcase.ToSNAKE(flagProbeTimeout)},
+			},
+			&cli.IntFlag{
+				Name:    flagMaxInFlight,
+				Usage:   "The maximum number of probe messages awaiting a response, beyond which the oldest is considered lost",
+				Value:   4096,
+				EnvVars: []string{strcase.ToSNAKE(flagMaxInFlight)},
+			},
+			&cli.DurationFlag{
+				Name:    flagIdleTimeout,
+				Usage:   "The duration without any response after which the client should reconnect to the server",
+				Value:   10 * time.Second,
+				EnvVars: []string{strcase.ToSNAKE(flagIdleTimeout)},
+			},
+			&cli.DurationFlag{
+				Name:    flagReadTimeout,
+				Usage:   "Deprecated: use --idle-timeout instead, which it sets unless that is set as well",
 				EnvVars: []string{strcase.ToSNAKE(flagReadTimeout)},
 			},
+			&cli.DurationFlag{
+				Name:    flagDrainTimeout,
+				Usage:   "The duration to wait on shutdown for the responses to outstanding probe messages",
+				Value:   2 * time.Second,
+				EnvVars: []string{strcase.ToSNAKE(flagDrainTimeout)},
+			},
 			&cli.DurationFlag{
 				Name:    flagWriteTimeout,
 				Usage:   "The duration after which the client should timeout when writing to a connection",
 				Value:   5 * time.Second,
 				EnvVars: []string{strcase.ToSNAKE(flagWriteTimeout)},
 			},
-		}.Merge(cmd.LogFlags),
+			&cli.StringSliceFlag{
+				Name:    flagStatsWindows,
+				Usage:   "The sliding windows over which statistics are collected",
+				Value:   cli.NewStringSlice("10s", "1m", "5m"),
+				EnvVars: []string{strcase.ToSNAKE(flagStatsWindows)},
+			},
+			&cli.DurationFlag{
+				Name:    flagSummaryInterval,
+				Usage:   "The interval at which a statistics summary is logged. A zero interval disables the summary",
+				Value:   10 * time.Second,
+				EnvVars: []string{strcase.ToSNAKE(flagSummaryInterval)},
+			},
+			&cli.StringFlag{
+				Name:    flagSecret,
+				Usage:   "The shared secret used to authenticate messages. Messages are not authenticated if empty",
+				EnvVars: []string{strcase.ToSNAKE(flagSecret)},
+			},
+		}.Merge(clientTransportFlags, cmd.LogFlags),
 		Action: runClient,
 	},
 	{
 		Name:  "server",
 		Usage: "Run the connqc server",
 		Flags: cmd.Flags{
+			&cli.StringSliceFlag{
+				Name: flagProtocol,
+				Usage: fmt.Sprintf(
+					"The protocols to listen on. Supported protocols: %s", supportedProtocols(),
+				),
+				Value:   cli.NewStringSlice(flagProtocolTCP, flagProtocolUDP),
+				EnvVars: []string{strcase.ToSNAKE(flagProtocol)},
+			},
 			&cli.StringFlag{
 				Name:    flagAddr,
 				Usage:   "The address to listen on for probe messages",
 				Value:   ":8123",
 				EnvVars: []string{strcase.ToSNAKE(flagAddr)},
 			},
+			&cli.StringFlag{
+				Name: flagMode,
+				Usage: fmt.Sprintf(
+					"The mode in which the server answers requests. Supported modes: '%s', '%s'", flagModeEcho, flagModeMessage,
+				),
+				Value:   flagModeEcho,
+				EnvVars: []string{strcase.ToSNAKE(flagMode)},
+			},
 			&cli.IntFlag{
 				Name:    flagBufferSize,
 				Usage:   "The size of the read buffer used by the server",
 				Value:   512,
 				EnvVars: []string{strcase.ToSNAKE(flagBufferSize)},
 			},
+			&cli.BoolFlag{
+				Name:    flagUDPCookies,
+				Usage:   "Require UDP clients to complete a cookie exchange before answering, preventing reflection to spoofed addresses",
+				EnvVars: []string{strcase.ToSNAKE(flagUDPCookies)},
+			},
 			&cli.DurationFlag{
 				Name:    flagReadTimeout,
 				Usage:   "The duration after which the server should timeout when reading from a connection",
@@ -105,9 +313,122 @@ var commands = []*cli.Command{
 				Value:   5 * time.Second,
 				EnvVars: []string{strcase.ToSNAKE(flagWriteTimeout)},
 			},
+			&cli.StringFlag{
+				Name:    flagSecret,
+				Usage:   "The shared secret used to authenticate messages. Messages are not authenticated if empty",
+				EnvVars: []string{strcase.ToSNAKE(flagSecret)},
+			},
+			&cli.DurationFlag{
+				Name:    flagReplayWindow,
+				Usage:   "The maximum age of authenticated probes before they are considered replayed",
+				Value:   30 * time.Second,
+				EnvVars: []string{strcase.ToSNAKE(flagReplayWindow)},
+			},
+			&cli.StringFlag{
+				Name:    flagTLSCert,
+				Usage:   "The server certificate file used by the TLS transport",
+				EnvVars: []string{strcase.ToSNAKE(flagTLSCert)},
+			},
+			&cli.StringFlag{
+				Name:    flagTLSKey,
+				Usage:   "The key file of the server certificate",
+				EnvVars: []string{strcase.ToSNAKE(flagTLSKey)},
+			},
+			&cli.StringFlag{
+				Name:    flagTLSClientCA,
+				Usage:   "The CA certificates file used to verify client certificates. Client certificates are required if set",
+				EnvVars: []string{strcase.ToSNAKE(flagTLSClientCA)},
+			},
+			&cli.BoolFlag{
+				Name:    flagGenerateSelfSigned,
+				Usage:   "Generate a self-signed server certificate for the TLS transports, meant for quick tests",
+				EnvVars: []string{strcase.ToSNAKE(flagGenerateSelfSigned)},
+			},
+			&cli.StringFlag{
+				Name:    flagWSPath,
+				Usage:   "The HTTP path on which WebSocket connections are upgraded",
+				Value:   ws.DefaultPath,
+				EnvVars: []string{strcase.ToSNAKE(flagWSPath)},
+			},
 		}.Merge(cmd.LogFlags),
 		Action: runServer,
 	},
+	{
+		Name:  "check",
+		Usage: "Check the connection quality once, printing a machine-readable result",
+		Description: fmt.Sprintf(
+			"Sends a burst of probe messages over every protocol and exits with the status "+
+				"of the Nagios plugin API: %d for OK, %d for WARNING, %d for CRITICAL and %d for UNKNOWN.",
+			checkOK, checkWarning, checkCritical, checkUnknown,
+		),
+		Flags: cmd.Flags{
+			&cli.StringSliceFlag{
+				Name: flagProtocol,
+				Usage: fmt.Sprintf(
+					"The protocols to check. Supported protocols: %s", supportedProtocols(),
+				),
+				Value:   cli.NewStringSlice(flagProtocolTCP, flagProtocolUDP),
+				EnvVars: []string{strcase.ToSNAKE(flagProtocol)},
+			},
+			&cli.StringFlag{
+				Name:     flagAddr,
+				Usage:    "The address of the connqc server",
+				Required: true,
+				EnvVars:  []string{strcase.ToSNAKE(flagAddr)},
+			},
+			&cli.StringFlag{
+				Name: flagFormat,
+				Usage: fmt.Sprintf(
+					"The format of the result. Supported formats: '%s', '%s'", flagFormatNagios, flagFormatJSON,
+				),
+				Value:   flagFormatNagios,
+				EnvVars: []string{strcase.ToSNAKE(flagFormat)},
+			},
+			&cli.Uint64Flag{
+				Name:    flagCount,
+				Usage:   "The number of probe messages to send over every protocol",
+				Value:   10,
+				EnvVars: []string{strcase.ToSNAKE(flagCount)},
+			},
+			&cli.DurationFlag{
+				Name:    flagSendInterval,
+				Usage:   "The interval at which to send probe messages to the server",
+				Value:   100 * time.Millisecond,
+				EnvVars: []string{strcase.ToSNAKE(flagSendInterval)},
+			},
+			&cli.DurationFlag{
+				Name:    flagProbeTimeout,
+				Usage:   "The duration after which a probe message without response is considered lost",
+				Value:   time.Second,
+				EnvVars: []string{strcase.ToSNAKE(flagProbeTimeout)},
+			},
+			&cli.DurationFlag{
+				Name:    flagTimeout,
+				Usage:   "The duration after which the check of a protocol is given up on",
+				Value:   10 * time.Second,
+				EnvVars: []string{strcase.ToSNAKE(flagTimeout)},
+			},
+			&cli.StringFlag{
+				Name:    flagSecret,
+				Usage:   "The shared secret used to authenticate messages. Messages are not authenticated if empty",
+				EnvVars: []string{strcase.ToSNAKE(flagSecret)},
+			},
+		}.Merge(
+			clientTransportFlags,
+			checkThresholdsFlags("warning", warningThresholdFlags),
+			checkThresholdsFlags("critical", criticalThresholdFlags),
+		),
+		Action: runCheck,
+	},
+}
+
+// supportedProtocols returns the quoted names of all registered transports.
+func supportedProtocols() string {
+	names := transport.Names()
+	for i, name := range names {
+		names[i] = "'" + name + "'"
+	}
+	return strings.Join(names, ", ")
 }
 
 func main() {
@@ -135,8 +456,20 @@ func realMain() (code int) {
 	defer cancel()
 
 	if err := app.RunContext(ctx, os.Args); err != nil {
-		ui.Error(err.Error())
-		return 1
+		var exitErr exitError
+		if !errors.As(err, &exitErr) || exitErr.err != nil {
+			ui.Error(err.Error())
+		}
+		return exitCode(err)
 	}
 	return 0
 }
+
+// exitCode returns the exit code of the process ending with the given error.
+func exitCode(err error) int {
+	var exitErr exitError
+	if errors.As(err, &exitErr) {
+		return exitErr.code
+	}
+	return 1
+}
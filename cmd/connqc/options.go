@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+
+	"github.com/nitrado/connqc"
+	"github.com/nitrado/connqc/proxy"
+	"github.com/nitrado/connqc/quic"
+	tlstransport "github.com/nitrado/connqc/tls"
+	"github.com/nitrado/connqc/ws"
+	"github.com/urfave/cli/v2"
+)
+
+// authOpts returns the message authentication options for the command.
+func authOpts(c *cli.Context) []connqc.Option {
+	var opts []connqc.Option
+	if secret := c.String(flagSecret); secret != "" {
+		opts = append(opts, connqc.WithSecret([]byte(secret)))
+	}
+	return opts
+}
+
+// transportOpts returns the TLS, transport and proxy options of the client commands.
+func transportOpts(c *cli.Context) ([]connqc.Option, error) {
+	tlsCfg, err := clientTLSConfig(c)
+	if err != nil {
+		return nil, err
+	}
+	opts := []connqc.Option{
+		connqc.WithTransport(flagProtocolTLS, tlstransport.NewTransport(tlsCfg)),
+		connqc.WithTransport(flagProtocolWS, ws.NewTransport(ws.WithPath(c.String(flagWSPath)))),
+		connqc.WithTransport(flagProtocolWSS, ws.NewTransport(ws.WithPath(c.String(flagWSPath)), ws.WithTLSConfig(tlsCfg))),
+		connqc.WithTransport(flagProtocolQUIC, quic.NewTransport(tlsCfg)),
+		connqc.WithTransport(flagProtocolQUICStream, quic.NewTransport(tlsCfg, quic.WithStream())),
+	}
+
+	if v := c.String(flagProxy); v != "" {
+		u, err := url.Parse(v)
+		if err != nil {
+			return nil, fmt.Errorf("parsing proxy url: %w", err)
+		}
+		d, err := proxy.FromURL(u, &net.Dialer{})
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, connqc.WithDialer(d))
+	}
+	return opts, nil
+}
@@ -1,15 +1,20 @@
 package main
 
 import (
+	"crypto/rand"
 	"errors"
+	"fmt"
 	"net"
 	"sync"
 
 	"github.com/hamba/cmd/v2"
 	lctx "github.com/hamba/logger/v2/ctx"
 	"github.com/nitrado/connqc"
-	"github.com/nitrado/connqc/tcp"
+	"github.com/nitrado/connqc/quic"
+	tlstransport "github.com/nitrado/connqc/tls"
+	"github.com/nitrado/connqc/transport"
 	"github.com/nitrado/connqc/udp"
+	"github.com/nitrado/connqc/ws"
 	"github.com/urfave/cli/v2"
 )
 
@@ -21,20 +26,83 @@ func runServer(c *cli.Context) error { //nolint:funlen // Keep it simple and rea
 		return err
 	}
 
+	var mode connqc.Mode
+	switch c.String(flagMode) {
+	case flagModeEcho:
+		mode = connqc.ModeEcho
+	case flagModeMessage:
+		mode = connqc.ModeMessage
+	default:
+		return fmt.Errorf("unsupported mode: %s", c.String(flagMode))
+	}
+
 	bufferSize := c.Int(flagBufferSize)
 	readTimeout := c.Duration(flagReadTimeout)
 	writeTimeout := c.Duration(flagWriteTimeout)
 
-	srv := connqc.NewServer(bufferSize, readTimeout, writeTimeout, log)
-
-	tcpSrv, err := tcp.NewServer(srv)
+	opts := append(authOpts(c),
+		connqc.WithReplayWindow(c.Duration(flagReplayWindow)),
+		connqc.WithMode(mode),
+		connqc.WithBufferSize(bufferSize),
+		connqc.WithReadTimeout(readTimeout),
+		connqc.WithWriteTimeout(writeTimeout),
+	)
+	srv, err := connqc.NewServer(log, opts...)
 	if err != nil {
 		return err
 	}
 
-	udpSrv, err := udp.NewServer(srv)
-	if err != nil {
-		return err
+	var udpOpts []udp.Option
+	if c.Bool(flagUDPCookies) {
+		secret := make([]byte, 32)
+		if _, err = rand.Read(secret); err != nil {
+			return fmt.Errorf("generating cookie secret: %w", err)
+		}
+		udpOpts = append(udpOpts, udp.WithCookies(secret))
+	}
+
+	protocols := c.StringSlice(flagProtocol)
+	transports := make([]transport.Transport, 0, len(protocols))
+	names := make([]string, 0, len(protocols))
+	quicIdx := -1
+	for _, protocol := range protocols {
+		t, ok := transport.Lookup(protocol)
+		if !ok {
+			return fmt.Errorf("unsupported protocol: %s", protocol)
+		}
+		switch protocol {
+		case flagProtocolUDP:
+			t = udp.NewTransport(udpOpts...)
+		case flagProtocolTLS:
+			cfg, err := serverTLSConfig(c, c.String(flagAddr))
+			if err != nil {
+				return err
+			}
+			t = tlstransport.NewTransport(cfg)
+		case flagProtocolWS:
+			t = ws.NewTransport(ws.WithPath(c.String(flagWSPath)))
+		case flagProtocolWSS:
+			cfg, err := serverTLSConfig(c, c.String(flagAddr))
+			if err != nil {
+				return err
+			}
+			t = ws.NewTransport(ws.WithPath(c.String(flagWSPath)), ws.WithTLSConfig(cfg))
+		case flagProtocolQUIC, flagProtocolQUICStream:
+			// A QUIC listener serves both datagrams and streams, so a
+			// second listener on the same address is not started.
+			if quicIdx >= 0 {
+				names[quicIdx] += "," + protocol
+				continue
+			}
+			cfg, err := serverTLSConfig(c, c.String(flagAddr))
+			if err != nil {
+				return err
+			}
+			t = quic.NewTransport(cfg)
+			quicIdx = len(transports)
+		}
+		transports = append(transports, t)
+		names = append(names, protocol)
 	}
 
 	addr := c.String(flagAddr)
@@ -43,32 +111,30 @@ func runServer(c *cli.Context) error { //nolint:funlen // Keep it simple and rea
 
 	log.Info("Starting server",
 		lctx.Str("addr", addr),
+		lctx.Strs("protocols", protocols),
+		lctx.Str("mode", mode.String()),
 		lctx.Int("buffer_size", bufferSize),
 		lctx.Duration("read_timeout", readTimeout),
 		lctx.Duration("write_timeout", writeTimeout),
+		lctx.Bool("authenticated", c.String(flagSecret) != ""),
+		lctx.Bool("udp_cookies", c.Bool(flagUDPCookies)),
+		lctx.Bool("tls_client_auth", c.String(flagTLSClientCA) != ""),
 	)
 
-	grp.Add(1)
-	go func() {
-		defer grp.Done()
+	for i, t := range transports {
+		log := log.With(lctx.Str("protocol", names[i]))
 
-		if err := tcpSrv.Listen(ctx, addr); err != nil && !errors.Is(err, net.ErrClosed) {
-			log.Error("Server error", lctx.Str("protocol", "tcp"), lctx.Err(err))
-			return
-		}
-		log.Info("TCP server stopped")
-	}()
+		grp.Add(1)
+		go func() {
+			defer grp.Done()
 
-	grp.Add(1)
-	go func() {
-		defer grp.Done()
-
-		if err := udpSrv.Listen(ctx, addr); err != nil && !errors.Is(err, net.ErrClosed) {
-			log.Error("Server error", lctx.Str("protocol", "udp"), lctx.Err(err))
-			return
-		}
-		log.Info("UDP server stopped")
-	}()
+			if err := t.Listen(ctx, addr, srv); err != nil && !errors.Is(err, net.ErrClosed) {
+				log.Error("Server error", lctx.Err(err))
+				return
+			}
+			log.Info("Server stopped")
+		}()
+	}
 
 	<-ctx.Done()
 
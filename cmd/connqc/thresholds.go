@@ -0,0 +1,170 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nitrado/connqc"
+	"github.com/urfave/cli/v2"
+)
+
+// exitCodeBreach is the exit code of runs breaching a threshold,
+// distinguishing them from runs that failed.
+const exitCodeBreach = 2
+
+// exitError is an error ending the process with the given exit code.
+// Without an underlying error, the process exits silently.
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e exitError) Error() string {
+	if e.err == nil {
+		return fmt.Sprintf("exit code %d", e.code)
+	}
+	return e.err.Error()
+}
+
+func (e exitError) Unwrap() error {
+	return e.err
+}
+
+// threshold is a limit on a metric of a run.
+type threshold struct {
+	name   string
+	metric string
+	limit  float64
+	value  func(connqc.Report) float64
+	format func(float64) string
+}
+
+// breach is a threshold exceeded by a run.
+type breach struct {
+	threshold
+
+	actual float64
+}
+
+// String describes the breach and by how much the threshold was exceeded.
+func (b breach) String() string {
+	return fmt.Sprintf("%s %s exceeds %s=%s by %s",
+		b.metric, b.format(b.actual), b.name, b.format(b.limit), b.format(b.actual-b.limit),
+	)
+}
+
+// thresholdFlags names the flags of a set of thresholds.
+type thresholdFlags struct {
+	loss   string
+	p99    string
+	jitter string
+	outage string
+}
+
+var maxThresholdFlags = thresholdFlags{
+	loss:   flagMaxLoss,
+	p99:    flagMaxP99,
+	jitter: flagMaxJitter,
+	outage: flagMaxOutage,
+}
+
+// thresholds returns the thresholds configured with the given flags.
+// Thresholds that are not set are not returned.
+func thresholds(c *cli.Context, flags thresholdFlags) ([]threshold, error) {
+	var ts []threshold
+	if v := c.String(flags.loss); v != "" {
+		limit, err := parseRatio(v)
+		if err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", flags.loss, err)
+		}
+		ts = append(ts, threshold{
+			name:   flags.loss,
+			metric: "loss",
+			limit:  limit,
+			value:  func(r connqc.Report) float64 { return r.Totals.Loss() },
+			format: formatPercent,
+		})
+	}
+
+	durations := []struct {
+		name   string
+		metric string
+		value  func(connqc.Report) time.Duration
+	}{
+		{name: flags.p99, metric: "rtt p99", value: func(r connqc.Report) time.Duration { return r.Totals.RTT.P99 }},
+		{name: flags.jitter, metric: "jitter", value: func(r connqc.Report) time.Duration { return r.Totals.Jitter }},
+		{name: flags.outage, metric: "longest outage", value: func(r connqc.Report) time.Duration { return r.LongestOutage }},
+	}
+	for _, d := range durations {
+		limit := c.Duration(d.name)
+		if limit <= 0 {
+			continue
+		}
+		ts = append(ts, threshold{
+			name:   d.name,
+			metric: d.metric,
+			limit:  float64(limit),
+			value:  func(r connqc.Report) float64 { return float64(d.value(r)) },
+			format: formatDuration,
+		})
+	}
+	return ts, nil
+}
+
+// checkThresholds returns the thresholds breached by the report.
+func checkThresholds(ts []threshold, r connqc.Report) []breach {
+	var breaches []breach
+	for _, t := range ts {
+		if actual := t.value(r); actual > t.limit {
+			breaches = append(breaches, breach{threshold: t, actual: actual})
+		}
+	}
+	return breaches
+}
+
+// printBreaches prints the breached thresholds, returning an error
+// exiting with the breach exit code if any threshold was breached.
+func printBreaches(w io.Writer, breaches []breach) error {
+	if len(breaches) == 0 {
+		return nil
+	}
+
+	names := make([]string, 0, len(breaches))
+	for _, b := range breaches {
+		_, _ = fmt.Fprintf(w, "threshold breached: %s\n", b)
+		names = append(names, b.name)
+	}
+	return exitError{
+		code: exitCodeBreach,
+		err:  fmt.Errorf("thresholds breached: %s", strings.Join(names, ", ")),
+	}
+}
+
+// parseRatio parses a ratio given either as a fraction, such as "0.005",
+// or as a percentage, such as "0.5%".
+func parseRatio(s string) (float64, error) {
+	pct, isPct := strings.CutSuffix(s, "%")
+	v, err := strconv.ParseFloat(pct, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid ratio %q", s)
+	}
+	if isPct {
+		v /= 100
+	}
+	if v < 0 || v > 1 {
+		return 0, errors.New("ratio must be between 0 and 100%")
+	}
+	return v, nil
+}
+
+func formatPercent(v float64) string {
+	return strconv.FormatFloat(100*v, 'g', 4, 64) + "%"
+}
+
+func formatDuration(v float64) string {
+	return time.Duration(v).Round(time.Microsecond).String()
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/nitrado/connqc"
+	"github.com/nitrado/connqc/stats"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli/v2"
+)
+
+func TestParseRatio(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		want    float64
+		wantErr string
+	}{
+		{name: "ratio", in: "0.005", want: 0.005},
+		{name: "percent", in: "0.5%", want: 0.005},
+		{name: "zero", in: "0", want: 0},
+		{name: "whole percent", in: "100%", want: 1},
+		{name: "invalid", in: "half", wantErr: `invalid ratio "half"`},
+		{name: "invalid percent", in: "%", wantErr: `invalid ratio "%"`},
+		{name: "negative", in: "-0.1", wantErr: "ratio must be between 0 and 100%"},
+		{name: "above one", in: "1.5", wantErr: "ratio must be between 0 and 100%"},
+		{name: "above hundred percent", in: "101%", wantErr: "ratio must be between 0 and 100%"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := parseRatio(test.in)
+
+			if test.wantErr != "" {
+				assert.EqualError(t, err, test.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.InDelta(t, test.want, got, 1e-12)
+		})
+	}
+}
+
+func TestCheckThresholds(t *testing.T) {
+	report := connqc.Report{
+		Totals: stats.Summary{
+			Received: 98,
+			Lost:     2,
+			RTT:      stats.RTT{P99: 30 * time.Millisecond},
+			Jitter:   4 * time.Millisecond,
+		},
+		LongestOutage: 2 * time.Second,
+	}
+
+	tests := []struct {
+		name  string
+		flags map[string]string
+		want  []string
+	}{
+		{
+			name: "no thresholds",
+		},
+		{
+			name:  "loss within",
+			flags: map[string]string{flagMaxLoss: "2%"},
+		},
+		{
+			name:  "loss",
+			flags: map[string]string{flagMaxLoss: "1%"},
+			want:  []string{"loss 2% exceeds max-loss=1% by 1%"},
+		},
+		{
+			name:  "p99",
+			flags: map[string]string{flagMaxP99: "20ms"},
+			want:  []string{"rtt p99 30ms exceeds max-p99=20ms by 10ms"},
+		},
+		{
+			name:  "jitter",
+			flags: map[string]string{flagMaxJitter: "1ms"},
+			want:  []string{"jitter 4ms exceeds max-jitter=1ms by 3ms"},
+		},
+		{
+			name:  "outage",
+			flags: map[string]string{flagMaxOutage: "500ms"},
+			want:  []string{"longest outage 2s exceeds max-outage=500ms by 1.5s"},
+		},
+		{
+			name: "all",
+			flags: map[string]string{
+				flagMaxLoss:   "0.01",
+				flagMaxP99:    "20ms",
+				flagMaxJitter: "5ms",
+				flagMaxOutage: "1s",
+			},
+			want: []string{
+				"loss 2% exceeds max-loss=1% by 1%",
+				"rtt p99 30ms exceeds max-p99=20ms by 10ms",
+				"longest outage 2s exceeds max-outage=1s by 1s",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := newThresholdContext(t, test.flags)
+
+			ts, err := thresholds(c, maxThresholdFlags)
+			require.NoError(t, err)
+			breaches := checkThresholds(ts, report)
+
+			got := make([]string, 0, len(breaches))
+			for _, b := range breaches {
+				got = append(got, b.String())
+			}
+			assert.Equal(t, append([]string{}, test.want...), got)
+		})
+	}
+}
+
+func TestThresholds_InvalidLoss(t *testing.T) {
+	c := newThresholdContext(t, map[string]string{flagMaxLoss: "lots"})
+
+	_, err := thresholds(c, maxThresholdFlags)
+
+	assert.EqualError(t, err, `parsing max-loss: invalid ratio "lots"`)
+}
+
+func TestPrintBreaches(t *testing.T) {
+	c := newThresholdContext(t, map[string]string{flagMaxLoss: "1%", flagMaxP99: "20ms"})
+	ts, err := thresholds(c, maxThresholdFlags)
+	require.NoError(t, err)
+	breaches := checkThresholds(ts, connqc.Report{
+		Totals: stats.Summary{Received: 9, Lost: 1, RTT: stats.RTT{P99: 25 * time.Millisecond}},
+	})
+
+	var buf bytes.Buffer
+	err = printBreaches(&buf, breaches)
+
+	want := "threshold breached: loss 10% exceeds max-loss=1% by 9%\n" +
+		"threshold breached: rtt p99 25ms exceeds max-p99=20ms by 5ms\n"
+	assert.Equal(t, want, buf.String())
+	require.EqualError(t, err, "thresholds breached: max-loss, max-p99")
+	// The breach exit code survives wrapping by the command.
+	assert.Equal(t, exitCodeBreach, exitCode(fmt.Errorf("client: %w", err)))
+}
+
+func TestPrintBreaches_NoBreaches(t *testing.T) {
+	var buf bytes.Buffer
+	err := printBreaches(&buf, nil)
+
+	require.NoError(t, err)
+	assert.Empty(t, buf.String())
+}
+
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{name: "error", err: errors.New("test"), want: 1},
+		{name: "breach", err: exitError{code: exitCodeBreach, err: errors.New("test")}, want: 2},
+		{name: "silent", err: exitError{code: 3}, want: 3},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.want, exitCode(test.err))
+		})
+	}
+}
+
+func newThresholdContext(t *testing.T, flags map[string]string) *cli.Context {
+	t.Helper()
+
+	set := flag.NewFlagSet("test", flag.ContinueOnError)
+	set.String(flagMaxLoss, "", "")
+	set.Duration(flagMaxP99, 0, "")
+	set.Duration(flagMaxJitter, 0, "")
+	set.Duration(flagMaxOutage, 0, "")
+	for name, v := range flags {
+		require.NoError(t, set.Set(name, v))
+	}
+	return cli.NewContext(cli.NewApp(), set, nil)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+
+	tlstransport "github.com/nitrado/connqc/tls"
+	"github.com/urfave/cli/v2"
+)
+
+// clientTLSConfig returns the TLS configuration of the client command.
+func clientTLSConfig(c *cli.Context) (*tls.Config, error) {
+	cfg := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: c.Bool(flagInsecure), //nolint:gosec // Explicitly requested by the user.
+	}
+
+	if caFile := c.String(flagTLSCA); caFile != "" {
+		pool, err := loadCertPool(caFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.RootCAs = pool
+	}
+
+	certFile, keyFile := c.String(flagTLSCert), c.String(flagTLSKey)
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading client certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	return cfg, nil
+}
+
+// serverTLSConfig returns the TLS configuration of the server command.
+//
+// Clients must present a certificate signed by the client CA, if one is configured.
+func serverTLSConfig(c *cli.Context, addr string) (*tls.Config, error) {
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	certFile, keyFile := c.String(flagTLSCert), c.String(flagTLSKey)
+	switch {
+	case c.Bool(flagGenerateSelfSigned):
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, fmt.Errorf("parsing address: %w", err)
+		}
+		hosts := []string{"localhost", "127.0.0.1", "::1"}
+		if host != "" {
+			hosts = []string{host}
+		}
+
+		cert, err := tlstransport.GenerateSelfSigned(hosts...)
+		if err != nil {
+			return nil, fmt.Errorf("generating self-signed certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	case certFile != "" || keyFile != "":
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading server certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	default:
+		return nil, fmt.Errorf("tls requires --%s and --%s, or --%s", flagTLSCert, flagTLSKey, flagGenerateSelfSigned)
+	}
+
+	if caFile := c.String(flagTLSClientCA); caFile != "" {
+		pool, err := loadCertPool(caFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return cfg, nil
+}
+
+func loadCertPool(file string) (*x509.CertPool, error) {
+	b, err := os.ReadFile(file) //nolint:gosec // The file is configured by the user.
+	if err != nil {
+		return nil, fmt.Errorf("reading CA certificates: %w", err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(b) {
+		return nil, errors.New("no CA certificates found in " + file)
+	}
+	return pool, nil
+}
@@ -0,0 +1,312 @@
+package connqc
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/nitrado/connqc/transport"
+)
+
+// Option configures a client or server.
+type Option func(*config)
+
+type config struct {
+	backoff         time.Duration
+	sendInterval    time.Duration
+	sendPattern     Pattern
+	sendJitter      time.Duration
+	idleTimeout     time.Duration
+	readTimeout     time.Duration
+	writeTimeout    time.Duration
+	bufferSize      int
+	probeTimeout    time.Duration
+	maxInFlight     int
+	count           uint64
+	drainTimeout    time.Duration
+	statsWindows    []time.Duration
+	summaryInterval time.Duration
+	observers       []Observer
+	dialer          transport.Dialer
+	transports      map[string]transport.Transport
+	mode            Mode
+	secret          []byte
+	replayWindow    time.Duration
+}
+
+func defaultConfig() config {
+	return config{
+		backoff:         time.Second,
+		sendInterval:    time.Second,
+		idleTimeout:     10 * time.Second,
+		readTimeout:     2 * time.Second,
+		writeTimeout:    5 * time.Second,
+		bufferSize:      512,
+		probeTimeout:    2 * time.Second,
+		maxInFlight:     4096,
+		drainTimeout:    2 * time.Second,
+		statsWindows:    []time.Duration{10 * time.Second, time.Minute, 5 * time.Minute},
+		summaryInterval: 10 * time.Second,
+		replayWindow:    30 * time.Second,
+		dialer:          &net.Dialer{},
+	}
+}
+
+func newConfig(opts []Option) config {
+	cfg := defaultConfig()
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return cfg
+}
+
+// validateClient checks the configuration of a client.
+func (c config) validateClient() error {
+	switch {
+	case c.backoff < 0:
+		return fmt.Errorf("backoff must not be negative, got %s", c.backoff)
+	case c.sendInterval <= 0:
+		return fmt.Errorf("send interval must be greater than zero, got %s", c.sendInterval)
+	case c.sendPattern != PatternFixed && c.sendPattern != PatternJitter && c.sendPattern != PatternPoisson:
+		return fmt.Errorf("unsupported send pattern %s", c.sendPattern)
+	case c.sendJitter < 0 || c.sendJitter > c.sendInterval/2:
+		return fmt.Errorf("send jitter must be between zero and half the send interval, got %s", c.sendJitter)
+	case c.idleTimeout <= 0:
+		return fmt.Errorf("idle timeout must be greater than zero, got %s", c.idleTimeout)
+	case c.writeTimeout <= 0:
+		return fmt.Errorf("write timeout must be greater than zero, got %s", c.writeTimeout)
+	case c.probeTimeout <= 0:
+		return fmt.Errorf("probe timeout must be greater than zero, got %s", c.probeTimeout)
+	case c.maxInFlight <= 0:
+		return fmt.Errorf("max in-flight probes must be greater than zero, got %d", c.maxInFlight)
+	case c.drainTimeout < 0:
+		return fmt.Errorf("drain timeout must not be negative, got %s", c.drainTimeout)
+	case len(c.statsWindows) == 0:
+		return errors.New("at least one stats window is required")
+	case c.dialer == nil:
+		return errors.New("dialer cannot be nil")
+	case c.summaryInterval < 0:
+		return fmt.Errorf("summary interval must not be negative, got %s", c.summaryInterval)
+	}
+	for _, w := range c.statsWindows {
+		if w <= 0 {
+			return fmt.Errorf("stats windows must be greater than zero, got %s", w)
+		}
+	}
+	return c.validateAuth()
+}
+
+// validateServer checks the configuration of a server.
+func (c config) validateServer() error {
+	minBufferSize := timedProbeHeaderSize
+	if c.secret != nil {
+		minBufferSize += macSize
+	}
+
+	switch {
+	case c.bufferSize < minBufferSize:
+		return fmt.Errorf("buffer size must be at least the probe header size of %d bytes, got %d", minBufferSize, c.bufferSize)
+	case c.readTimeout <= 0:
+		return fmt.Errorf("read timeout must be greater than zero, got %s", c.readTimeout)
+	case c.writeTimeout <= 0:
+		return fmt.Errorf("write timeout must be greater than zero, got %s", c.writeTimeout)
+	case c.mode != ModeEcho && c.mode != ModeMessage:
+		return fmt.Errorf("unsupported mode %s", c.mode)
+	}
+	return c.validateAuth()
+}
+
+func (c config) validateAuth() error {
+	if c.secret == nil {
+		return nil
+	}
+
+	switch {
+	case len(c.secret) == 0:
+		return errors.New("secret must not be empty")
+	case c.replayWindow <= 0:
+		return fmt.Errorf("replay window must be greater than zero, got %s", c.replayWindow)
+	}
+	return nil
+}
+
+// WithBackoff sets the duration a client waits for before reconnecting to the server.
+func WithBackoff(d time.Duration) Option {
+	return func(c *config) {
+		c.backoff = d
+	}
+}
+
+// WithSendInterval sets the interval at which a client sends probes.
+func WithSendInterval(d time.Duration) Option {
+	return func(c *config) {
+		c.sendInterval = d
+	}
+}
+
+// WithSendPattern sets the pattern in which a client spaces its probes.
+// By default, probes are sent at a fixed interval.
+func WithSendPattern(p Pattern) Option {
+	return func(c *config) {
+		c.sendPattern = p
+	}
+}
+
+// WithSendJitter sets the maximum random offset of each probe from its
+// interval when sending with the jitter pattern. The jitter must not
+// exceed half the send interval.
+func WithSendJitter(d time.Duration) Option {
+	return func(c *config) {
+		c.sendJitter = d
+	}
+}
+
+// WithIdleTimeout sets the duration without any response after which
+// a client with outstanding probes reconnects to the server.
+func WithIdleTimeout(d time.Duration) Option {
+	return func(c *config) {
+		c.idleTimeout = d
+	}
+}
+
+// WithReadTimeout sets the duration after which a server times out
+// when reading from a connection.
+func WithReadTimeout(d time.Duration) Option {
+	return func(c *config) {
+		c.readTimeout = d
+	}
+}
+
+// WithWriteTimeout sets the duration after which a client or server
+// times out when writing to a connection.
+func WithWriteTimeout(d time.Duration) Option {
+	return func(c *config) {
+		c.writeTimeout = d
+	}
+}
+
+// WithBufferSize sets the size of the read buffer used by a server.
+// The buffer must be able to hold at least the header of a probe.
+func WithBufferSize(size int) Option {
+	return func(c *config) {
+		c.bufferSize = size
+	}
+}
+
+// WithProbeTimeout sets the duration a client waits for the response
+// to a probe before considering the probe lost.
+func WithProbeTimeout(d time.Duration) Option {
+	return func(c *config) {
+		c.probeTimeout = d
+	}
+}
+
+// WithCount sets the number of probes after which a client stops sending.
+// Once the outcome of all outstanding probes is known, the client returns.
+// A zero count sends probes until the client is stopped.
+func WithCount(n uint64) Option {
+	return func(c *config) {
+		c.count = n
+	}
+}
+
+// WithMaxInFlight sets the maximum number of probes a client awaits a response for.
+// Once reached, the oldest probe is evicted and considered lost, bounding the
+// memory used when the server stops answering.
+func WithMaxInFlight(n int) Option {
+	return func(c *config) {
+		c.maxInFlight = n
+	}
+}
+
+// WithDrainTimeout sets the grace period a client waits on shutdown for the
+// responses to outstanding probes. A zero timeout stops the client immediately.
+func WithDrainTimeout(d time.Duration) Option {
+	return func(c *config) {
+		c.drainTimeout = d
+	}
+}
+
+// WithStatsWindows sets the sliding windows over which a client collects statistics.
+func WithStatsWindows(windows ...time.Duration) Option {
+	return func(c *config) {
+		c.statsWindows = windows
+	}
+}
+
+// WithSummaryInterval sets the interval at which a client logs a summary
+// of its statistics. A zero interval disables the summary.
+func WithSummaryInterval(d time.Duration) Option {
+	return func(c *config) {
+		c.summaryInterval = d
+	}
+}
+
+// WithObserver adds an observer receiving the events of a client.
+//
+// Events are also logged, making logging one observer among others.
+func WithObserver(o Observer) Option {
+	return func(c *config) {
+		c.observers = append(c.observers, o)
+	}
+}
+
+// WithTransport sets the transport a client uses for the given protocol name,
+// taking precedence over the transport registered under the same name.
+func WithTransport(name string, t transport.Transport) Option {
+	return func(c *config) {
+		if c.transports == nil {
+			c.transports = map[string]transport.Transport{}
+		}
+		c.transports[name] = t
+	}
+}
+
+// WithDialer sets the dialer a client's transports establish their network
+// connections with, such as a proxy dialer. By default, connections are
+// established directly.
+func WithDialer(d transport.Dialer) Option {
+	return func(c *config) {
+		c.dialer = d
+	}
+}
+
+// WithMode sets the mode in which a server answers requests.
+// By default, servers run in echo mode.
+func WithMode(mode Mode) Option {
+	return func(c *config) {
+		c.mode = mode
+	}
+}
+
+// WithSecret authenticates all messages with an HMAC keyed with the given shared secret.
+//
+// Servers drop messages that are not authenticated, while clients
+// reject responses that are not authenticated or are replayed.
+func WithSecret(secret []byte) Option {
+	return func(c *config) {
+		c.secret = secret
+	}
+}
+
+// WithReplayWindow sets the maximum age of authenticated probes accepted by a server.
+// Older probes are considered replayed and are rejected.
+//
+// Servers compare the probe age against their own clock, so the clocks
+// of the client and server must not drift apart by more than the window.
+// Clients do not need a window, as they only accept responses to the
+// probes they remember sending.
+func WithReplayWindow(d time.Duration) Option {
+	return func(c *config) {
+		c.replayWindow = d
+	}
+}
+
+// codecOpts returns the codec options for the given secret.
+func codecOpts(secret []byte) []CodecOption {
+	if secret == nil {
+		return nil
+	}
+	return []CodecOption{WithHMAC(secret)}
+}
@@ -0,0 +1,5 @@
+// Package connqctest provides utilities for end-to-end tests against a connqc server.
+//
+// It starts an in-process server on an ephemeral loopback port, much like
+// httptest.NewServer, and records the messages the server received.
+package connqctest
@@ -0,0 +1,340 @@
+package connqctest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+
+	"github.com/hamba/logger/v2"
+	"github.com/nitrado/connqc"
+	"github.com/nitrado/connqc/tcp"
+	"github.com/nitrado/connqc/transport"
+	"github.com/nitrado/connqc/udp"
+)
+
+// listenAttempts is the number of ephemeral ports tried
+// before giving up on finding one free for all protocols.
+const listenAttempts = 10
+
+// Option configures a test server.
+type Option func(*config)
+
+type config struct {
+	protocols  []string
+	secret     []byte
+	serverOpts []connqc.Option
+}
+
+// WithProtocols sets the protocols the server listens on.
+// Supported protocols are "tcp" and "udp". By default, the server listens on both.
+func WithProtocols(protocols ...string) Option {
+	return func(c *config) {
+		c.protocols = protocols
+	}
+}
+
+// WithSecret authenticates all messages with an HMAC keyed with the given secret.
+//
+// Use this instead of passing connqc.WithSecret as a server option,
+// so the server can still record the messages it received.
+func WithSecret(secret []byte) Option {
+	return func(c *config) {
+		c.secret = secret
+	}
+}
+
+// WithServerOptions sets the options of the connqc server, such as its mode.
+func WithServerOptions(opts ...connqc.Option) Option {
+	return func(c *config) {
+		c.serverOpts = append(c.serverOpts, opts...)
+	}
+}
+
+// Server is a connqc server listening on a loopback address, for use in end-to-end tests.
+type Server struct {
+	// Addr is the address of the server, in the form "127.0.0.1:port".
+	// All protocols listen on the same port.
+	Addr string
+
+	srv       *connqc.Server
+	codecOpts []connqc.CodecOption
+	cancel    context.CancelFunc
+	wg        sync.WaitGroup
+
+	mu       sync.Mutex
+	conns    map[net.PacketConn]struct{}
+	received []connqc.Message
+	closed   bool
+}
+
+// NewServer starts and returns a new server.
+// The caller should call Close when finished, to shut it down.
+//
+// NewServer panics if the options are invalid or if it cannot listen on a port.
+func NewServer(opts ...Option) *Server {
+	cfg := config{protocols: []string{"tcp", "udp"}}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	serverOpts := cfg.serverOpts
+	var codecOpts []connqc.CodecOption
+	if cfg.secret != nil {
+		serverOpts = append(serverOpts, connqc.WithSecret(cfg.secret))
+		codecOpts = append(codecOpts, connqc.WithHMAC(cfg.secret), connqc.AsServer())
+	}
+
+	log := logger.New(io.Discard, logger.LogfmtFormat(), logger.Error)
+	srv, err := connqc.NewServer(log, serverOpts...)
+	if err != nil {
+		panic(fmt.Sprintf("connqctest: invalid server options: %v", err))
+	}
+
+	s := &Server{
+		srv:       srv,
+		codecOpts: codecOpts,
+		conns:     map[net.PacketConn]struct{}{},
+	}
+	if err = s.start(cfg.protocols); err != nil {
+		panic(fmt.Sprintf("connqctest: %v", err))
+	}
+	return s
+}
+
+func (s *Server) start(protocols []string) error {
+	for _, proto := range protocols {
+		if proto != "tcp" && proto != "udp" {
+			return fmt.Errorf("unsupported protocol %q", proto)
+		}
+	}
+
+	var (
+		tcpLn  net.Listener
+		udpLn  *net.UDPConn
+		lnErr  error
+		tcpSrv *tcp.Server
+		udpSrv *udp.Server
+	)
+	for range listenAttempts {
+		tcpLn, udpLn, lnErr = listen(protocols)
+		if lnErr == nil {
+			break
+		}
+	}
+	if lnErr != nil {
+		return fmt.Errorf("failed to listen on a port: %w", lnErr)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+
+	if tcpLn != nil {
+		s.Addr = tcpLn.Addr().String()
+		tcpSrv, _ = tcp.NewServer(handler{s: s})
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+
+			_ = tcpSrv.Serve(ctx, tcpLn)
+		}()
+	}
+	if udpLn != nil {
+		s.Addr = udpLn.LocalAddr().String()
+		udpSrv, _ = udp.NewServer(handler{s: s})
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+
+			_ = udpSrv.Serve(ctx, udpLn)
+		}()
+	}
+	return nil
+}
+
+// listen listens on the same ephemeral loopback port for all the given protocols.
+func listen(protocols []string) (net.Listener, *net.UDPConn, error) {
+	var (
+		tcpLn net.Listener
+		udpLn *net.UDPConn
+		port  int
+		err   error
+	)
+	for _, proto := range protocols {
+		switch proto {
+		case "tcp":
+			tcpLn, err = net.Listen("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+			if err == nil {
+				port = tcpLn.Addr().(*net.TCPAddr).Port
+			}
+		case "udp":
+			udpLn, err = net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port})
+			if err == nil {
+				port = udpLn.LocalAddr().(*net.UDPAddr).Port
+			}
+		}
+		if err != nil {
+			if tcpLn != nil {
+				_ = tcpLn.Close()
+			}
+			if udpLn != nil {
+				_ = udpLn.Close()
+			}
+			return nil, nil, err
+		}
+	}
+	return tcpLn, udpLn, nil
+}
+
+// Received returns the messages the server received, in the order
+// they were read. Messages that could not be decoded are not recorded.
+func (s *Server) Received() []connqc.Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]connqc.Message(nil), s.received...)
+}
+
+// Stats returns the message counters of the server.
+func (s *Server) Stats() connqc.ServerStats {
+	return s.srv.Stats()
+}
+
+// Close shuts down the server, closing all open connections,
+// and blocks until all its goroutines have returned.
+func (s *Server) Close() {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	s.closed = true
+	for conn := range s.conns {
+		_ = conn.Close()
+	}
+	s.mu.Unlock()
+
+	s.cancel()
+	s.wg.Wait()
+}
+
+func (s *Server) track(conn net.PacketConn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return false
+	}
+	s.conns[conn] = struct{}{}
+	s.wg.Add(1)
+	return true
+}
+
+func (s *Server) untrack(conn net.PacketConn) {
+	s.mu.Lock()
+	delete(s.conns, conn)
+	s.mu.Unlock()
+
+	s.wg.Done()
+}
+
+func (s *Server) record(msgs []connqc.Message) {
+	if len(msgs) == 0 {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.received = append(s.received, msgs...)
+}
+
+// handler records the connections and messages of the server.
+type handler struct {
+	s *Server
+}
+
+func (h handler) Serve(conn net.PacketConn) {
+	if !h.s.track(conn) {
+		_ = conn.Close()
+		return
+	}
+	defer h.s.untrack(conn)
+
+	sc, ok := conn.(interface{ Stream() bool })
+	h.s.srv.Serve(&recordingConn{
+		PacketConn: conn,
+		stream:     ok && sc.Stream(),
+		s:          h.s,
+	})
+}
+
+// recordingConn decodes the messages read from a connection.
+type recordingConn struct {
+	net.PacketConn
+
+	stream  bool
+	pending []byte
+	s       *Server
+}
+
+func (c *recordingConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	n, addr, err := c.PacketConn.ReadFrom(p)
+	if n > 0 {
+		c.s.record(c.decode(p[:n]))
+	}
+	return n, addr, err
+}
+
+// decode returns the messages completed by the given read.
+//
+// Stream reads do not preserve message boundaries, so incomplete
+// messages are kept until the rest of them has been read.
+func (c *recordingConn) decode(p []byte) []connqc.Message {
+	if !c.stream {
+		c.pending = nil
+	}
+	c.pending = append(c.pending, p...)
+
+	var msgs []connqc.Message
+	for len(c.pending) > 0 {
+		msg, err := connqc.NewDecoder(bytes.NewReader(c.pending), c.s.codecOpts...).Decode()
+		switch {
+		case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+			return msgs
+		case err != nil:
+			c.pending = nil
+			return msgs
+		}
+
+		// The encoding is deterministic, giving the length of the decoded message.
+		var buf bytes.Buffer
+		if err = connqc.NewEncoder(&buf, c.s.codecOpts...).Encode(msg); err != nil {
+			c.pending = nil
+			return msgs
+		}
+		c.pending = c.pending[buf.Len():]
+		msgs = append(msgs, msg)
+	}
+	return msgs
+}
+
+// Stream reports whether the connection is backed by a stream.
+func (c *recordingConn) Stream() bool {
+	return c.stream
+}
+
+// Identity returns the identity of the peer, if the underlying
+// connection knows it.
+func (c *recordingConn) Identity() string {
+	if id, ok := c.PacketConn.(transport.Identifier); ok {
+		return id.Identity()
+	}
+	return ""
+}
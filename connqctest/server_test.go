@@ -0,0 +1,147 @@
+package connqctest_test
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/hamba/logger/v2"
+	"github.com/nitrado/connqc"
+	"github.com/nitrado/connqc/connqctest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/goleak"
+)
+
+func TestServer(t *testing.T) {
+	tests := []struct {
+		name     string
+		protocol string
+	}{
+		{
+			name:     "tcp",
+			protocol: "tcp",
+		},
+		{
+			name:     "udp",
+			protocol: "udp",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+
+			srv := connqctest.NewServer()
+			defer srv.Close()
+
+			received := runClient(t, test.protocol, srv.Addr, 3)
+
+			assert.Equal(t, 3, received)
+			msgs := srv.Received()
+			require.NotEmpty(t, msgs)
+			assert.IsType(t, connqc.Hello{}, msgs[0])
+			var probes []uint64
+			for _, msg := range msgs[1:] {
+				p, ok := msg.(connqc.TimedProbe)
+				require.True(t, ok, "unexpected message %T", msg)
+				probes = append(probes, p.ID)
+			}
+			assert.Equal(t, []uint64{1, 2, 3}, probes[:3])
+			assert.Equal(t, uint64(len(msgs)), srv.Stats().Received)
+		})
+	}
+}
+
+func TestServer_WithSecret(t *testing.T) {
+	srv := connqctest.NewServer(connqctest.WithProtocols("udp"), connqctest.WithSecret([]byte("secret")))
+	defer srv.Close()
+
+	received := runClient(t, "udp", srv.Addr, 2, connqc.WithSecret([]byte("secret")))
+
+	assert.Equal(t, 2, received)
+	assert.GreaterOrEqual(t, len(srv.Received()), 3)
+}
+
+func TestServer_CloseClosesConnections(t *testing.T) {
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+
+	srv := connqctest.NewServer(connqctest.WithProtocols("tcp"))
+
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+
+	disconnected := make(chan error, 1)
+	client := newTestClient(t, connqc.WithBackoff(time.Hour), connqc.WithObserver(connqc.ObserverFunc(func(e connqc.Event) {
+		switch v := e.(type) {
+		case connqc.ProbeReceived:
+			srv.Close()
+		case connqc.Disconnected:
+			select {
+			case disconnected <- v.Err:
+			default:
+			}
+		}
+	})))
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = client.Run(ctx, "tcp", srv.Addr)
+	}()
+
+	select {
+	case err := <-disconnected:
+		assert.Error(t, err)
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "client was not disconnected")
+	}
+	cancel()
+	<-done
+}
+
+func TestNewServer_PanicsOnUnsupportedProtocol(t *testing.T) {
+	assert.PanicsWithValue(t, `connqctest: unsupported protocol "quic"`, func() {
+		connqctest.NewServer(connqctest.WithProtocols("quic"))
+	})
+}
+
+// runClient runs a client until it received n probe responses,
+// returning the number of responses received.
+func runClient(t *testing.T, protocol, addr string, n int, opts ...connqc.Option) int {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+
+	var received int
+	opts = append(opts,
+		connqc.WithSendInterval(10*time.Millisecond),
+		connqc.WithDrainTimeout(0),
+		connqc.WithObserver(connqc.ObserverFunc(func(e connqc.Event) {
+			if _, ok := e.(connqc.ProbeReceived); !ok {
+				return
+			}
+			if received++; received == n {
+				cancel()
+			}
+		})),
+	)
+	client := newTestClient(t, opts...)
+
+	_ = client.Run(ctx, protocol, addr)
+
+	return received
+}
+
+func newTestClient(t *testing.T, opts ...connqc.Option) *connqc.Client {
+	t.Helper()
+
+	log := logger.New(io.Discard, logger.LogfmtFormat(), logger.Error)
+	opts = append([]connqc.Option{connqc.WithSummaryInterval(0)}, opts...)
+	client, err := connqc.NewClient(log, opts...)
+	require.NoError(t, err)
+
+	return client
+}
@@ -0,0 +1,191 @@
+package connqc_test
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/hamba/logger/v2"
+	"github.com/nitrado/connqc"
+	"github.com/nitrado/connqc/impair"
+	"github.com/nitrado/connqc/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientServer_Unimpaired(t *testing.T) {
+	res := runScenario(t, 20)
+
+	assert.Len(t, res.received, 20)
+	for i, e := range res.received {
+		assert.Equal(t, uint64(i+1), e.ID)
+		assert.Equal(t, connqc.ArrivalInOrder, e.Arrival)
+	}
+	assert.Empty(t, res.lost)
+	assert.Empty(t, res.disconnects)
+}
+
+func TestClientServer_Loss(t *testing.T) {
+	res := runScenario(t, 50, impair.WithSeed(3), impair.WithLoss(0.2))
+
+	require.Positive(t, res.stats.Dropped)
+	assert.Len(t, res.lost, int(res.stats.Dropped))
+	assert.Len(t, res.received, 50-int(res.stats.Dropped))
+	assert.Empty(t, res.disconnects)
+}
+
+func TestClientServer_BurstLoss(t *testing.T) {
+	res := runScenario(t, 100, impair.WithSeed(3), impair.WithBurstLoss(0.05, 0.3))
+
+	require.Positive(t, res.stats.Dropped)
+	assert.Len(t, res.lost, int(res.stats.Dropped))
+	assert.Empty(t, res.disconnects)
+}
+
+func TestClientServer_Duplication(t *testing.T) {
+	res := runScenario(t, 20, impair.WithDuplication(1))
+
+	assert.Len(t, res.received, 20)
+	// The duplicate of the last response may arrive after the client has stopped.
+	assert.GreaterOrEqual(t, len(res.duplicated), 19)
+	assert.Empty(t, res.disconnects)
+}
+
+func TestClientServer_Reordering(t *testing.T) {
+	res := runScenario(t, 50, impair.WithSeed(3), impair.WithReordering(0.2, 10*time.Millisecond))
+
+	assert.Len(t, res.received, 50)
+	var reordered int
+	for _, e := range res.received {
+		if e.Arrival == connqc.ArrivalReordered {
+			reordered++
+			assert.Positive(t, e.ReorderDistance)
+		}
+	}
+	assert.Positive(t, reordered)
+	assert.Empty(t, res.lost)
+}
+
+func TestClientServer_DelayBeyondProbeTimeout(t *testing.T) {
+	res := runScenario(t, 50, impair.WithSeed(3), impair.WithReordering(0.1, 100*time.Millisecond))
+
+	require.Positive(t, res.stats.Reordered)
+	assert.Len(t, res.lost, int(res.stats.Reordered))
+	// The responses to the last probes held back may arrive after the client has stopped.
+	assert.NotEmpty(t, res.late)
+	assert.LessOrEqual(t, len(res.late), len(res.lost))
+}
+
+func TestClientServer_Corruption(t *testing.T) {
+	res := runScenario(t, 50, impair.WithSeed(3), impair.WithCorruption(0.5))
+
+	require.Positive(t, res.stats.Corrupted)
+	assert.NotEmpty(t, res.corrupted)
+	// Packets with a corrupted message type are rejected without disconnecting.
+	require.NotEmpty(t, res.rejected)
+	assert.Equal(t, "malformed", res.rejected[0].Reason)
+	assert.Empty(t, res.disconnects)
+}
+
+func TestClientServer_Blackhole(t *testing.T) {
+	res := runScenario(t, 60, impair.WithBlackhole(100*time.Millisecond, 100*time.Millisecond))
+
+	require.Positive(t, res.stats.Blackholed)
+	assert.Len(t, res.lost, int(res.stats.Blackholed))
+	// The client recovers once the blackhole is over.
+	require.NotEmpty(t, res.received)
+	assert.Equal(t, uint64(60), res.received[len(res.received)-1].ID)
+}
+
+type scenarioResult struct {
+	received    []connqc.ProbeReceived
+	lost        []connqc.ProbeLost
+	duplicated  []connqc.ProbeDuplicated
+	late        []connqc.ProbeLate
+	corrupted   []connqc.ProbeCorrupted
+	rejected    []connqc.ProbeRejected
+	disconnects []connqc.Disconnected
+	stats       impair.Stats
+}
+
+// runScenario runs a client against a server over the in-memory transport,
+// impairing only the probes sent by the client, as the impairment is one-way, until n probes have been answered
+// or lost. The outstanding probes are drained before returning.
+func runScenario(t *testing.T, n int, opts ...impair.Option) scenarioResult {
+	t.Helper()
+	verifyNoLeaks(t)
+
+	log := logger.New(io.Discard, logger.LogfmtFormat(), logger.Error)
+	srv, err := connqc.NewServer(log)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+	srvErrCh := make(chan error, 1)
+	go func() { srvErrCh <- memory.Transport{}.Listen(ctx, t.Name(), srv) }()
+	require.Eventually(t, func() bool { return memory.Listening(t.Name()) }, time.Second, time.Millisecond)
+
+	events := make(chan connqc.Event, 10*n)
+	tr, err := impair.NewTransport(memory.Transport{}, opts...)
+	require.NoError(t, err)
+	client := newTestClient(t,
+		connqc.WithTransport("impaired", tr),
+		connqc.WithSendInterval(5*time.Millisecond),
+		connqc.WithProbeTimeout(50*time.Millisecond),
+		connqc.WithDrainTimeout(500*time.Millisecond),
+		connqc.WithObserver(connqc.ObserverFunc(func(e connqc.Event) { events <- e })),
+	)
+
+	clientCtx, stop := context.WithCancel(ctx)
+	defer stop()
+	errCh := make(chan error, 1)
+	go func() { errCh <- client.Run(clientCtx, "impaired", t.Name()) }()
+
+	var res scenarioResult
+	collect := func(e connqc.Event) {
+		switch v := e.(type) {
+		case connqc.ProbeReceived:
+			res.received = append(res.received, v)
+		case connqc.ProbeLost:
+			res.lost = append(res.lost, v)
+		case connqc.ProbeDuplicated:
+			res.duplicated = append(res.duplicated, v)
+		case connqc.ProbeLate:
+			res.late = append(res.late, v)
+		case connqc.ProbeCorrupted:
+			res.corrupted = append(res.corrupted, v)
+		case connqc.ProbeRejected:
+			res.rejected = append(res.rejected, v)
+		case connqc.Disconnected:
+			if v.Err != nil {
+				res.disconnects = append(res.disconnects, v)
+			}
+		}
+	}
+
+	timeout := time.After(10 * time.Second)
+	for len(res.received)+len(res.lost)+len(res.corrupted) < n {
+		select {
+		case e := <-events:
+			collect(e)
+		case <-timeout:
+			require.FailNow(t, "timed out waiting for probes")
+		}
+	}
+
+	stop()
+	require.NoError(t, <-errCh)
+	for len(events) > 0 {
+		collect(<-events)
+	}
+
+	// Responses to the probes sent while stopping are not of interest.
+	res.received = res.received[:min(len(res.received), n-len(res.lost)-len(res.corrupted))]
+	res.stats = tr.Stats()
+
+	cancel()
+	require.NoError(t, <-srvErrCh)
+
+	return res
+}
@@ -0,0 +1,316 @@
+package connqc
+
+import (
+	"time"
+
+	"github.com/hamba/logger/v2"
+	lctx "github.com/hamba/logger/v2/ctx"
+	"github.com/nitrado/connqc/stats"
+)
+
+// Event represents a client event.
+type Event interface {
+	unexported()
+}
+
+// Arrival is the classification of a probe response, following
+// the reordering metrics of RFC 4737 and RFC 5236.
+type Arrival int
+
+// Arrivals.
+const (
+	// ArrivalInOrder is a response to a probe sent after all probes answered so far.
+	ArrivalInOrder Arrival = iota
+	// ArrivalReordered is a response to a probe sent before a probe that was already answered.
+	ArrivalReordered
+	// ArrivalDuplicate is a response to a probe that was already answered.
+	ArrivalDuplicate
+	// ArrivalLate is a response to a probe that was already considered lost.
+	ArrivalLate
+)
+
+// String returns the string representation of the arrival.
+func (a Arrival) String() string {
+	switch a {
+	case ArrivalInOrder:
+		return "in_order"
+	case ArrivalReordered:
+		return "reordered"
+	case ArrivalDuplicate:
+		return "duplicate"
+	case ArrivalLate:
+		return "late"
+	default:
+		return "unknown"
+	}
+}
+
+// Connected is emitted when the client has connected to the server.
+type Connected struct {
+	Protocol     string
+	Addr         string
+	Version      uint16
+	Capabilities Capability
+
+	// Handshake is the duration of the transport handshake, such as the
+	// TLS handshake. It is zero for transports without a handshake.
+	Handshake time.Duration
+
+	// Resumed reports whether the transport resumed a previous session,
+	// such as QUIC connections using 0-RTT.
+	Resumed bool
+
+	// Proxy is the duration of connecting through the proxy.
+	// It is zero for direct connections.
+	Proxy time.Duration
+}
+
+func (e Connected) unexported() {}
+
+// ConnectFailed is emitted when the client could not connect to the server.
+type ConnectFailed struct {
+	Protocol  string
+	Addr      string
+	Reconnect int
+	Err       error
+}
+
+func (e ConnectFailed) unexported() {}
+
+// Disconnected is emitted when the connection to the server has ended.
+// Err is the reason of the disconnect, which is nil if the client was stopped.
+type Disconnected struct {
+	Protocol  string
+	Addr      string
+	Reconnect int
+	Err       error
+}
+
+func (e Disconnected) unexported() {}
+
+// ProbeSent is emitted when a probe has been sent.
+//
+// Lateness is the duration the probe was sent after it was due.
+// Skipped is the number of probes that were not sent before it,
+// as they were already late by a whole send interval.
+type ProbeSent struct {
+	ID       uint64
+	Data     string
+	Time     time.Time
+	Lateness time.Duration
+	Skipped  int
+}
+
+func (e ProbeSent) unexported() {}
+
+// ProbeReceived is emitted when the response to an outstanding probe has been received.
+//
+// Upstream, Server and Downstream are only set if the server supports timestamps
+// and filled them in, as reported by Timestamps.
+// ReorderDistance is the number of IDs the probe is behind the next expected ID
+// when it arrived reordered.
+type ProbeReceived struct {
+	ID              uint64
+	Data            string
+	Time            time.Time
+	RTT             time.Duration
+	Timestamps      bool
+	Upstream        time.Duration
+	Server          time.Duration
+	Downstream      time.Duration
+	Arrival         Arrival
+	ReorderDistance uint64
+}
+
+func (e ProbeReceived) unexported() {}
+
+// ProbeLost is emitted when a probe has not been answered within the probe timeout.
+//
+// Evicted is set if the probe was considered lost before the probe timeout,
+// as the maximum number of in-flight probes was reached.
+// Abandoned is set if the probe was considered lost before the probe timeout,
+// as its connection was dropped or the drain timeout was reached.
+type ProbeLost struct {
+	ID        uint64
+	Data      string
+	Time      time.Time
+	Evicted   bool
+	Abandoned bool
+}
+
+func (e ProbeLost) unexported() {}
+
+// ProbeDuplicated is emitted when a response to an already answered probe has been received.
+type ProbeDuplicated struct {
+	ID   uint64
+	Time time.Time
+}
+
+func (e ProbeDuplicated) unexported() {}
+
+// ProbeLate is emitted when the response to a lost probe has been received.
+type ProbeLate struct {
+	ID   uint64
+	Time time.Time
+	RTT  time.Duration
+}
+
+func (e ProbeLate) unexported() {}
+
+// ProbeCorrupted is emitted when a response has been received
+// whose data does not match its checksum.
+type ProbeCorrupted struct {
+	ID           uint64
+	Data         string
+	ExpectedData string
+	Checksum     uint32
+	Time         time.Time
+}
+
+func (e ProbeCorrupted) unexported() {}
+
+// ProbeRejected is emitted when a response has been rejected, such as
+// unauthenticated, replayed or malformed responses.
+type ProbeRejected struct {
+	ID     uint64
+	Reason string
+	Time   time.Time
+}
+
+func (e ProbeRejected) unexported() {}
+
+// Observer observes client events.
+//
+// Observers are called synchronously by the client and must not block.
+type Observer interface {
+	Observe(Event)
+}
+
+// ObserverFunc is an adapter allowing a function to be used as an Observer.
+type ObserverFunc func(Event)
+
+// Observe calls fn(e).
+func (fn ObserverFunc) Observe(e Event) {
+	fn(e)
+}
+
+// logObserver logs client events.
+type logObserver struct {
+	log *logger.Logger
+}
+
+func (o logObserver) Observe(e Event) { //nolint:cyclop,funlen // Simplify readability.
+	switch v := e.(type) {
+	case Connected:
+		fields := []logger.Field{
+			lctx.Int("version", int(v.Version)),
+			lctx.Uint32("capabilities", uint32(v.Capabilities)),
+		}
+		if v.Handshake > 0 {
+			fields = append(fields, lctx.Duration("handshake", v.Handshake))
+		}
+		if v.Resumed {
+			fields = append(fields, lctx.Bool("resumed", true))
+		}
+		if v.Proxy > 0 {
+			fields = append(fields, lctx.Duration("proxy", v.Proxy))
+		}
+		o.log.Info("Connected", fields...)
+	case ConnectFailed:
+		o.log.Error("Could not connect to server",
+			lctx.Str("protocol", v.Protocol),
+			lctx.Str("addr", v.Addr),
+			lctx.Int("reconnect", v.Reconnect),
+			lctx.Err(v.Err),
+		)
+	case Disconnected:
+		if v.Err == nil {
+			return
+		}
+		o.log.Error("Connection error",
+			lctx.Str("protocol", v.Protocol),
+			lctx.Str("addr", v.Addr),
+			lctx.Int("reconnect", v.Reconnect),
+			lctx.Err(v.Err),
+		)
+	case ProbeSent:
+		fields := []logger.Field{lctx.Uint64("id", v.ID), lctx.Str("data", v.Data)}
+		if v.Lateness > 0 {
+			fields = append(fields, lctx.Duration("lateness", v.Lateness))
+		}
+		if v.Skipped > 0 {
+			fields = append(fields, lctx.Int("skipped", v.Skipped))
+		}
+		o.log.Info("Message sent", fields...)
+	case ProbeReceived:
+		fields := []logger.Field{
+			lctx.Uint64("id", v.ID),
+			lctx.Str("data", v.Data),
+			lctx.Duration("took", v.RTT),
+			lctx.Str("arrival", v.Arrival.String()),
+		}
+		if v.Arrival == ArrivalReordered {
+			fields = append(fields, lctx.Uint64("reorder_distance", v.ReorderDistance))
+		}
+		if v.Timestamps {
+			fields = append(fields,
+				lctx.Duration("upstream", v.Upstream),
+				lctx.Duration("server", v.Server),
+				lctx.Duration("downstream", v.Downstream),
+			)
+		}
+		o.log.Info("Message received", fields...)
+	case ProbeLost:
+		reason := "timeout"
+		switch {
+		case v.Evicted:
+			reason = "evicted"
+		case v.Abandoned:
+			reason = "abandoned"
+		}
+		o.log.Warn("Message dropped",
+			lctx.Str("error", reason),
+			lctx.Uint64("id", v.ID),
+			lctx.Str("data", v.Data),
+		)
+	case ProbeDuplicated:
+		o.log.Warn("Message duplicated", lctx.Uint64("id", v.ID))
+	case ProbeLate:
+		o.log.Warn("Message arrived late", lctx.Uint64("id", v.ID), lctx.Duration("took", v.RTT))
+	case ProbeCorrupted:
+		o.log.Warn("Message corrupted",
+			lctx.Uint64("id", v.ID),
+			lctx.Str("data", v.Data),
+			lctx.Str("expected_data", v.ExpectedData),
+			lctx.Uint32("checksum", v.Checksum),
+		)
+	case ProbeRejected:
+		o.log.Warn("Message rejected", lctx.Uint64("id", v.ID), lctx.Str("reason", v.Reason))
+	}
+}
+
+// statsObserver feeds client events into statistics.
+type statsObserver struct {
+	stats *stats.Stats
+}
+
+func (o statsObserver) Observe(e Event) {
+	switch v := e.(type) {
+	case ProbeSent:
+		o.stats.Sent(v.Time)
+	case ProbeReceived:
+		if v.Arrival == ArrivalReordered {
+			o.stats.Reordered(v.Time, v.RTT)
+			return
+		}
+		o.stats.Received(v.Time, v.RTT)
+	case ProbeLost:
+		o.stats.Lost(v.Time)
+	case ProbeDuplicated:
+		o.stats.Duplicated(v.Time)
+	case ProbeLate:
+		o.stats.Late(v.Time)
+	case ProbeCorrupted:
+		o.stats.Corrupted(v.Time)
+	}
+}
@@ -0,0 +1,182 @@
+package impair
+
+import (
+	"context"
+	"net"
+
+	"github.com/nitrado/connqc/transport"
+)
+
+// Conn is a connection whose writes are impaired.
+type Conn struct {
+	net.Conn
+
+	im    *impairer
+	stats *stats
+}
+
+// NewConn returns a connection impairing the writes to conn.
+func NewConn(conn net.Conn, opts ...Option) (*Conn, error) {
+	cfg, err := newConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+	return newConn(conn, &stats{}, cfg), nil
+}
+
+func newConn(conn net.Conn, s *stats, cfg config) *Conn {
+	c := &Conn{Conn: conn, stats: s}
+	c.im = newImpairer(cfg, s, func(p []byte, _ net.Addr) error {
+		_, err := conn.Write(p)
+		return err
+	})
+	return c
+}
+
+// Write impairs the packet, writing it now or once it is due.
+// Errors writing delayed packets are not reported.
+func (c *Conn) Write(p []byte) (int, error) {
+	if err := c.im.send(p, nil); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close discards the delayed packets and closes the connection.
+func (c *Conn) Close() error {
+	c.im.stop()
+	return c.Conn.Close()
+}
+
+// Stats returns the counters of impaired packets.
+func (c *Conn) Stats() Stats {
+	return c.stats.snapshot()
+}
+
+// Stream reports whether the underlying connection is backed by a stream.
+// Connections that do not report it are assumed to be streams.
+func (c *Conn) Stream() bool {
+	sc, ok := c.Conn.(interface{ Stream() bool })
+	return !ok || sc.Stream()
+}
+
+// PacketConn is a packet connection whose writes are impaired.
+type PacketConn struct {
+	net.PacketConn
+
+	im    *impairer
+	stats *stats
+}
+
+// NewPacketConn returns a packet connection impairing the writes to pc.
+func NewPacketConn(pc net.PacketConn, opts ...Option) (*PacketConn, error) {
+	cfg, err := newConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+	return newPacketConn(pc, &stats{}, cfg), nil
+}
+
+func newPacketConn(pc net.PacketConn, s *stats, cfg config) *PacketConn {
+	c := &PacketConn{PacketConn: pc, stats: s}
+	c.im = newImpairer(cfg, s, func(p []byte, addr net.Addr) error {
+		_, err := pc.WriteTo(p, addr)
+		return err
+	})
+	return c
+}
+
+// WriteTo impairs the packet, writing it now or once it is due.
+// Errors writing delayed packets are not reported.
+func (c *PacketConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	if err := c.im.send(p, addr); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close discards the delayed packets and closes the connection.
+func (c *PacketConn) Close() error {
+	c.im.stop()
+	return c.PacketConn.Close()
+}
+
+// Stream reports whether the underlying connection is backed by a stream.
+func (c *PacketConn) Stream() bool {
+	sc, ok := c.PacketConn.(interface{ Stream() bool })
+	return ok && sc.Stream()
+}
+
+// Identity returns the identity of the peer, if the underlying
+// connection knows it.
+func (c *PacketConn) Identity() string {
+	if id, ok := c.PacketConn.(transport.Identifier); ok {
+		return id.Identity()
+	}
+	return ""
+}
+
+// Stats returns the counters of impaired packets.
+func (c *PacketConn) Stats() Stats {
+	return c.stats.snapshot()
+}
+
+var _ transport.Transport = &Transport{}
+
+// Transport wraps a transport, impairing the writes to the connections
+// it dials and to the connections passed to the handler when it listens.
+// Every connection draws its random decisions from its own generator,
+// seeded with the same seed.
+//
+// The impairment is one-way on each side: dialing through the transport
+// impairs what the client sends, while listening through it impairs what
+// the server sends. Both sides must use it to impair both directions.
+type Transport struct {
+	transport transport.Transport
+	cfg       config
+	stats     *stats
+}
+
+// NewTransport returns a transport impairing the connections of t.
+func NewTransport(t transport.Transport, opts ...Option) (*Transport, error) {
+	cfg, err := newConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+	return &Transport{
+		transport: t,
+		cfg:       cfg,
+		stats:     &stats{},
+	}, nil
+}
+
+// Dial returns a connection of the wrapped transport, impairing its writes.
+func (t *Transport) Dial(ctx context.Context, d transport.Dialer, addr string) (net.Conn, error) {
+	conn, err := t.transport.Dial(ctx, d, addr)
+	if err != nil {
+		return nil, err
+	}
+	return newConn(conn, t.stats, t.cfg), nil
+}
+
+// Listen listens with the wrapped transport, impairing the writes to
+// the connections passed to the handler.
+func (t *Transport) Listen(ctx context.Context, addr string, h transport.Handler) error {
+	return t.transport.Listen(ctx, addr, handlerFunc(func(pc net.PacketConn) {
+		ipc := newPacketConn(pc, t.stats, t.cfg)
+		defer ipc.im.stop()
+
+		h.Serve(ipc)
+	}))
+}
+
+// Stats returns the counters of impaired packets, summed over all connections.
+func (t *Transport) Stats() Stats {
+	return t.stats.snapshot()
+}
+
+type handlerFunc func(net.PacketConn)
+
+func (fn handlerFunc) Serve(pc net.PacketConn) {
+	fn(pc)
+}
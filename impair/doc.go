@@ -0,0 +1,11 @@
+// Package impair simulates network impairments for reproducible tests.
+//
+// Connections are wrapped to inject loss, bursty loss, delay, jitter,
+// reordering, duplication, corruption and blackhole periods into the
+// messages written to them. Every random decision is drawn from a seeded
+// generator, so the same sequence of writes is impaired in the same way.
+//
+// Impairments treat every write as a packet, making them suited to packet
+// connections such as UDP. Only writes are impaired, so both ends of a
+// connection must be wrapped to impair both directions.
+package impair
@@ -0,0 +1,415 @@
+package impair
+
+import (
+	"container/heap"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Option configures the impairments.
+type Option func(*config)
+
+type config struct {
+	seed         uint64
+	loss         float64
+	burstEnter   float64
+	burstExit    float64
+	delay        time.Duration
+	jitter       time.Duration
+	reorder      float64
+	reorderDelay time.Duration
+	duplicate    float64
+	corrupt      float64
+	blackholes   []blackhole
+}
+
+type blackhole struct {
+	start time.Duration
+	end   time.Duration
+}
+
+func newConfig(opts []Option) (config, error) {
+	cfg := config{seed: 1}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return cfg, cfg.validate()
+}
+
+// WithSeed sets the seed of the random decisions. The default seed is 1.
+func WithSeed(seed uint64) Option {
+	return func(c *config) {
+		c.seed = seed
+	}
+}
+
+// WithLoss drops packets at random with the given probability.
+func WithLoss(p float64) Option {
+	return func(c *config) {
+		c.loss = p
+	}
+}
+
+// WithBurstLoss drops packets in bursts, following the Gilbert model. A burst
+// starts with probability enter after each packet and ends with probability
+// exit after each packet dropped, making the average burst 1/exit packets long.
+func WithBurstLoss(enter, exit float64) Option {
+	return func(c *config) {
+		c.burstEnter = enter
+		c.burstExit = exit
+	}
+}
+
+// WithDelay delays every packet by the given duration.
+func WithDelay(d time.Duration) Option {
+	return func(c *config) {
+		c.delay = d
+	}
+}
+
+// WithJitter delays every packet by an additional random duration
+// of up to the given duration.
+func WithJitter(d time.Duration) Option {
+	return func(c *config) {
+		c.jitter = d
+	}
+}
+
+// WithReordering holds packets back by the given duration with the given
+// probability, letting the packets written after them overtake them.
+func WithReordering(p float64, d time.Duration) Option {
+	return func(c *config) {
+		c.reorder = p
+		c.reorderDelay = d
+	}
+}
+
+// WithDuplication writes packets twice with the given probability.
+func WithDuplication(p float64) Option {
+	return func(c *config) {
+		c.duplicate = p
+	}
+}
+
+// WithCorruption flips a random bit of packets with the given probability.
+func WithCorruption(p float64) Option {
+	return func(c *config) {
+		c.corrupt = p
+	}
+}
+
+// WithBlackhole drops all packets for the given duration, starting after
+// the given offset from wrapping the connection. It can be set multiple times.
+func WithBlackhole(offset, d time.Duration) Option {
+	return func(c *config) {
+		c.blackholes = append(c.blackholes, blackhole{start: offset, end: offset + d})
+	}
+}
+
+func (c config) validate() error {
+	probabilities := []struct {
+		name string
+		p    float64
+	}{
+		{name: "loss", p: c.loss},
+		{name: "burst enter", p: c.burstEnter},
+		{name: "burst exit", p: c.burstExit},
+		{name: "reordering", p: c.reorder},
+		{name: "duplication", p: c.duplicate},
+		{name: "corruption", p: c.corrupt},
+	}
+	for _, v := range probabilities {
+		if v.p < 0 || v.p > 1 {
+			return fmt.Errorf("impair: %s probability must be between 0 and 1, got %v", v.name, v.p)
+		}
+	}
+
+	switch {
+	case c.delay < 0:
+		return fmt.Errorf("impair: delay must not be negative, got %s", c.delay)
+	case c.jitter < 0:
+		return fmt.Errorf("impair: jitter must not be negative, got %s", c.jitter)
+	case c.reorderDelay < 0:
+		return fmt.Errorf("impair: reordering delay must not be negative, got %s", c.reorderDelay)
+	}
+	return nil
+}
+
+// Stats contains the counters of impaired packets.
+type Stats struct {
+	// Packets is the number of packets written.
+	Packets uint64
+	// Dropped is the number of packets dropped by random or bursty loss.
+	Dropped uint64
+	// Blackholed is the number of packets dropped during blackhole periods.
+	Blackholed uint64
+	// Duplicated is the number of packets written twice.
+	Duplicated uint64
+	// Corrupted is the number of packets with a flipped bit, including duplicates.
+	Corrupted uint64
+	// Reordered is the number of packets held back, including duplicates.
+	Reordered uint64
+}
+
+type stats struct {
+	packets    atomic.Uint64
+	dropped    atomic.Uint64
+	blackholed atomic.Uint64
+	duplicated atomic.Uint64
+	corrupted  atomic.Uint64
+	reordered  atomic.Uint64
+}
+
+func (s *stats) snapshot() Stats {
+	return Stats{
+		Packets:    s.packets.Load(),
+		Dropped:    s.dropped.Load(),
+		Blackholed: s.blackholed.Load(),
+		Duplicated: s.duplicated.Load(),
+		Corrupted:  s.corrupted.Load(),
+		Reordered:  s.reordered.Load(),
+	}
+}
+
+// packet is a packet waiting to be written.
+type packet struct {
+	due  time.Time
+	seq  uint64
+	data []byte
+	addr net.Addr
+}
+
+// queue orders packets by their due time, keeping the order
+// in which they were written for equal due times.
+type queue []packet
+
+func (q queue) Len() int { return len(q) }
+
+func (q queue) Less(i, j int) bool {
+	if q[i].due.Equal(q[j].due) {
+		return q[i].seq < q[j].seq
+	}
+	return q[i].due.Before(q[j].due)
+}
+
+func (q queue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+
+func (q *queue) Push(x any) { *q = append(*q, x.(packet)) }
+
+func (q *queue) Pop() any {
+	old := *q
+	p := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return p
+}
+
+// impairer impairs packets before passing them to the write function.
+// Delayed packets are written in order of their due time by a goroutine,
+// which is started with the first delayed packet.
+type impairer struct {
+	cfg   config
+	write func([]byte, net.Addr) error
+	stats *stats
+	start time.Time
+
+	mu     sync.Mutex
+	rng    *rand.Rand
+	burst  bool
+	seq    uint64
+	queue  queue
+	closed bool
+
+	once sync.Once
+	wake chan struct{}
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+func newImpairer(cfg config, s *stats, write func([]byte, net.Addr) error) *impairer {
+	return &impairer{
+		cfg:   cfg,
+		write: write,
+		stats: s,
+		start: time.Now(),
+		rng:   rand.New(rand.NewPCG(cfg.seed, cfg.seed)), //nolint:gosec // Reproducibility is required.
+		wake:  make(chan struct{}, 1),
+		done:  make(chan struct{}),
+	}
+}
+
+// send impairs the packet, writing it now or once it is due. Only the
+// errors of packets written now are returned, like a network would.
+func (im *impairer) send(p []byte, addr net.Addr) error {
+	now := time.Now()
+
+	im.mu.Lock()
+	if im.closed {
+		im.mu.Unlock()
+		return net.ErrClosed
+	}
+	pkts := im.impair(p, addr, now)
+
+	var delayed bool
+	for _, pkt := range pkts {
+		if pkt.due.After(now) {
+			delayed = true
+		}
+	}
+	if !delayed && len(im.queue) == 0 {
+		im.mu.Unlock()
+
+		for _, pkt := range pkts {
+			if err := im.write(pkt.data, pkt.addr); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for _, pkt := range pkts {
+		heap.Push(&im.queue, pkt)
+	}
+	im.once.Do(func() {
+		im.wg.Add(1)
+		go im.run()
+	})
+	im.mu.Unlock()
+
+	select {
+	case im.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// impair applies the impairments to the packet, returning the packets to write.
+// The random decisions are drawn in a fixed order to be reproducible.
+func (im *impairer) impair(p []byte, addr net.Addr, now time.Time) []packet {
+	im.stats.packets.Add(1)
+
+	elapsed := now.Sub(im.start)
+	for _, bh := range im.cfg.blackholes {
+		if elapsed >= bh.start && elapsed < bh.end {
+			im.stats.blackholed.Add(1)
+			return nil
+		}
+	}
+
+	if im.lost() {
+		im.stats.dropped.Add(1)
+		return nil
+	}
+
+	n := 1
+	if im.chance(im.cfg.duplicate) {
+		im.stats.duplicated.Add(1)
+		n = 2
+	}
+
+	pkts := make([]packet, 0, n)
+	for range n {
+		data := append([]byte(nil), p...)
+		if len(data) > 0 && im.chance(im.cfg.corrupt) {
+			bit := im.rng.IntN(len(data) * 8)
+			data[bit/8] ^= 1 << (bit % 8)
+			im.stats.corrupted.Add(1)
+		}
+
+		delay := im.cfg.delay
+		if im.cfg.jitter > 0 {
+			delay += time.Duration(im.rng.Int64N(int64(im.cfg.jitter)))
+		}
+		if im.chance(im.cfg.reorder) {
+			delay += im.cfg.reorderDelay
+			im.stats.reordered.Add(1)
+		}
+
+		pkts = append(pkts, packet{due: now.Add(delay), seq: im.seq, data: data, addr: addr})
+		im.seq++
+	}
+	return pkts
+}
+
+// lost decides whether the packet is lost, moving between the
+// states of the Gilbert model if bursty loss is configured.
+func (im *impairer) lost() bool {
+	if im.cfg.burstEnter > 0 {
+		if im.burst {
+			im.burst = !im.chance(im.cfg.burstExit)
+		} else {
+			im.burst = im.chance(im.cfg.burstEnter)
+		}
+		if im.burst {
+			return true
+		}
+	}
+	return im.chance(im.cfg.loss)
+}
+
+// chance returns true with the given probability. No random
+// decision is drawn for impairments that are not configured.
+func (im *impairer) chance(p float64) bool {
+	if p <= 0 {
+		return false
+	}
+	return im.rng.Float64() < p
+}
+
+// run writes the delayed packets once they are due, until stopped.
+func (im *impairer) run() {
+	defer im.wg.Done()
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		var due []packet
+		wait := time.Duration(-1)
+
+		im.mu.Lock()
+		now := time.Now()
+		for len(im.queue) > 0 && !im.queue[0].due.After(now) {
+			due = append(due, heap.Pop(&im.queue).(packet))
+		}
+		if len(im.queue) > 0 {
+			wait = im.queue[0].due.Sub(now)
+		}
+		im.mu.Unlock()
+
+		for _, pkt := range due {
+			_ = im.write(pkt.data, pkt.addr)
+		}
+
+		var timerCh <-chan time.Time
+		if wait >= 0 {
+			timer.Reset(wait)
+			timerCh = timer.C
+		}
+
+		select {
+		case <-im.done:
+			return
+		case <-im.wake:
+		case <-timerCh:
+		}
+	}
+}
+
+// stop stops writing packets, discarding the packets still delayed.
+func (im *impairer) stop() {
+	im.mu.Lock()
+	if im.closed {
+		im.mu.Unlock()
+		return
+	}
+	im.closed = true
+	im.queue = nil
+	im.mu.Unlock()
+
+	close(im.done)
+	im.wg.Wait()
+}
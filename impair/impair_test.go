@@ -0,0 +1,245 @@
+package impair
+
+import (
+	"fmt"
+	"math/bits"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPacketConn_IsReproducible(t *testing.T) {
+	opts := []Option{WithSeed(42), WithLoss(0.3), WithDuplication(0.2), WithCorruption(0.2)}
+
+	first := writeAll(t, 100, opts...)
+	second := writeAll(t, 100, opts...)
+
+	assert.Equal(t, first.packets(), second.packets())
+}
+
+func TestPacketConn_Loss(t *testing.T) {
+	rec := writeAll(t, 1000, WithLoss(0.1))
+
+	got := len(rec.packets())
+	assert.InDelta(t, 900, got, 50)
+	assert.Equal(t, Stats{Packets: 1000, Dropped: uint64(1000 - got)}, rec.conn.Stats())
+}
+
+func TestPacketConn_BurstLoss(t *testing.T) {
+	rec := writeAll(t, 10000, WithBurstLoss(0.05, 0.25))
+
+	var bursts, dropped int
+	prev := -1
+	for _, p := range rec.packets() {
+		var i int
+		_, err := fmt.Sscanf(p, "%d", &i)
+		require.NoError(t, err)
+		if gap := i - prev - 1; gap > 0 {
+			bursts++
+			dropped += gap
+		}
+		prev = i
+	}
+
+	require.Positive(t, bursts)
+	// The average burst is 1/exit packets long.
+	assert.InDelta(t, 4, float64(dropped)/float64(bursts), 0.5)
+}
+
+func TestPacketConn_Delay(t *testing.T) {
+	rec := newRecorder()
+	conn, err := NewPacketConn(rec, WithDelay(50*time.Millisecond), WithJitter(10*time.Millisecond))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+
+	start := time.Now()
+	_, err = conn.WriteTo([]byte("0"), nil)
+	require.NoError(t, err)
+
+	rec.wait(t, 1)
+	assert.GreaterOrEqual(t, rec.times[0].Sub(start), 50*time.Millisecond)
+}
+
+func TestPacketConn_Reordering(t *testing.T) {
+	rec := newRecorder()
+	conn, err := NewPacketConn(rec, WithSeed(2), WithReordering(0.5, 20*time.Millisecond))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+
+	for i := range 10 {
+		_, err := conn.WriteTo(fmt.Appendf(nil, "%d", i), nil)
+		require.NoError(t, err)
+	}
+
+	rec.wait(t, 10)
+	assert.ElementsMatch(t, []string{"0", "1", "2", "3", "4", "5", "6", "7", "8", "9"}, rec.packets())
+	assert.NotEqual(t, []string{"0", "1", "2", "3", "4", "5", "6", "7", "8", "9"}, rec.packets())
+	assert.Positive(t, conn.Stats().Reordered)
+}
+
+func TestPacketConn_Duplication(t *testing.T) {
+	rec := writeAll(t, 3, WithDuplication(1))
+
+	assert.Equal(t, []string{"0", "0", "1", "1", "2", "2"}, rec.packets())
+	assert.Equal(t, uint64(3), rec.conn.Stats().Duplicated)
+}
+
+func TestPacketConn_Corruption(t *testing.T) {
+	rec := writeAll(t, 100, WithCorruption(1))
+
+	for i, p := range rec.packets() {
+		want := fmt.Sprintf("%d", i)
+		require.Len(t, p, len(want))
+
+		var flipped int
+		for j := range len(p) {
+			flipped += bits.OnesCount8(p[j] ^ want[j])
+		}
+		assert.Equal(t, 1, flipped)
+	}
+}
+
+func TestPacketConn_Blackhole(t *testing.T) {
+	rec := newRecorder()
+	conn, err := NewPacketConn(rec, WithBlackhole(0, 50*time.Millisecond))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+
+	_, err = conn.WriteTo([]byte("0"), nil)
+	require.NoError(t, err)
+	time.Sleep(60 * time.Millisecond)
+	_, err = conn.WriteTo([]byte("1"), nil)
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"1"}, rec.packets())
+	assert.Equal(t, uint64(1), conn.Stats().Blackholed)
+}
+
+func TestPacketConn_WriteToAfterClose(t *testing.T) {
+	conn, err := NewPacketConn(newRecorder(), WithDelay(time.Second))
+	require.NoError(t, err)
+	_, err = conn.WriteTo([]byte("0"), nil)
+	require.NoError(t, err)
+
+	require.NoError(t, conn.Close())
+
+	_, err = conn.WriteTo([]byte("1"), nil)
+	assert.ErrorIs(t, err, net.ErrClosed)
+}
+
+func TestNewPacketConn_ValidatesOptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    []Option
+		wantErr string
+	}{
+		{
+			name:    "loss",
+			opts:    []Option{WithLoss(1.5)},
+			wantErr: "impair: loss probability must be between 0 and 1, got 1.5",
+		},
+		{
+			name:    "burst exit",
+			opts:    []Option{WithBurstLoss(0.1, -0.1)},
+			wantErr: "impair: burst exit probability must be between 0 and 1, got -0.1",
+		},
+		{
+			name:    "reordering",
+			opts:    []Option{WithReordering(2, time.Millisecond)},
+			wantErr: "impair: reordering probability must be between 0 and 1, got 2",
+		},
+		{
+			name:    "duplication",
+			opts:    []Option{WithDuplication(-1)},
+			wantErr: "impair: duplication probability must be between 0 and 1, got -1",
+		},
+		{
+			name:    "corruption",
+			opts:    []Option{WithCorruption(1.01)},
+			wantErr: "impair: corruption probability must be between 0 and 1, got 1.01",
+		},
+		{
+			name:    "delay",
+			opts:    []Option{WithDelay(-time.Second)},
+			wantErr: "impair: delay must not be negative, got -1s",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := NewPacketConn(newRecorder(), test.opts...)
+
+			assert.EqualError(t, err, test.wantErr)
+		})
+	}
+}
+
+type recording struct {
+	rec  *recorder
+	conn *PacketConn
+}
+
+func (r recording) packets() []string {
+	return r.rec.packets()
+}
+
+// writeAll writes n packets numbered from 0 without delay.
+func writeAll(t *testing.T, n int, opts ...Option) recording {
+	t.Helper()
+
+	rec := newRecorder()
+	conn, err := NewPacketConn(rec, opts...)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+
+	for i := range n {
+		_, err := conn.WriteTo(fmt.Appendf(nil, "%d", i), nil)
+		require.NoError(t, err)
+	}
+	return recording{rec: rec, conn: conn}
+}
+
+// recorder is a packet connection recording the packets written to it.
+type recorder struct {
+	net.PacketConn
+
+	mu    sync.Mutex
+	data  []string
+	times []time.Time
+}
+
+func newRecorder() *recorder {
+	return &recorder{}
+}
+
+func (r *recorder) WriteTo(p []byte, _ net.Addr) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.data = append(r.data, string(p))
+	r.times = append(r.times, time.Now())
+	return len(p), nil
+}
+
+func (r *recorder) Close() error {
+	return nil
+}
+
+func (r *recorder) packets() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]string(nil), r.data...)
+}
+
+func (r *recorder) wait(t *testing.T, n int) {
+	t.Helper()
+
+	require.Eventually(t, func() bool {
+		return len(r.packets()) >= n
+	}, time.Second, time.Millisecond)
+}
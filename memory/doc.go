@@ -0,0 +1,8 @@
+// Package memory provides an in-memory transport, connecting clients and
+// servers of the same process through synchronous pipes with the semantics
+// of net.Pipe. It exercises clients and servers deterministically in tests,
+// without binding ports.
+//
+// The transport is registered as "memory". Addresses are arbitrary names
+// shared by the listening server and its clients.
+package memory
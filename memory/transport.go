@@ -0,0 +1,185 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/nitrado/connqc/transport"
+)
+
+// Network is the network name of in-memory addresses.
+const Network = "memory"
+
+func init() {
+	transport.Register("memory", Transport{})
+}
+
+var (
+	mu        sync.Mutex
+	listeners = map[string]*listener{}
+)
+
+// listener hands the server ends of new connections to a listening server.
+type listener struct {
+	conns chan net.Conn
+	done  chan struct{}
+	next  int
+}
+
+var _ transport.Transport = Transport{}
+
+// Transport is the in-memory transport, registered as "memory".
+type Transport struct{}
+
+// Dial returns a new in-memory connection to the server listening on the address.
+// The dialer is not used.
+//
+// Each write is read as one message, as long as the read buffer can hold it.
+// Writes block until the peer has read them, or until the deadline.
+func (Transport) Dial(ctx context.Context, _ transport.Dialer, addr string) (net.Conn, error) {
+	mu.Lock()
+	l, ok := listeners[addr]
+	var id int
+	if ok {
+		l.next++
+		id = l.next
+	}
+	mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("dialing: memory: no server listening on %s", addr)
+	}
+
+	local := Addr(fmt.Sprintf("%s#%d", addr, id))
+	remote := Addr(addr)
+	clientEnd, serverEnd := net.Pipe()
+
+	select {
+	case l.conns <- &conn{Conn: serverEnd, local: remote, remote: local}:
+		return &conn{Conn: clientEnd, local: local, remote: remote}, nil
+	case <-l.done:
+		return nil, fmt.Errorf("dialing: memory: no server listening on %s", addr)
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Listen serves in-memory connections on the address with the given handler,
+// until the context is done. Connections still open are then closed, and
+// Listen returns once the handler has returned for all of them.
+func (Transport) Listen(ctx context.Context, addr string, h transport.Handler) error {
+	if h == nil {
+		return errors.New("memory: handler cannot be nil")
+	}
+
+	l := &listener{conns: make(chan net.Conn), done: make(chan struct{})}
+
+	mu.Lock()
+	if _, ok := listeners[addr]; ok {
+		mu.Unlock()
+		return fmt.Errorf("listening: memory: address %s already in use", addr)
+	}
+	listeners[addr] = l
+	mu.Unlock()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	defer func() {
+		mu.Lock()
+		delete(listeners, addr)
+		mu.Unlock()
+		close(l.done)
+	}()
+
+	for {
+		select {
+		case c := <-l.conns:
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				stop := context.AfterFunc(ctx, func() { _ = c.Close() })
+				defer stop()
+				defer func() { _ = c.Close() }()
+
+				h.Serve(&packetConn{Conn: c})
+			}()
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// Listening reports whether a server is listening on the address.
+func Listening(addr string) bool {
+	mu.Lock()
+	defer mu.Unlock()
+	_, ok := listeners[addr]
+	return ok
+}
+
+// Addr is an in-memory address.
+type Addr string
+
+// Network returns the network name, "memory".
+func (a Addr) Network() string {
+	return Network
+}
+
+func (a Addr) String() string {
+	return string(a)
+}
+
+// conn is one end of an in-memory connection.
+type conn struct {
+	net.Conn
+
+	local  net.Addr
+	remote net.Addr
+}
+
+func (c *conn) LocalAddr() net.Addr {
+	return c.local
+}
+
+func (c *conn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+// Stream reports that the connection is not backed by a stream,
+// meaning each read returns one packet.
+func (c *conn) Stream() bool {
+	return false
+}
+
+var _ net.PacketConn = &packetConn{}
+
+// packetConn makes the server end of an in-memory connection act like
+// an unbound connection. As each write is read as one message, it is
+// not a stream.
+//
+// Like network connections, it returns net.ErrClosed once it is closed.
+type packetConn struct {
+	net.Conn
+}
+
+func (c *packetConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	n, err := c.Read(p)
+	return n, c.RemoteAddr(), closedErr(err)
+}
+
+func (c *packetConn) WriteTo(p []byte, _ net.Addr) (int, error) {
+	n, err := c.Write(p)
+	return n, closedErr(err)
+}
+
+func closedErr(err error) error {
+	if errors.Is(err, io.ErrClosedPipe) {
+		return net.ErrClosed
+	}
+	return err
+}
@@ -0,0 +1,99 @@
+package memory
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransport_Dial(t *testing.T) {
+	addr := newTestServer(t, &echoHandler{})
+
+	conn, err := Transport{}.Dial(t.Context(), nil, addr)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+
+	assert.Equal(t, Network, conn.LocalAddr().Network())
+	assert.Equal(t, addr, conn.RemoteAddr().String())
+
+	for _, msg := range []string{"Hello 1", "Hello 2"} {
+		_, err = io.WriteString(conn, msg)
+		require.NoError(t, err)
+
+		got := make([]byte, 512)
+		n, err := conn.Read(got)
+		require.NoError(t, err)
+
+		assert.Equal(t, msg, string(got[:n]))
+	}
+}
+
+func TestTransport_DialErrorsWithoutServer(t *testing.T) {
+	_, err := Transport{}.Dial(t.Context(), nil, "unknown")
+
+	assert.EqualError(t, err, "dialing: memory: no server listening on unknown")
+}
+
+func TestTransport_ListenErrorsOnAddressInUse(t *testing.T) {
+	addr := newTestServer(t, &echoHandler{})
+
+	err := Transport{}.Listen(t.Context(), addr, &echoHandler{})
+
+	assert.EqualError(t, err, "listening: memory: address "+addr+" already in use")
+}
+
+func TestTransport_ListenClosesConnectionsWhenDone(t *testing.T) {
+	addr := t.Name()
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+	errCh := make(chan error, 1)
+	go func() { errCh <- Transport{}.Listen(ctx, addr, &echoHandler{}) }()
+	require.Eventually(t, func() bool { return Listening(addr) }, time.Second, time.Millisecond)
+
+	conn, err := Transport{}.Dial(t.Context(), nil, addr)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+
+	cancel()
+	require.NoError(t, <-errCh)
+
+	_, err = conn.Read(make([]byte, 512))
+	assert.ErrorIs(t, err, io.EOF)
+	assert.False(t, Listening(addr))
+}
+
+func newTestServer(t *testing.T, h *echoHandler) string {
+	t.Helper()
+
+	addr := t.Name()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	go func() {
+		_ = Transport{}.Listen(ctx, addr, h)
+	}()
+	require.Eventually(t, func() bool { return Listening(addr) }, time.Second, time.Millisecond)
+
+	return addr
+}
+
+type echoHandler struct{}
+
+func (e *echoHandler) Serve(conn net.PacketConn) {
+	buf := make([]byte, 512)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+
+		if _, err = conn.WriteTo(buf[:n], addr); err != nil {
+			return
+		}
+	}
+}
@@ -2,14 +2,50 @@ package connqc
 
 import (
 	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
 	"encoding/binary"
 	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
 	"io"
 	"math"
+	"time"
 
 	"github.com/nitrado/connqc/internal/buffr"
 )
 
+// ProtocolVersion is the version of the wire protocol implemented by this package.
+const ProtocolVersion uint16 = 1
+
+// Capability is a bitmap of optional protocol features.
+type Capability uint32
+
+// Capabilities supported by the protocol.
+const (
+	// CapTimestamps indicates that the server fills in the server timestamps of timed probes.
+	CapTimestamps Capability = 1 << iota
+)
+
+// SupportedCapabilities are the capabilities implemented by this package.
+const SupportedCapabilities = CapTimestamps
+
+// Has determines if all the given capabilities are set.
+func (c Capability) Has(o Capability) bool {
+	return c&o == o
+}
+
+const (
+	typeProbe      = "PRB"
+	typeTimedProbe = "TPR"
+	typeHello      = "HLO"
+	typeHelloAck   = "HLA"
+)
+
+// timedProbeHeaderSize is the size of an encoded timed probe without data.
+const timedProbeHeaderSize = len(typeTimedProbe) + 8 + 3*8 + 4 + 2
+
 // Message represents a connqc message.
 type Message interface {
 	unexported()
@@ -23,60 +59,276 @@ type Probe struct {
 
 func (p Probe) unexported() {}
 
+// TimedProbe is a probe message carrying the timestamps required
+// to split the round-trip time into upstream delay, server dwell
+// time and downstream delay.
+//
+// The client sets ClientSend, the server sets ServerRecv and ServerSend.
+// One-way delays are only meaningful if the clocks of both hosts are synchronised,
+// while the server dwell time is measured on the server clock alone.
+//
+// Checksum is the CRC32C checksum of the data, set by the client
+// and left untouched by the server to detect corruption on the path.
+type TimedProbe struct {
+	ID         uint64
+	ClientSend time.Time
+	ServerRecv time.Time
+	ServerSend time.Time
+	Checksum   uint32
+	Data       string
+}
+
+func (p TimedProbe) unexported() {}
+
+// Corrupted determines if the probe data does not match its checksum.
+func (p TimedProbe) Corrupted() bool {
+	return p.Checksum != Checksum(p.Data)
+}
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// Checksum returns the CRC32C checksum of the given probe data.
+func Checksum(data string) uint32 {
+	return crc32.Checksum([]byte(data), castagnoli)
+}
+
+// Hello is sent by the client when it connects, announcing
+// its protocol version and capabilities.
+//
+// Servers that only echo messages send the hello back unchanged.
+type Hello struct {
+	Version      uint16
+	Capabilities Capability
+}
+
+func (h Hello) unexported() {}
+
+// HelloAck is the server answer to a hello, containing the protocol
+// version and the capabilities supported by both sides.
+type HelloAck struct {
+	Version      uint16
+	Capabilities Capability
+}
+
+func (h HelloAck) unexported() {}
+
+// ErrUnauthenticated is returned when a message does not carry a valid HMAC.
+var ErrUnauthenticated = errors.New("message authentication failed")
+
+// macSize is the size of the truncated HMAC-SHA256 appended to authenticated messages.
+const macSize = 16
+
+// The direction labels are authenticated along with every message, so
+// requests cannot be reflected back to their sender as responses.
+var (
+	macLabelRequest  = []byte("connqc request\x00")
+	macLabelResponse = []byte("connqc response\x00")
+)
+
+// CodecOption configures an Encoder or Decoder.
+type CodecOption func(*codecConfig)
+
+type codecConfig struct {
+	secret []byte
+	server bool
+}
+
+// WithHMAC authenticates messages with an HMAC-SHA256 keyed with the given secret.
+//
+// The encoder appends the HMAC to every message, while the decoder
+// verifies it, returning ErrUnauthenticated if it does not match.
+// The HMAC covers the direction of the message, see AsServer.
+func WithHMAC(secret []byte) CodecOption {
+	return func(c *codecConfig) {
+		c.secret = secret
+	}
+}
+
+// AsServer sets the codec up for the server side of a connection, encoding
+// responses and decoding requests. By default, codecs are set up for the
+// client side, encoding requests and decoding responses.
+//
+// The side only matters for authenticated messages, whose direction
+// must match for the HMAC to be valid.
+func AsServer() CodecOption {
+	return func(c *codecConfig) {
+		c.server = true
+	}
+}
+
+// macLabels returns the direction labels of the encoded and decoded messages.
+func (c codecConfig) macLabels() (enc, dec []byte) {
+	if c.server {
+		return macLabelResponse, macLabelRequest
+	}
+	return macLabelRequest, macLabelResponse
+}
+
+func newCodecConfig(opts []CodecOption) codecConfig {
+	var cfg codecConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return cfg
+}
+
 // Encoder encodes messages onto a stream.
 type Encoder struct {
-	w io.Writer
+	w      io.Writer
+	secret []byte
+	label  []byte
 }
 
 // NewEncoder returns an encoder with the given writer.
-func NewEncoder(w io.Writer) Encoder {
-	return Encoder{w: w}
+func NewEncoder(w io.Writer, opts ...CodecOption) Encoder {
+	cfg := newCodecConfig(opts)
+	label, _ := cfg.macLabels()
+
+	return Encoder{
+		w:      w,
+		secret: cfg.secret,
+		label:  label,
+	}
 }
 
 // Encode encodes a message onto the steam.
 func (e Encoder) Encode(msg Message) error {
+	var buf bytes.Buffer
 	switch v := msg.(type) {
 	case Probe:
-		var idBytes [8]byte
-		binary.BigEndian.PutUint64(idBytes[:], v.ID)
-
-		dataLen := len(v.Data)
-		if dataLen > math.MaxUint16 {
-			return errors.New("probe data is too long")
+		buf.WriteString(typeProbe)
+		writeUint64(&buf, v.ID)
+		if err := writeString(&buf, v.Data); err != nil {
+			return err
+		}
+	case TimedProbe:
+		buf.WriteString(typeTimedProbe)
+		writeUint64(&buf, v.ID)
+		writeTime(&buf, v.ClientSend)
+		writeTime(&buf, v.ServerRecv)
+		writeTime(&buf, v.ServerSend)
+		writeUint32(&buf, v.Checksum)
+		if err := writeString(&buf, v.Data); err != nil {
+			return err
 		}
+	case Hello:
+		buf.WriteString(typeHello)
+		writeHello(&buf, v.Version, v.Capabilities)
+	case HelloAck:
+		buf.WriteString(typeHelloAck)
+		writeHello(&buf, v.Version, v.Capabilities)
+	default:
+		return errors.New("unsupported message type")
+	}
 
-		var lenBytes [2]byte
-		binary.BigEndian.PutUint16(lenBytes[:], uint16(dataLen))
+	if e.secret != nil {
+		mac := hmac.New(sha256.New, e.secret)
+		_, _ = mac.Write(e.label)
+		_, _ = mac.Write(buf.Bytes())
+		buf.Write(mac.Sum(nil)[:macSize])
+	}
 
-		var buf bytes.Buffer
-		buf.WriteString("PRB")
-		buf.Write(idBytes[:])
-		buf.Write(lenBytes[:])
-		buf.WriteString(v.Data)
+	// The message is written at once, which is required for packet connections.
+	_, err := e.w.Write(buf.Bytes())
+	return err
+}
 
-		_, err := e.w.Write(buf.Bytes())
-		return err
-	default:
-		return errors.New("unsupported message type")
+func writeUint64(buf *bytes.Buffer, v uint64) {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], v)
+	buf.Write(b[:])
+}
+
+func writeUint32(buf *bytes.Buffer, v uint32) {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], v)
+	buf.Write(b[:])
+}
+
+// writeTime writes the time as nanoseconds since the Unix epoch.
+// The zero time is written as 0.
+func writeTime(buf *bytes.Buffer, t time.Time) {
+	var ns int64
+	if !t.IsZero() {
+		ns = t.UnixNano()
 	}
+	writeUint64(buf, uint64(ns)) //nolint:gosec // The conversion is reversed when decoding.
+}
+
+func writeHello(buf *bytes.Buffer, version uint16, caps Capability) {
+	var b [6]byte
+	binary.BigEndian.PutUint16(b[:2], version)
+	binary.BigEndian.PutUint32(b[2:], uint32(caps))
+	buf.Write(b[:])
+}
+
+func writeString(buf *bytes.Buffer, s string) error {
+	l := len(s)
+	if l > math.MaxUint16 {
+		return errors.New("probe data is too long")
+	}
+
+	var b [2]byte
+	binary.BigEndian.PutUint16(b[:], uint16(l))
+	buf.Write(b[:])
+	buf.WriteString(s)
+	return nil
 }
 
 // Decoder decodes messages from a reader.
 type Decoder struct {
-	r io.Reader
+	r     io.Reader
+	buf   *buffr.Reader
+	mac   *macReader
+	label []byte
 }
 
 // NewDecoder returns a decoder for the given reader.
-func NewDecoder(r io.Reader) Decoder {
-	return Decoder{
-		// The buffered reader solves the issue of reading packets at once (required for UDP) while
-		// still being able to read byte by byte to verify the input.
-		r: buffr.NewReader(r, 1500),
+func NewDecoder(r io.Reader, opts ...CodecOption) Decoder {
+	cfg := newCodecConfig(opts)
+
+	// The buffered reader solves the issue of reading packets at once (required for UDP) while
+	// still being able to read byte by byte to verify the input.
+	var dec Decoder
+	dec.buf = buffr.NewReader(r, 1500)
+	dec.r = dec.buf
+	if cfg.secret != nil {
+		dec.mac = &macReader{r: dec.r, mac: hmac.New(sha256.New, cfg.secret)}
+		dec.r = dec.mac
+		_, dec.label = cfg.macLabels()
 	}
+	return dec
 }
 
 // Decode decodes a message off the stream.
 func (d Decoder) Decode() (Message, error) {
+	if d.mac == nil {
+		return d.decode()
+	}
+
+	d.mac.mac.Reset()
+	_, _ = d.mac.mac.Write(d.label)
+	msg, err := d.decode()
+	if err != nil {
+		return nil, err
+	}
+
+	var got [macSize]byte
+	if _, err = io.ReadFull(d.mac.r, got[:]); err != nil {
+		return nil, err
+	}
+	if !hmac.Equal(got[:], d.mac.mac.Sum(nil)[:macSize]) {
+		return nil, ErrUnauthenticated
+	}
+	return msg, nil
+}
+
+// buffered returns the number of bytes read but not yet decoded.
+func (d Decoder) buffered() int {
+	return d.buf.Buffered()
+}
+
+func (d Decoder) decode() (Message, error) {
 	var typ [3]byte
 	_, err := io.ReadFull(d.r, typ[:])
 	if err != nil {
@@ -84,25 +336,101 @@ func (d Decoder) Decode() (Message, error) {
 	}
 
 	switch string(typ[:]) {
-	case "PRB":
-		var b [10]byte
-		_, err = io.ReadFull(d.r, b[:])
+	case typeProbe:
+		id, err := d.readUint64()
 		if err != nil {
 			return nil, err
 		}
-
-		l := binary.BigEndian.Uint16(b[8:])
-		data := make([]byte, l)
-		_, err = io.ReadFull(d.r, data)
+		data, err := d.readString()
 		if err != nil {
 			return nil, err
 		}
 
 		return Probe{
-			ID:   binary.BigEndian.Uint64(b[:]),
-			Data: string(data),
+			ID:   id,
+			Data: data,
+		}, nil
+	case typeTimedProbe:
+		var b [36]byte
+		_, err = io.ReadFull(d.r, b[:])
+		if err != nil {
+			return nil, err
+		}
+		data, err := d.readString()
+		if err != nil {
+			return nil, err
+		}
+
+		return TimedProbe{
+			ID:         binary.BigEndian.Uint64(b[:8]),
+			ClientSend: decodeTime(b[8:16]),
+			ServerRecv: decodeTime(b[16:24]),
+			ServerSend: decodeTime(b[24:32]),
+			Checksum:   binary.BigEndian.Uint32(b[32:]),
+			Data:       data,
 		}, nil
+	case typeHello:
+		version, caps, err := d.readHello()
+		if err != nil {
+			return nil, err
+		}
+		return Hello{Version: version, Capabilities: caps}, nil
+	case typeHelloAck:
+		version, caps, err := d.readHello()
+		if err != nil {
+			return nil, err
+		}
+		return HelloAck{Version: version, Capabilities: caps}, nil
 	default:
-		return nil, errors.New("unsupported message type")
+		return nil, fmt.Errorf("unsupported message type %q", typ[:])
+	}
+}
+
+func (d Decoder) readUint64() (uint64, error) {
+	var b [8]byte
+	if _, err := io.ReadFull(d.r, b[:]); err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint64(b[:]), nil
+}
+
+func (d Decoder) readHello() (uint16, Capability, error) {
+	var b [6]byte
+	if _, err := io.ReadFull(d.r, b[:]); err != nil {
+		return 0, 0, err
+	}
+	return binary.BigEndian.Uint16(b[:2]), Capability(binary.BigEndian.Uint32(b[2:])), nil
+}
+
+func (d Decoder) readString() (string, error) {
+	var b [2]byte
+	if _, err := io.ReadFull(d.r, b[:]); err != nil {
+		return "", err
+	}
+
+	data := make([]byte, binary.BigEndian.Uint16(b[:]))
+	if _, err := io.ReadFull(d.r, data); err != nil {
+		return "", err
 	}
+	return string(data), nil
+}
+
+func decodeTime(b []byte) time.Time {
+	ns := int64(binary.BigEndian.Uint64(b)) //nolint:gosec // Reverses the encoding conversion.
+	if ns == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, ns)
+}
+
+// macReader feeds all bytes read into a MAC.
+type macReader struct {
+	r   io.Reader
+	mac hash.Hash
+}
+
+func (r *macReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	_, _ = r.mac.Write(p[:n])
+	return n, err
 }
@@ -3,6 +3,7 @@ package connqc_test
 import (
 	"bytes"
 	"testing"
+	"time"
 
 	"github.com/nitrado/connqc"
 	"github.com/stretchr/testify/assert"
@@ -22,6 +23,32 @@ func TestEncoder_Encode(t *testing.T) {
 			wantBytes: []byte{'P', 'R', 'B', 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x2, 0x0, 0x7, 'H', 'e', 'l', 'l', 'o', ' ', '2'},
 			wantErr:   require.NoError,
 		},
+		{
+			name: "handles encoding timed probe",
+			msg:  connqc.TimedProbe{ID: 2, ClientSend: time.Unix(0, 1), ServerRecv: time.Unix(0, 2), Checksum: 0x01020304, Data: "Hi"},
+			wantBytes: []byte{
+				'T', 'P', 'R',
+				0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x2,
+				0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x1,
+				0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x2,
+				0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0,
+				0x1, 0x2, 0x3, 0x4,
+				0x0, 0x2, 'H', 'i',
+			},
+			wantErr: require.NoError,
+		},
+		{
+			name:      "handles encoding hello",
+			msg:       connqc.Hello{Version: 1, Capabilities: connqc.CapTimestamps},
+			wantBytes: []byte{'H', 'L', 'O', 0x0, 0x1, 0x0, 0x0, 0x0, 0x1},
+			wantErr:   require.NoError,
+		},
+		{
+			name:      "handles encoding hello ack",
+			msg:       connqc.HelloAck{Version: 1, Capabilities: connqc.CapTimestamps},
+			wantBytes: []byte{'H', 'L', 'A', 0x0, 0x1, 0x0, 0x0, 0x0, 0x1},
+			wantErr:   require.NoError,
+		},
 	}
 
 	for _, test := range tests {
@@ -37,6 +64,16 @@ func TestEncoder_Encode(t *testing.T) {
 	}
 }
 
+func TestTimedProbe_Corrupted(t *testing.T) {
+	p := connqc.TimedProbe{ID: 1, Checksum: connqc.Checksum("Hello 1"), Data: "Hello 1"}
+
+	assert.False(t, p.Corrupted())
+
+	p.Data = "Hellp 1"
+
+	assert.True(t, p.Corrupted())
+}
+
 func TestDecoder_Decode(t *testing.T) {
 	tests := []struct {
 		name    string
@@ -50,6 +87,37 @@ func TestDecoder_Decode(t *testing.T) {
 			wantMsg: connqc.Probe{ID: 2, Data: "Hello 2"},
 			wantErr: require.NoError,
 		},
+		{
+			name: "handles decoding timed probe",
+			data: []byte{
+				'T', 'P', 'R',
+				0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x2,
+				0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x1,
+				0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x2,
+				0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0,
+				0x1, 0x2, 0x3, 0x4,
+				0x0, 0x2, 'H', 'i',
+			},
+			wantMsg: connqc.TimedProbe{ID: 2, ClientSend: time.Unix(0, 1), ServerRecv: time.Unix(0, 2), Checksum: 0x01020304, Data: "Hi"},
+			wantErr: require.NoError,
+		},
+		{
+			name:    "handles decoding hello",
+			data:    []byte{'H', 'L', 'O', 0x0, 0x1, 0x0, 0x0, 0x0, 0x1},
+			wantMsg: connqc.Hello{Version: 1, Capabilities: connqc.CapTimestamps},
+			wantErr: require.NoError,
+		},
+		{
+			name:    "handles decoding hello ack",
+			data:    []byte{'H', 'L', 'A', 0x0, 0x1, 0x0, 0x0, 0x0, 0x1},
+			wantMsg: connqc.HelloAck{Version: 1, Capabilities: connqc.CapTimestamps},
+			wantErr: require.NoError,
+		},
+		{
+			name:    "handles unsupported message type",
+			data:    []byte{'X', 'Y', 'Z'},
+			wantErr: require.Error,
+		},
 	}
 
 	for _, test := range tests {
@@ -63,3 +131,67 @@ func TestDecoder_Decode(t *testing.T) {
 		})
 	}
 }
+
+func TestDecoder_DecodeWithHMAC(t *testing.T) {
+	buf := bytes.Buffer{}
+	err := connqc.NewEncoder(&buf, connqc.WithHMAC([]byte("secret"))).Encode(connqc.Probe{ID: 2, Data: "Hello 2"})
+	require.NoError(t, err)
+
+	dec := connqc.NewDecoder(bytes.NewReader(buf.Bytes()), connqc.WithHMAC([]byte("secret")), connqc.AsServer())
+
+	got, err := dec.Decode()
+
+	require.NoError(t, err)
+	assert.Equal(t, connqc.Probe{ID: 2, Data: "Hello 2"}, got)
+}
+
+func TestDecoder_DecodeWithHMACRejectsInvalidMessages(t *testing.T) {
+	tests := []struct {
+		name   string
+		secret []byte
+		opts   []connqc.CodecOption
+		modify func([]byte)
+	}{
+		{
+			name:   "wrong secret",
+			secret: []byte("other"),
+			opts:   []connqc.CodecOption{connqc.AsServer()},
+			modify: func([]byte) {},
+		},
+		{
+			name:   "tampered message",
+			secret: []byte("secret"),
+			opts:   []connqc.CodecOption{connqc.AsServer()},
+			modify: func(b []byte) { b[len(b)-macLen-1] = 'x' },
+		},
+		{
+			name:   "tampered hmac",
+			secret: []byte("secret"),
+			opts:   []connqc.CodecOption{connqc.AsServer()},
+			modify: func(b []byte) { b[len(b)-1]++ },
+		},
+		{
+			name:   "reflected request",
+			secret: []byte("secret"),
+			modify: func([]byte) {},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			buf := bytes.Buffer{}
+			err := connqc.NewEncoder(&buf, connqc.WithHMAC([]byte("secret"))).Encode(connqc.Probe{ID: 2, Data: "Hello 2"})
+			require.NoError(t, err)
+			b := buf.Bytes()
+			test.modify(b)
+
+			dec := connqc.NewDecoder(bytes.NewReader(b), append([]connqc.CodecOption{connqc.WithHMAC(test.secret)}, test.opts...)...)
+
+			_, err = dec.Decode()
+
+			assert.ErrorIs(t, err, connqc.ErrUnauthenticated)
+		})
+	}
+}
+
+const macLen = 16
@@ -0,0 +1,192 @@
+package connqc
+
+import "time"
+
+// maxAnswered is the number of answered or lost probes remembered
+// to classify duplicate and late responses.
+const maxAnswered = 1024
+
+type expectation struct {
+	probe    TimedProbe
+	deadline time.Time
+}
+
+type answer struct {
+	exp  expectation
+	lost bool
+}
+
+// outstanding tracks the probes awaiting a response by ID, as well
+// as recently answered and lost probes to classify their responses.
+//
+// Pending probes are held in a ring indexed by ID, bounding the memory
+// used when responses stop arriving. Probe IDs must be added in order.
+type outstanding struct {
+	ring []expectation
+	// pending marks the ring slots holding a pending probe.
+	pending []bool
+	n       int
+	// oldest is the lowest ID that may still be pending.
+	oldest uint64
+	// newest is the highest ID added.
+	newest uint64
+
+	answered      map[uint64]answer
+	answeredOrder []uint64
+
+	// nextExp is the next expected ID, one more than the highest ID answered.
+	nextExp uint64
+}
+
+// newOutstanding returns an outstanding tracker holding at most max pending probes.
+func newOutstanding(max int) *outstanding {
+	return &outstanding{
+		ring:     make([]expectation, max),
+		pending:  make([]bool, max),
+		answered: map[uint64]answer{},
+	}
+}
+
+// Add adds a probe that must be answered before the deadline.
+//
+// If the probe does not fit in the ring, the probes it displaces are
+// evicted, remembering them as lost, and returned.
+func (o *outstanding) Add(p TimedProbe, deadline time.Time) []expectation {
+	if o.n == 0 {
+		o.oldest = p.ID
+	}
+	o.newest = p.ID
+
+	var evicted []expectation
+	size := uint64(len(o.ring))
+	for ; p.ID-o.oldest >= size; o.oldest++ {
+		if exp, ok := o.remove(o.oldest); ok {
+			o.remember(o.oldest, answer{exp: exp, lost: true})
+			evicted = append(evicted, exp)
+		}
+	}
+
+	i := p.ID % size
+	o.ring[i] = expectation{probe: p, deadline: deadline}
+	o.pending[i] = true
+	o.n++
+	return evicted
+}
+
+// get returns the pending probe with the given ID.
+func (o *outstanding) get(id uint64) (expectation, bool) {
+	if o.n == 0 || id < o.oldest || id > o.newest {
+		return expectation{}, false
+	}
+	i := id % uint64(len(o.ring))
+	if !o.pending[i] {
+		return expectation{}, false
+	}
+	return o.ring[i], true
+}
+
+// remove removes the pending probe with the given ID.
+func (o *outstanding) remove(id uint64) (expectation, bool) {
+	exp, ok := o.get(id)
+	if !ok {
+		return expectation{}, false
+	}
+	i := id % uint64(len(o.ring))
+	o.ring[i] = expectation{}
+	o.pending[i] = false
+	o.n--
+	return exp, true
+}
+
+// Lookup returns the expectation of the pending, answered or lost probe
+// with the given ID, without recording a response.
+func (o *outstanding) Lookup(id uint64) (expectation, bool) {
+	if exp, ok := o.get(id); ok {
+		return exp, true
+	}
+	ans, ok := o.answered[id]
+	return ans.exp, ok
+}
+
+// Receive records the response to the probe with the given ID, returning its
+// expectation and arrival. For reordered responses, the reorder distance is returned,
+// being the number of IDs the probe is behind the next expected ID.
+// If the probe is not known, false is returned.
+func (o *outstanding) Receive(id uint64) (expectation, Arrival, uint64, bool) {
+	if exp, ok := o.remove(id); ok {
+		o.remember(id, answer{exp: exp})
+
+		if id < o.nextExp {
+			return exp, ArrivalReordered, o.nextExp - id, true
+		}
+		o.nextExp = id + 1
+		return exp, ArrivalInOrder, 0, true
+	}
+
+	ans, ok := o.answered[id]
+	switch {
+	case !ok:
+		return expectation{}, 0, 0, false
+	case ans.lost:
+		// Further responses to the probe are duplicates.
+		o.answered[id] = answer{exp: ans.exp}
+		return ans.exp, ArrivalLate, 0, true
+	default:
+		return ans.exp, ArrivalDuplicate, 0, true
+	}
+}
+
+// Expire removes and returns the probes whose deadline has passed,
+// remembering them as lost.
+func (o *outstanding) Expire(now time.Time) []expectation {
+	var expired []expectation
+	for ; o.n > 0; o.oldest++ {
+		exp, ok := o.get(o.oldest)
+		if ok && exp.deadline.After(now) {
+			break
+		}
+		if !ok {
+			continue
+		}
+
+		o.remove(o.oldest)
+		o.remember(o.oldest, answer{exp: exp, lost: true})
+		expired = append(expired, exp)
+	}
+	return expired
+}
+
+// Abandon removes and returns all pending probes, as they can no longer be answered.
+func (o *outstanding) Abandon() []expectation {
+	var abandoned []expectation
+	for ; o.n > 0; o.oldest++ {
+		if exp, ok := o.remove(o.oldest); ok {
+			abandoned = append(abandoned, exp)
+		}
+	}
+	return abandoned
+}
+
+func (o *outstanding) remember(id uint64, ans answer) {
+	o.answered[id] = ans
+	o.answeredOrder = append(o.answeredOrder, id)
+	if len(o.answeredOrder) > maxAnswered {
+		delete(o.answered, o.answeredOrder[0])
+		o.answeredOrder = o.answeredOrder[1:]
+	}
+}
+
+// Next returns the earliest deadline of the pending probes.
+func (o *outstanding) Next() (time.Time, bool) {
+	for ; o.n > 0; o.oldest++ {
+		if exp, ok := o.get(o.oldest); ok {
+			return exp.deadline, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// Len returns the number of pending probes.
+func (o *outstanding) Len() int {
+	return o.n
+}
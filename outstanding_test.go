@@ -0,0 +1,136 @@
+package connqc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOutstanding(t *testing.T) {
+	now := time.Now()
+
+	out := newOutstanding(8)
+	for i := 1; i <= 4; i++ {
+		out.Add(TimedProbe{ID: uint64(i)}, now.Add(time.Duration(i)*time.Second))
+	}
+
+	expired := out.Expire(now.Add(time.Second))
+	require.Len(t, expired, 1)
+	assert.Equal(t, uint64(1), expired[0].probe.ID)
+
+	next, ok := out.Next()
+	require.True(t, ok)
+	assert.Equal(t, now.Add(2*time.Second), next)
+
+	tests := []struct {
+		id           uint64
+		wantArrival  Arrival
+		wantDistance uint64
+	}{
+		{id: 3, wantArrival: ArrivalInOrder},
+		{id: 2, wantArrival: ArrivalReordered, wantDistance: 2},
+		{id: 4, wantArrival: ArrivalInOrder},
+		{id: 4, wantArrival: ArrivalDuplicate},
+		{id: 1, wantArrival: ArrivalLate},
+		{id: 1, wantArrival: ArrivalDuplicate},
+	}
+	for _, test := range tests {
+		exp, arr, distance, ok := out.Receive(test.id)
+
+		require.True(t, ok)
+		assert.Equal(t, test.id, exp.probe.ID)
+		assert.Equal(t, test.wantArrival, arr, "id %d", test.id)
+		assert.Equal(t, test.wantDistance, distance, "id %d", test.id)
+	}
+
+	_, _, _, ok = out.Receive(5)
+	assert.False(t, ok)
+	assert.Equal(t, 0, out.Len())
+}
+
+func TestOutstanding_LookupDoesNotRecordResponse(t *testing.T) {
+	now := time.Now()
+
+	out := newOutstanding(8)
+	out.Add(TimedProbe{ID: 1}, now.Add(time.Second))
+	out.Add(TimedProbe{ID: 2}, now.Add(2*time.Second))
+	out.Expire(now.Add(time.Second))
+
+	for _, id := range []uint64{1, 2} {
+		exp, ok := out.Lookup(id)
+		require.True(t, ok)
+		assert.Equal(t, id, exp.probe.ID)
+	}
+	_, ok := out.Lookup(3)
+	assert.False(t, ok)
+
+	_, arr, _, ok := out.Receive(2)
+	require.True(t, ok)
+	assert.Equal(t, ArrivalInOrder, arr)
+	_, arr, _, ok = out.Receive(1)
+	require.True(t, ok)
+	assert.Equal(t, ArrivalLate, arr)
+}
+
+func TestOutstanding_EvictsBeyondMax(t *testing.T) {
+	now := time.Now()
+
+	out := newOutstanding(3)
+	for i := 1; i <= 3; i++ {
+		assert.Empty(t, out.Add(TimedProbe{ID: uint64(i)}, now.Add(time.Minute)))
+	}
+	_, _, _, ok := out.Receive(2)
+	require.True(t, ok)
+
+	evicted := out.Add(TimedProbe{ID: 4}, now.Add(time.Minute))
+	require.Len(t, evicted, 1)
+	assert.Equal(t, uint64(1), evicted[0].probe.ID)
+
+	// The answered probe leaves room for the next probe.
+	assert.Empty(t, out.Add(TimedProbe{ID: 5}, now.Add(time.Minute)))
+	assert.Equal(t, 3, out.Len())
+
+	_, arr, _, ok := out.Receive(1)
+	require.True(t, ok)
+	assert.Equal(t, ArrivalLate, arr)
+}
+
+func TestOutstanding_Abandon(t *testing.T) {
+	now := time.Now()
+
+	out := newOutstanding(8)
+	for i := 1; i <= 3; i++ {
+		out.Add(TimedProbe{ID: uint64(i)}, now.Add(time.Minute))
+	}
+	_, _, _, ok := out.Receive(2)
+	require.True(t, ok)
+
+	abandoned := out.Abandon()
+
+	require.Len(t, abandoned, 2)
+	assert.Equal(t, uint64(1), abandoned[0].probe.ID)
+	assert.Equal(t, uint64(3), abandoned[1].probe.ID)
+	assert.Zero(t, out.Len())
+	assert.Empty(t, out.Abandon())
+}
+
+func TestOutstanding_BoundsMemory(t *testing.T) {
+	now := time.Now()
+
+	out := newOutstanding(16)
+	for i := 1; i <= 100000; i++ {
+		sent := now.Add(time.Duration(i) * time.Millisecond)
+		out.Add(TimedProbe{ID: uint64(i)}, sent.Add(10*time.Millisecond))
+		if i%3 != 0 {
+			out.Receive(uint64(i))
+		}
+		out.Expire(sent)
+	}
+
+	assert.LessOrEqual(t, out.Len(), 16)
+	assert.Len(t, out.ring, 16)
+	assert.LessOrEqual(t, len(out.answered), maxAnswered)
+	assert.LessOrEqual(t, cap(out.answeredOrder), 4*maxAnswered)
+}
@@ -0,0 +1,3 @@
+// Package proxy provides dialers connecting through HTTP CONNECT
+// and SOCKS5 proxies, for clients on locked-down networks.
+package proxy
@@ -0,0 +1,79 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/nitrado/connqc/transport"
+)
+
+// httpDialer tunnels TCP connections through an HTTP proxy using CONNECT.
+type httpDialer struct {
+	addr    string
+	user    string
+	pass    string
+	forward transport.Dialer
+}
+
+// DialContext connects to the address through the proxy.
+func (d *httpDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+	default:
+		return nil, fmt.Errorf("http proxy: network %s not supported", network)
+	}
+
+	start := time.Now()
+	conn, stop, err := dialProxy(ctx, d.forward, d.addr)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := d.connect(conn, addr)
+	if !stop() {
+		_ = conn.Close()
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	return &proxyConn{Conn: conn, r: r, proxy: time.Since(start)}, nil
+}
+
+// connect requests the tunnel, returning the reader holding
+// the data the proxy sent after its response.
+func (d *httpDialer) connect(conn net.Conn, addr string) (*bufio.Reader, error) {
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: http.Header{},
+	}
+	if d.user != "" {
+		creds := base64.StdEncoding.EncodeToString([]byte(d.user + ":" + d.pass))
+		req.Header.Set("Proxy-Authorization", "Basic "+creds)
+	}
+	if err := req.Write(conn); err != nil {
+		return nil, fmt.Errorf("http proxy: writing request: %w", err)
+	}
+
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, req)
+	if err != nil {
+		return nil, fmt.Errorf("http proxy: reading response: %w", err)
+	}
+	_ = resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("http proxy: unexpected response %q", resp.Status)
+	}
+	return r, nil
+}
@@ -0,0 +1,48 @@
+package proxy
+
+import (
+	"net"
+	"net/url"
+	"testing"
+
+	"github.com/nitrado/connqc/transport"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTTPDialer_DialContext(t *testing.T) {
+	target := newTCPEchoServer(t)
+	proxyAddr := newHTTPProxy(t, "user", "pass")
+
+	d, err := FromURL(&url.URL{Scheme: "http", Host: proxyAddr, User: url.UserPassword("user", "pass")}, &net.Dialer{})
+	require.NoError(t, err)
+
+	conn, err := d.DialContext(t.Context(), "tcp", target)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+
+	assertEcho(t, conn)
+	require.Implements(t, (*transport.ProxyTimer)(nil), conn)
+	assert.Positive(t, conn.(transport.ProxyTimer).ProxyDuration())
+}
+
+func TestHTTPDialer_DialContextRejected(t *testing.T) {
+	target := newTCPEchoServer(t)
+	proxyAddr := newHTTPProxy(t, "user", "pass")
+
+	d, err := FromURL(&url.URL{Scheme: "http", Host: proxyAddr, User: url.UserPassword("user", "wrong")}, &net.Dialer{})
+	require.NoError(t, err)
+
+	_, err = d.DialContext(t.Context(), "tcp", target)
+
+	assert.EqualError(t, err, `http proxy: unexpected response "407 Proxy Authentication Required"`)
+}
+
+func TestHTTPDialer_DialContextUDPUnsupported(t *testing.T) {
+	d, err := FromURL(&url.URL{Scheme: "http", Host: "127.0.0.1:3128"}, &net.Dialer{})
+	require.NoError(t, err)
+
+	_, err = d.DialContext(t.Context(), "udp", "127.0.0.1:8123")
+
+	assert.EqualError(t, err, "http proxy: network udp not supported")
+}
@@ -0,0 +1,76 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/nitrado/connqc/transport"
+)
+
+// FromURL returns a dialer connecting through the proxy at the given URL,
+// establishing the connection to the proxy with the forward dialer.
+//
+// Supported are http://[user:pass@]host:port, tunneling TCP connections
+// with CONNECT, and socks5://[user:pass@]host:port, tunneling TCP connections
+// with CONNECT and UDP connections with UDP ASSOCIATE.
+func FromURL(u *url.URL, forward transport.Dialer) (transport.Dialer, error) {
+	var user, pass string
+	if u.User != nil {
+		user = u.User.Username()
+		pass, _ = u.User.Password()
+	}
+
+	switch u.Scheme {
+	case "http":
+		return &httpDialer{addr: hostPort(u, "80"), user: user, pass: pass, forward: forward}, nil
+	case "socks5":
+		return &socks5Dialer{addr: hostPort(u, "1080"), user: user, pass: pass, forward: forward}, nil
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %q", u.Scheme)
+	}
+}
+
+func hostPort(u *url.URL, defaultPort string) string {
+	if u.Port() != "" {
+		return u.Host
+	}
+	return net.JoinHostPort(u.Hostname(), defaultPort)
+}
+
+// dialProxy connects to the proxy, closing the connection when the context
+// is done before the returned stop function is called.
+func dialProxy(ctx context.Context, d transport.Dialer, addr string) (net.Conn, func() bool, error) {
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, nil, fmt.Errorf("dialing proxy: %w", err)
+	}
+
+	stop := context.AfterFunc(ctx, func() { _ = conn.Close() })
+	return conn, stop, nil
+}
+
+var _ transport.ProxyTimer = &proxyConn{}
+
+// proxyConn is a connection tunneled through a proxy.
+type proxyConn struct {
+	net.Conn
+
+	r     io.Reader
+	proxy time.Duration
+}
+
+func (c *proxyConn) Read(p []byte) (int, error) {
+	if c.r != nil {
+		return c.r.Read(p)
+	}
+	return c.Conn.Read(p)
+}
+
+// ProxyDuration returns the duration of connecting through the proxy.
+func (c *proxyConn) ProxyDuration() time.Duration {
+	return c.proxy
+}
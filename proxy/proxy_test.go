@@ -0,0 +1,294 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFromURL_UnsupportedScheme(t *testing.T) {
+	u, err := url.Parse("ftp://127.0.0.1:21")
+	require.NoError(t, err)
+
+	_, err = FromURL(u, &net.Dialer{})
+
+	assert.EqualError(t, err, `unsupported proxy scheme "ftp"`)
+}
+
+// assertEcho checks that a message written to the connection is echoed back.
+func assertEcho(t *testing.T, conn net.Conn) {
+	t.Helper()
+
+	_, err := io.WriteString(conn, "Hello")
+	require.NoError(t, err)
+
+	got := make([]byte, 512)
+	n, err := conn.Read(got)
+	require.NoError(t, err)
+
+	assert.Equal(t, "Hello", string(got[:n]))
+}
+
+// newTCPEchoServer starts a TCP server echoing everything it reads.
+func newTCPEchoServer(t *testing.T) string {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer func() { _ = conn.Close() }()
+				_, _ = io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	return ln.Addr().String()
+}
+
+// newUDPEchoServer starts a UDP server echoing every datagram it reads.
+func newUDPEchoServer(t *testing.T) string {
+	t.Helper()
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = pc.Close() })
+
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			_, _ = pc.WriteTo(buf[:n], addr)
+		}
+	}()
+
+	return pc.LocalAddr().String()
+}
+
+// newHTTPProxy starts an HTTP proxy supporting CONNECT, requiring
+// the given credentials if set.
+func newHTTPProxy(t *testing.T, user, pass string) string {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = ln.Close() })
+
+	wantAuth := ""
+	if user != "" {
+		wantAuth = "Basic " + base64.StdEncoding.EncodeToString([]byte(user+":"+pass))
+	}
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer func() { _ = conn.Close() }()
+
+				req, err := http.ReadRequest(bufio.NewReader(conn))
+				if err != nil {
+					return
+				}
+				if req.Method != http.MethodConnect {
+					_, _ = io.WriteString(conn, "HTTP/1.1 405 Method Not Allowed\r\n\r\n")
+					return
+				}
+				if req.Header.Get("Proxy-Authorization") != wantAuth {
+					_, _ = io.WriteString(conn, "HTTP/1.1 407 Proxy Authentication Required\r\n\r\n")
+					return
+				}
+
+				target, err := net.Dial("tcp", req.Host)
+				if err != nil {
+					_, _ = io.WriteString(conn, "HTTP/1.1 502 Bad Gateway\r\n\r\n")
+					return
+				}
+				defer func() { _ = target.Close() }()
+
+				_, _ = io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+				pipe(conn, target)
+			}()
+		}
+	}()
+
+	return ln.Addr().String()
+}
+
+// newSOCKS5Proxy starts a SOCKS5 proxy supporting CONNECT and UDP ASSOCIATE,
+// requiring the given credentials if set.
+func newSOCKS5Proxy(t *testing.T, user, pass string) string {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer func() { _ = conn.Close() }()
+
+				serveSOCKS5(conn, user, pass)
+			}()
+		}
+	}()
+
+	return ln.Addr().String()
+}
+
+func serveSOCKS5(conn net.Conn, user, pass string) {
+	var hdr [2]byte
+	if _, err := io.ReadFull(conn, hdr[:]); err != nil {
+		return
+	}
+	methods := make([]byte, hdr[1])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return
+	}
+
+	want := byte(socksAuthNone)
+	if user != "" {
+		want = socksAuthPassword
+	}
+	if !bytes.Contains(methods, []byte{want}) {
+		_, _ = conn.Write([]byte{socksVersion, socksAuthNoAcceptable})
+		return
+	}
+	_, _ = conn.Write([]byte{socksVersion, want})
+
+	if user != "" {
+		r := bufio.NewReader(conn)
+		_, _ = r.ReadByte()
+		gotUser := readString(r)
+		gotPass := readString(r)
+		if gotUser != user || gotPass != pass {
+			_, _ = conn.Write([]byte{0x01, 0x01})
+			return
+		}
+		_, _ = conn.Write([]byte{0x01, 0x00})
+	}
+
+	var req [3]byte
+	if _, err := io.ReadFull(conn, req[:]); err != nil {
+		return
+	}
+	addr, err := readAddr(conn)
+	if err != nil {
+		return
+	}
+
+	switch req[1] {
+	case socksCmdConnect:
+		target, err := net.Dial("tcp", addr)
+		if err != nil {
+			_, _ = conn.Write([]byte{socksVersion, 0x05, 0x00, socksAddrIPv4, 0, 0, 0, 0, 0, 0})
+			return
+		}
+		defer func() { _ = target.Close() }()
+
+		reply(conn, target.LocalAddr().String())
+		pipe(conn, target)
+	case socksCmdUDPAssociate:
+		relay, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			return
+		}
+		defer func() { _ = relay.Close() }()
+
+		reply(conn, relay.LocalAddr().String())
+		go relayUDP(relay)
+
+		// The association ends when the control connection closes.
+		_, _ = io.Copy(io.Discard, conn)
+	default:
+		_, _ = conn.Write([]byte{socksVersion, 0x07, 0x00, socksAddrIPv4, 0, 0, 0, 0, 0, 0})
+	}
+}
+
+func relayUDP(relay net.PacketConn) {
+	var client net.Addr
+	buf := make([]byte, 1500)
+	for {
+		n, from, err := relay.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+
+		if client == nil || from.String() == client.String() {
+			client = from
+
+			r := bytes.NewReader(buf[3:n])
+			addr, err := readAddr(r)
+			if err != nil {
+				continue
+			}
+			to, err := net.ResolveUDPAddr("udp", addr)
+			if err != nil {
+				continue
+			}
+			_, _ = relay.WriteTo(buf[n-r.Len():n], to)
+			continue
+		}
+
+		var resp bytes.Buffer
+		resp.Write([]byte{0x00, 0x00, 0x00})
+		_ = writeAddr(&resp, from.String())
+		resp.Write(buf[:n])
+		_, _ = relay.WriteTo(resp.Bytes(), client)
+	}
+}
+
+func reply(conn net.Conn, bound string) {
+	var resp bytes.Buffer
+	resp.Write([]byte{socksVersion, 0x00, 0x00})
+	_ = writeAddr(&resp, bound)
+	_, _ = conn.Write(resp.Bytes())
+}
+
+func readString(r *bufio.Reader) string {
+	n, err := r.ReadByte()
+	if err != nil {
+		return ""
+	}
+	b := make([]byte, n)
+	_, _ = io.ReadFull(r, b)
+	return string(b)
+}
+
+func pipe(a, b net.Conn) {
+	done := make(chan struct{}, 2)
+	go func() {
+		_, _ = io.Copy(a, b)
+		done <- struct{}{}
+	}()
+	go func() {
+		_, _ = io.Copy(b, a)
+		done <- struct{}{}
+	}()
+	<-done
+}
@@ -0,0 +1,333 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/nitrado/connqc/transport"
+)
+
+// SOCKS5 protocol constants, see RFC 1928 and RFC 1929.
+const (
+	socksVersion = 0x05
+
+	socksAuthNone         = 0x00
+	socksAuthPassword     = 0x02
+	socksAuthNoAcceptable = 0xff
+
+	socksCmdConnect      = 0x01
+	socksCmdUDPAssociate = 0x03
+
+	socksAddrIPv4   = 0x01
+	socksAddrDomain = 0x03
+	socksAddrIPv6   = 0x04
+
+	// socksMaxUDPHeader is the size of the longest UDP request header,
+	// holding a domain name of 255 bytes.
+	socksMaxUDPHeader = 3 + 1 + 1 + 255 + 2
+)
+
+// socks5Dialer tunnels TCP connections through a SOCKS5 proxy using CONNECT,
+// and UDP connections using UDP ASSOCIATE.
+type socks5Dialer struct {
+	addr    string
+	user    string
+	pass    string
+	forward transport.Dialer
+}
+
+// DialContext connects to the address through the proxy.
+func (d *socks5Dialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	var cmd byte
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+		cmd = socksCmdConnect
+	case "udp", "udp4", "udp6":
+		cmd = socksCmdUDPAssociate
+	default:
+		return nil, fmt.Errorf("socks5 proxy: network %s not supported", network)
+	}
+
+	start := time.Now()
+	conn, stop, err := dialProxy(ctx, d.forward, d.addr)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := d.connect(ctx, conn, cmd, network, addr)
+	if !stop() {
+		if c != nil {
+			_ = c.Close()
+		}
+		_ = conn.Close()
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	switch c := c.(type) {
+	case *udpConn:
+		c.proxy = time.Since(start)
+		return c, nil
+	default:
+		return &proxyConn{Conn: conn, proxy: time.Since(start)}, nil
+	}
+}
+
+func (d *socks5Dialer) connect(ctx context.Context, conn net.Conn, cmd byte, network, addr string) (net.Conn, error) {
+	if err := d.authenticate(conn); err != nil {
+		return nil, err
+	}
+
+	if cmd == socksCmdConnect {
+		if _, err := request(conn, cmd, addr); err != nil {
+			return nil, err
+		}
+		return conn, nil
+	}
+
+	// The address the datagrams are sent from is not known upfront.
+	relay, err := request(conn, cmd, "0.0.0.0:0")
+	if err != nil {
+		return nil, err
+	}
+	if host, port, err := net.SplitHostPort(relay); err == nil {
+		if ip := net.ParseIP(host); ip != nil && ip.IsUnspecified() {
+			proxyHost, _, _ := net.SplitHostPort(d.addr)
+			relay = net.JoinHostPort(proxyHost, port)
+		}
+	}
+
+	var header bytes.Buffer
+	header.Write([]byte{0x00, 0x00, 0x00}) // Reserved and fragment number.
+	if err = writeAddr(&header, addr); err != nil {
+		return nil, err
+	}
+
+	pc, err := d.forward.DialContext(ctx, network, relay)
+	if err != nil {
+		return nil, fmt.Errorf("socks5 proxy: dialing relay: %w", err)
+	}
+	return &udpConn{Conn: pc, ctrl: conn, header: header.Bytes()}, nil
+}
+
+// authenticate negotiates the authentication method and authenticates
+// with the username and password, if required by the proxy.
+func (d *socks5Dialer) authenticate(conn net.Conn) error {
+	methods := []byte{socksAuthNone}
+	if d.user != "" {
+		methods = append(methods, socksAuthPassword)
+	}
+	greeting := append([]byte{socksVersion, byte(len(methods))}, methods...)
+	if _, err := conn.Write(greeting); err != nil {
+		return fmt.Errorf("socks5 proxy: writing greeting: %w", err)
+	}
+
+	var resp [2]byte
+	if _, err := io.ReadFull(conn, resp[:]); err != nil {
+		return fmt.Errorf("socks5 proxy: reading greeting: %w", err)
+	}
+	if resp[0] != socksVersion {
+		return fmt.Errorf("socks5 proxy: unexpected version %d", resp[0])
+	}
+
+	switch resp[1] {
+	case socksAuthNone:
+		return nil
+	case socksAuthPassword:
+	case socksAuthNoAcceptable:
+		return errors.New("socks5 proxy: no acceptable authentication method")
+	default:
+		return fmt.Errorf("socks5 proxy: unsupported authentication method %d", resp[1])
+	}
+
+	if len(d.user) > 255 || len(d.pass) > 255 {
+		return errors.New("socks5 proxy: username or password too long")
+	}
+	var req bytes.Buffer
+	req.WriteByte(0x01)
+	req.WriteByte(byte(len(d.user)))
+	req.WriteString(d.user)
+	req.WriteByte(byte(len(d.pass)))
+	req.WriteString(d.pass)
+	if _, err := conn.Write(req.Bytes()); err != nil {
+		return fmt.Errorf("socks5 proxy: writing credentials: %w", err)
+	}
+
+	if _, err := io.ReadFull(conn, resp[:]); err != nil {
+		return fmt.Errorf("socks5 proxy: reading authentication status: %w", err)
+	}
+	if resp[1] != 0x00 {
+		return errors.New("socks5 proxy: authentication failed")
+	}
+	return nil
+}
+
+// request sends the command for the address, returning the address bound by the proxy.
+func request(conn net.Conn, cmd byte, addr string) (string, error) {
+	var req bytes.Buffer
+	req.Write([]byte{socksVersion, cmd, 0x00})
+	if err := writeAddr(&req, addr); err != nil {
+		return "", err
+	}
+	if _, err := conn.Write(req.Bytes()); err != nil {
+		return "", fmt.Errorf("socks5 proxy: writing request: %w", err)
+	}
+
+	var resp [3]byte
+	if _, err := io.ReadFull(conn, resp[:]); err != nil {
+		return "", fmt.Errorf("socks5 proxy: reading reply: %w", err)
+	}
+	if resp[1] != 0x00 {
+		return "", fmt.Errorf("socks5 proxy: request failed with reply %d", resp[1])
+	}
+
+	bound, err := readAddr(conn)
+	if err != nil {
+		return "", fmt.Errorf("socks5 proxy: reading bound address: %w", err)
+	}
+	return bound, nil
+}
+
+func writeAddr(buf *bytes.Buffer, addr string) error {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("socks5 proxy: parsing address: %w", err)
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return fmt.Errorf("socks5 proxy: parsing port: %w", err)
+	}
+
+	switch ip := net.ParseIP(host); {
+	case ip != nil && ip.To4() != nil:
+		buf.WriteByte(socksAddrIPv4)
+		buf.Write(ip.To4())
+	case ip != nil:
+		buf.WriteByte(socksAddrIPv6)
+		buf.Write(ip.To16())
+	default:
+		if len(host) > 255 {
+			return errors.New("socks5 proxy: host name too long")
+		}
+		buf.WriteByte(socksAddrDomain)
+		buf.WriteByte(byte(len(host)))
+		buf.WriteString(host)
+	}
+
+	return binary.Write(buf, binary.BigEndian, uint16(port))
+}
+
+func readAddr(r io.Reader) (string, error) {
+	var typ [1]byte
+	if _, err := io.ReadFull(r, typ[:]); err != nil {
+		return "", err
+	}
+
+	var host string
+	switch typ[0] {
+	case socksAddrIPv4, socksAddrIPv6:
+		ip := make(net.IP, net.IPv4len)
+		if typ[0] == socksAddrIPv6 {
+			ip = make(net.IP, net.IPv6len)
+		}
+		if _, err := io.ReadFull(r, ip); err != nil {
+			return "", err
+		}
+		host = ip.String()
+	case socksAddrDomain:
+		var n [1]byte
+		if _, err := io.ReadFull(r, n[:]); err != nil {
+			return "", err
+		}
+		b := make([]byte, n[0])
+		if _, err := io.ReadFull(r, b); err != nil {
+			return "", err
+		}
+		host = string(b)
+	default:
+		return "", fmt.Errorf("unsupported address type %d", typ[0])
+	}
+
+	var port uint16
+	if err := binary.Read(r, binary.BigEndian, &port); err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(port))), nil
+}
+
+var _ transport.ProxyTimer = &udpConn{}
+
+// udpConn is a UDP connection relayed through a SOCKS5 proxy.
+//
+// The association lasts as long as the control connection is open.
+type udpConn struct {
+	net.Conn
+
+	ctrl   net.Conn
+	header []byte
+	proxy  time.Duration
+
+	// buf is the buffer datagrams are read into, reused across reads.
+	buf []byte
+}
+
+// Read reads the payload of the next datagram from the relay.
+func (c *udpConn) Read(p []byte) (int, error) {
+	if size := len(p) + socksMaxUDPHeader; len(c.buf) < size {
+		c.buf = make([]byte, size)
+	}
+	buf := c.buf
+	for {
+		n, err := c.Conn.Read(buf)
+		if err != nil {
+			return 0, err
+		}
+
+		r := bytes.NewReader(buf[:n])
+		var hdr [3]byte
+		if _, err = io.ReadFull(r, hdr[:]); err != nil {
+			continue
+		}
+		// Fragmented datagrams are not supported and are dropped.
+		if hdr[2] != 0x00 {
+			continue
+		}
+		if _, err = readAddr(r); err != nil {
+			continue
+		}
+		return copy(p, buf[n-r.Len():n]), nil
+	}
+}
+
+// Write sends the datagram to the destination through the relay.
+func (c *udpConn) Write(p []byte) (int, error) {
+	buf := make([]byte, 0, len(c.header)+len(p))
+	buf = append(buf, c.header...)
+	buf = append(buf, p...)
+	if _, err := c.Conn.Write(buf); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close closes the relayed connection and ends the association.
+func (c *udpConn) Close() error {
+	err := c.Conn.Close()
+	_ = c.ctrl.Close()
+	return err
+}
+
+// ProxyDuration returns the duration of associating with the proxy.
+func (c *udpConn) ProxyDuration() time.Duration {
+	return c.proxy
+}
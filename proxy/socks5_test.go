@@ -0,0 +1,116 @@
+package proxy
+
+import (
+	"net"
+	"net/url"
+	"testing"
+
+	"github.com/nitrado/connqc/transport"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSOCKS5Dialer_DialContext(t *testing.T) {
+	tests := []struct {
+		name    string
+		network string
+		target  func(t *testing.T) string
+		user    *url.Userinfo
+	}{
+		{
+			name:    "tcp",
+			network: "tcp",
+			target:  newTCPEchoServer,
+		},
+		{
+			name:    "tcp with credentials",
+			network: "tcp",
+			target:  newTCPEchoServer,
+			user:    url.UserPassword("user", "pass"),
+		},
+		{
+			name:    "udp",
+			network: "udp",
+			target:  newUDPEchoServer,
+			user:    url.UserPassword("user", "pass"),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			target := test.target(t)
+			var user, pass string
+			if test.user != nil {
+				user = test.user.Username()
+				pass, _ = test.user.Password()
+			}
+			proxyAddr := newSOCKS5Proxy(t, user, pass)
+
+			d, err := FromURL(&url.URL{Scheme: "socks5", Host: proxyAddr, User: test.user}, &net.Dialer{})
+			require.NoError(t, err)
+
+			conn, err := d.DialContext(t.Context(), test.network, target)
+			require.NoError(t, err)
+			t.Cleanup(func() { _ = conn.Close() })
+
+			assertEcho(t, conn)
+			require.Implements(t, (*transport.ProxyTimer)(nil), conn)
+			assert.Positive(t, conn.(transport.ProxyTimer).ProxyDuration())
+		})
+	}
+}
+
+func TestSOCKS5Dialer_DialContextUDPReadsDatagramsInTurn(t *testing.T) {
+	target := newUDPEchoServer(t)
+	proxyAddr := newSOCKS5Proxy(t, "", "")
+
+	d, err := FromURL(&url.URL{Scheme: "socks5", Host: proxyAddr}, &net.Dialer{})
+	require.NoError(t, err)
+
+	conn, err := d.DialContext(t.Context(), "udp", target)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+
+	// The read buffer is reused, growing with the buffers read into.
+	for _, test := range []struct {
+		data string
+		size int
+	}{
+		{data: "Hello 1", size: 16},
+		{data: "Hello, World 2", size: 512},
+		{data: "Hello 3", size: 16},
+	} {
+		_, err = conn.Write([]byte(test.data))
+		require.NoError(t, err)
+
+		got := make([]byte, test.size)
+		n, err := conn.Read(got)
+		require.NoError(t, err)
+
+		assert.Equal(t, test.data, string(got[:n]))
+	}
+}
+
+func TestSOCKS5Dialer_DialContextAuthenticationFailed(t *testing.T) {
+	target := newTCPEchoServer(t)
+	proxyAddr := newSOCKS5Proxy(t, "user", "pass")
+
+	d, err := FromURL(&url.URL{Scheme: "socks5", Host: proxyAddr, User: url.UserPassword("user", "wrong")}, &net.Dialer{})
+	require.NoError(t, err)
+
+	_, err = d.DialContext(t.Context(), "tcp", target)
+
+	assert.EqualError(t, err, "socks5 proxy: authentication failed")
+}
+
+func TestSOCKS5Dialer_DialContextNoAcceptableMethod(t *testing.T) {
+	target := newTCPEchoServer(t)
+	proxyAddr := newSOCKS5Proxy(t, "user", "pass")
+
+	d, err := FromURL(&url.URL{Scheme: "socks5", Host: proxyAddr}, &net.Dialer{})
+	require.NoError(t, err)
+
+	_, err = d.DialContext(t.Context(), "tcp", target)
+
+	assert.EqualError(t, err, "socks5 proxy: no acceptable authentication method")
+}
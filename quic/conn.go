@@ -0,0 +1,323 @@
+package quic
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/nitrado/connqc/transport"
+	"github.com/quic-go/quic-go"
+)
+
+// packetConn makes a connected UDP connection, such as one established
+// through a proxy, act like the unbound connection QUIC expects.
+type packetConn struct {
+	net.Conn
+}
+
+func (c *packetConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	n, err := c.Read(p)
+	return n, c.RemoteAddr(), err
+}
+
+func (c *packetConn) WriteTo(p []byte, _ net.Addr) (int, error) {
+	return c.Write(p)
+}
+
+// SetReadBuffer sets the receive buffer size of the underlying connection,
+// if it supports it, as QUIC benefits from larger buffers.
+func (c *packetConn) SetReadBuffer(bytes int) error {
+	if bc, ok := c.Conn.(interface{ SetReadBuffer(int) error }); ok {
+		return bc.SetReadBuffer(bytes)
+	}
+	return nil
+}
+
+// SetWriteBuffer sets the send buffer size of the underlying connection,
+// if it supports it, as QUIC benefits from larger buffers.
+func (c *packetConn) SetWriteBuffer(bytes int) error {
+	if bc, ok := c.Conn.(interface{ SetWriteBuffer(int) error }); ok {
+		return bc.SetWriteBuffer(bytes)
+	}
+	return nil
+}
+
+var (
+	_ transport.HandshakeTimer = &baseConn{}
+	_ transport.Resumer        = &baseConn{}
+)
+
+// baseConn is a client QUIC connection, owning the underlying UDP connection.
+//
+// The connection may be used before its handshake has completed.
+type baseConn struct {
+	conn *quic.Conn
+	raw  net.Conn
+
+	handshake *handshake
+}
+
+// handshake records the completion of a handshake.
+type handshake struct {
+	// done is closed once the handshake has completed or failed.
+	done     chan struct{}
+	duration time.Duration
+}
+
+func newBaseConn(conn *quic.Conn, raw net.Conn, start time.Time) baseConn {
+	hs := &handshake{done: make(chan struct{})}
+	go func() {
+		defer close(hs.done)
+
+		<-conn.HandshakeComplete()
+		hs.duration = time.Since(start)
+	}()
+
+	return baseConn{conn: conn, raw: raw, handshake: hs}
+}
+
+// HandshakeDuration returns the duration of the QUIC handshake,
+// waiting for the handshake to complete.
+func (c *baseConn) HandshakeDuration() time.Duration {
+	<-c.handshake.done
+	return c.handshake.duration
+}
+
+// Resumed reports whether the connection resumed a previous session with 0-RTT,
+// waiting for the handshake to complete to know if the server accepted it.
+func (c *baseConn) Resumed() bool {
+	<-c.handshake.done
+	return c.conn.ConnectionState().Used0RTT
+}
+
+func (c *baseConn) LocalAddr() net.Addr {
+	return c.conn.LocalAddr()
+}
+
+func (c *baseConn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+func (c *baseConn) Close() error {
+	err := c.conn.CloseWithError(0, "")
+	_ = c.raw.Close()
+	return err
+}
+
+var _ net.Conn = &datagramConn{}
+
+// datagramConn is a client connection sending and receiving QUIC datagrams.
+type datagramConn struct {
+	baseConn
+
+	deadline deadline
+}
+
+func (c *datagramConn) Read(p []byte) (int, error) {
+	b, err := receive(c.conn, &c.deadline)
+	if err != nil {
+		return 0, err
+	}
+	return copy(p, b), nil
+}
+
+func (c *datagramConn) Write(p []byte) (int, error) {
+	if err := c.conn.SendDatagram(p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (c *datagramConn) SetDeadline(t time.Time) error {
+	return c.SetReadDeadline(t)
+}
+
+func (c *datagramConn) SetReadDeadline(t time.Time) error {
+	c.deadline.set(t)
+	return nil
+}
+
+// SetWriteDeadline does nothing, as sending datagrams does not block.
+func (c *datagramConn) SetWriteDeadline(time.Time) error {
+	return nil
+}
+
+// Stream reports that the connection is not backed by a stream,
+// meaning each read returns one packet.
+func (c *datagramConn) Stream() bool {
+	return false
+}
+
+var _ net.Conn = &streamConn{}
+
+// streamConn is a client connection sending and receiving on a QUIC stream.
+type streamConn struct {
+	baseConn
+	*quic.Stream
+}
+
+func (c *streamConn) Close() error {
+	return c.baseConn.Close()
+}
+
+var _ net.PacketConn = &datagramPacketConn{}
+
+// datagramPacketConn makes the datagrams of a server QUIC connection
+// act like an unbound connection.
+type datagramPacketConn struct {
+	conn    *quic.Conn
+	pending []byte
+
+	deadline deadline
+}
+
+func (c *datagramPacketConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	b := c.pending
+	c.pending = nil
+	if b == nil {
+		var err error
+		if b, err = receive(c.conn, &c.deadline); err != nil {
+			return 0, nil, err
+		}
+	}
+	return copy(p, b), c.conn.RemoteAddr(), nil
+}
+
+func (c *datagramPacketConn) WriteTo(p []byte, _ net.Addr) (int, error) {
+	if err := c.conn.SendDatagram(p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Identity returns the subject of the verified client certificate,
+// or an empty string if the client did not present one.
+func (c *datagramPacketConn) Identity() string {
+	return identity(c.conn)
+}
+
+func (c *datagramPacketConn) Close() error {
+	return c.conn.CloseWithError(0, "")
+}
+
+func (c *datagramPacketConn) LocalAddr() net.Addr {
+	return c.conn.LocalAddr()
+}
+
+func (c *datagramPacketConn) SetDeadline(t time.Time) error {
+	return c.SetReadDeadline(t)
+}
+
+func (c *datagramPacketConn) SetReadDeadline(t time.Time) error {
+	c.deadline.set(t)
+	return nil
+}
+
+// SetWriteDeadline does nothing, as sending datagrams does not block.
+func (c *datagramPacketConn) SetWriteDeadline(time.Time) error {
+	return nil
+}
+
+var _ net.PacketConn = &streamPacketConn{}
+
+// streamPacketConn makes a stream of a server QUIC connection
+// act like an unbound connection.
+type streamPacketConn struct {
+	conn *quic.Conn
+	str  *quic.Stream
+}
+
+func (c *streamPacketConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	n, err := c.str.Read(p)
+	return n, c.conn.RemoteAddr(), err
+}
+
+func (c *streamPacketConn) WriteTo(p []byte, _ net.Addr) (int, error) {
+	return c.str.Write(p)
+}
+
+// Stream reports that the connection is backed by a stream,
+// meaning reads do not preserve message boundaries.
+func (c *streamPacketConn) Stream() bool {
+	return true
+}
+
+// Identity returns the subject of the verified client certificate,
+// or an empty string if the client did not present one.
+func (c *streamPacketConn) Identity() string {
+	return identity(c.conn)
+}
+
+func (c *streamPacketConn) Close() error {
+	return c.str.Close()
+}
+
+func (c *streamPacketConn) LocalAddr() net.Addr {
+	return c.conn.LocalAddr()
+}
+
+func (c *streamPacketConn) SetDeadline(t time.Time) error {
+	return c.str.SetDeadline(t)
+}
+
+func (c *streamPacketConn) SetReadDeadline(t time.Time) error {
+	return c.str.SetReadDeadline(t)
+}
+
+func (c *streamPacketConn) SetWriteDeadline(t time.Time) error {
+	return c.str.SetWriteDeadline(t)
+}
+
+// deadline is the read deadline of a datagram connection.
+type deadline struct {
+	mu sync.Mutex
+	t  time.Time
+}
+
+func (d *deadline) set(t time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.t = t
+}
+
+func (d *deadline) get() time.Time {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.t
+}
+
+// receive returns the next datagram of the connection, waiting until the
+// deadline at the latest. A deadline set while waiting applies to the next call.
+//
+// Once the connection is closed, an error wrapping net.ErrClosed is returned.
+func receive(conn *quic.Conn, d *deadline) ([]byte, error) {
+	ctx := context.Background()
+	if t := d.get(); !t.IsZero() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, t)
+		defer cancel()
+	}
+
+	b, err := conn.ReceiveDatagram(ctx)
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return nil, os.ErrDeadlineExceeded
+	case err != nil:
+		return nil, fmt.Errorf("%w: %w", net.ErrClosed, err)
+	}
+	return b, nil
+}
+
+// identity returns the subject of the verified client certificate
+// of the connection, or an empty string if the client did not present one.
+func identity(conn *quic.Conn) string {
+	state := conn.ConnectionState().TLS
+	if len(state.PeerCertificates) == 0 {
+		return ""
+	}
+	return state.PeerCertificates[0].Subject.String()
+}
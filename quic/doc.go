@@ -0,0 +1,7 @@
+// Package quic provides the QUIC transport, carrying probe messages either
+// in unreliable QUIC datagrams, comparable to UDP, or over a reliable QUIC
+// stream, comparable to TCP.
+//
+// The transport is registered as "quic" for datagrams and as "quic-stream"
+// for streams. Servers listening with either accept both.
+package quic
@@ -0,0 +1,197 @@
+package quic
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/nitrado/connqc/transport"
+	"github.com/quic-go/quic-go"
+)
+
+// ALPN is the application protocol negotiated on QUIC connections.
+const ALPN = "connqc"
+
+var testHookServerServe func(net.PacketConn)
+
+func init() {
+	transport.Register("quic", NewTransport(&tls.Config{MinVersion: tls.VersionTLS13}))
+	transport.Register("quic-stream", NewTransport(&tls.Config{MinVersion: tls.VersionTLS13}, WithStream()))
+}
+
+// Option configures a transport.
+type Option func(*Transport)
+
+// WithStream sends probes over a QUIC stream instead of in QUIC datagrams.
+func WithStream() Option {
+	return func(t *Transport) {
+		t.stream = true
+	}
+}
+
+var _ transport.Transport = &Transport{}
+
+// Transport is the QUIC transport.
+//
+// The registered transports verify servers against the system roots and
+// cannot listen, as they have no certificate. Use NewTransport for a transport
+// with a configuration.
+type Transport struct {
+	config   *tls.Config
+	stream   bool
+	sessions tls.ClientSessionCache
+}
+
+// NewTransport returns a QUIC transport with the given TLS configuration.
+//
+// Clients resume the sessions of previous connections with 0-RTT, if the
+// server allows it, sending the first messages before the handshake has
+// completed. If the server rejects 0-RTT, the datagrams sent early are lost,
+// while streams fail. QUIC requires TLS 1.3, regardless of the configuration.
+// A nil configuration is treated as the zero configuration.
+func NewTransport(cfg *tls.Config, opts ...Option) *Transport {
+	t := &Transport{
+		config:   cfg,
+		sessions: tls.NewLRUClientSessionCache(0),
+	}
+	for _, opt := range opts {
+		opt(t)
+	}
+	return t
+}
+
+// Dial returns a new QUIC connection over a UDP connection established
+// with the dialer.
+//
+// Connections resuming a previous session are returned before the handshake
+// has completed, so messages can be sent with 0-RTT. Other connections are
+// returned once the handshake has completed.
+func (t *Transport) Dial(ctx context.Context, d transport.Dialer, addr string) (net.Conn, error) {
+	cfg := t.tlsConfig()
+	if cfg.ServerName == "" {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, fmt.Errorf("parsing address: %w", err)
+		}
+		cfg.ServerName = host
+	}
+	if cfg.ClientSessionCache == nil {
+		cfg.ClientSessionCache = t.sessions
+	}
+
+	raw, err := d.DialContext(ctx, "udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("dialing: %w", err)
+	}
+	pc := &packetConn{Conn: raw}
+
+	start := time.Now()
+	conn, err := quic.DialEarly(ctx, pc, raw.RemoteAddr(), cfg, &quic.Config{EnableDatagrams: true})
+	if err != nil {
+		_ = raw.Close()
+		return nil, fmt.Errorf("handshake: %w", err)
+	}
+	base := newBaseConn(conn, raw, start)
+
+	if !t.stream {
+		if !conn.ConnectionState().SupportsDatagrams {
+			_ = base.Close()
+			return nil, errors.New("quic: server does not support datagrams")
+		}
+		return &datagramConn{baseConn: base}, nil
+	}
+
+	str, err := conn.OpenStreamSync(ctx)
+	if err != nil {
+		_ = base.Close()
+		return nil, fmt.Errorf("opening stream: %w", err)
+	}
+	return &streamConn{baseConn: base, Stream: str}, nil
+}
+
+// Listen serves QUIC connections on the address with the given handler.
+//
+// The datagrams of each connection and each of its streams are passed off
+// to the handler separately.
+func (t *Transport) Listen(ctx context.Context, addr string, h transport.Handler) error {
+	cfg := t.tlsConfig()
+	if len(cfg.Certificates) == 0 && cfg.GetCertificate == nil && cfg.GetConfigForClient == nil {
+		return errors.New("quic: server certificate required")
+	}
+
+	lc := &net.ListenConfig{}
+	pc, err := lc.ListenPacket(ctx, "udp", addr)
+	if err != nil {
+		return fmt.Errorf("listening: %w", err)
+	}
+	defer func() { _ = pc.Close() }()
+
+	ln, err := quic.ListenEarly(pc, cfg, &quic.Config{EnableDatagrams: true, Allow0RTT: true})
+	if err != nil {
+		return fmt.Errorf("listening: %w", err)
+	}
+	defer func() { _ = ln.Close() }()
+
+	if testHookServerServe != nil {
+		testHookServerServe(pc)
+	}
+
+	for {
+		conn, err := ln.Accept(ctx)
+		switch {
+		case err != nil && ctx.Err() != nil:
+			return net.ErrClosed
+		case err != nil:
+			return fmt.Errorf("accepting connection: %w", err)
+		}
+
+		go serveConn(ctx, conn, h)
+	}
+}
+
+// tlsConfig returns a copy of the TLS configuration suitable for QUIC,
+// treating nil as the zero configuration.
+func (t *Transport) tlsConfig() *tls.Config {
+	cfg := &tls.Config{} //nolint:gosec // The minimum version is set below.
+	if t.config != nil {
+		cfg = t.config.Clone()
+	}
+	cfg.MinVersion = tls.VersionTLS13
+	if len(cfg.NextProtos) == 0 {
+		cfg.NextProtos = []string{ALPN}
+	}
+	return cfg
+}
+
+// serveConn passes the datagrams and streams of a connection off to the
+// handler until the connection or the context is done.
+func serveConn(ctx context.Context, conn *quic.Conn, h transport.Handler) {
+	stop := context.AfterFunc(ctx, func() { _ = conn.CloseWithError(0, "server stopped") })
+	defer stop()
+
+	// Datagrams are only served once the client sends one, sparing
+	// stream clients the read timeouts of an unused datagram handler.
+	go func() {
+		b, err := conn.ReceiveDatagram(ctx)
+		if err != nil {
+			return
+		}
+		h.Serve(&datagramPacketConn{conn: conn, pending: b})
+	}()
+
+	for {
+		str, err := conn.AcceptStream(ctx)
+		if err != nil {
+			return
+		}
+
+		go func() {
+			defer func() { _ = str.Close() }()
+
+			h.Serve(&streamPacketConn{conn: conn, str: str})
+		}()
+	}
+}
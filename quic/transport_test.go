@@ -0,0 +1,225 @@
+package quic
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	tlstransport "github.com/nitrado/connqc/tls"
+	"github.com/nitrado/connqc/transport"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransport_Dial(t *testing.T) {
+	tests := []struct {
+		name   string
+		opts   []Option
+		stream bool
+	}{
+		{
+			name: "datagrams",
+		},
+		{
+			name:   "stream",
+			opts:   []Option{WithStream()},
+			stream: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			srvCert, err := tlstransport.GenerateSelfSigned("127.0.0.1")
+			require.NoError(t, err)
+
+			h := &echoHandler{stream: make(chan bool, 1)}
+			addr := newTestServer(t, h, &tls.Config{Certificates: []tls.Certificate{srvCert}})
+
+			tr := NewTransport(&tls.Config{RootCAs: certPool(srvCert)}, test.opts...)
+			conn, err := tr.Dial(t.Context(), &net.Dialer{}, addr)
+			require.NoError(t, err)
+			t.Cleanup(func() { _ = conn.Close() })
+
+			require.Implements(t, (*transport.HandshakeTimer)(nil), conn)
+			assert.Positive(t, conn.(transport.HandshakeTimer).HandshakeDuration())
+
+			assertEcho(t, conn)
+			assert.Equal(t, test.stream, <-h.stream)
+		})
+	}
+}
+
+func TestTransport_DialResumesWith0RTT(t *testing.T) {
+	srvCert, err := tlstransport.GenerateSelfSigned("127.0.0.1")
+	require.NoError(t, err)
+
+	addr := newTestServer(t, &echoHandler{}, &tls.Config{Certificates: []tls.Certificate{srvCert}})
+
+	tr := NewTransport(&tls.Config{RootCAs: certPool(srvCert)})
+
+	conn, err := tr.Dial(t.Context(), &net.Dialer{}, addr)
+	require.NoError(t, err)
+	// The session ticket is sent after the handshake.
+	assertEcho(t, conn)
+	require.Implements(t, (*transport.Resumer)(nil), conn)
+	assert.False(t, conn.(transport.Resumer).Resumed())
+	_ = conn.Close()
+
+	// Holding back the packets of the server, the resumed connection is
+	// returned and written to before the handshake can complete.
+	d := &gatedDialer{gate: make(chan struct{})}
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+	conn, err = tr.Dial(ctx, d, addr)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+
+	_, err = io.WriteString(conn, "Hello")
+	require.NoError(t, err)
+	close(d.gate)
+
+	got := make([]byte, 512)
+	n, err := conn.Read(got)
+	require.NoError(t, err)
+	assert.Equal(t, "Hello", string(got[:n]))
+	assert.True(t, conn.(transport.Resumer).Resumed())
+}
+
+func TestTransport_DialVerifiesServer(t *testing.T) {
+	srvCert, err := tlstransport.GenerateSelfSigned("127.0.0.1")
+	require.NoError(t, err)
+
+	addr := newTestServer(t, &echoHandler{}, &tls.Config{Certificates: []tls.Certificate{srvCert}})
+
+	_, err = NewTransport(&tls.Config{}).Dial(t.Context(), &net.Dialer{}, addr)
+
+	var certErr *tls.CertificateVerificationError
+	assert.ErrorAs(t, err, &certErr)
+}
+
+func TestTransport_DialWithNilConfig(t *testing.T) {
+	srvCert, err := tlstransport.GenerateSelfSigned("127.0.0.1")
+	require.NoError(t, err)
+
+	addr := newTestServer(t, &echoHandler{}, &tls.Config{Certificates: []tls.Certificate{srvCert}})
+
+	for name, tr := range map[string]*Transport{"constructor": NewTransport(nil), "zero": {}} {
+		t.Run(name, func(t *testing.T) {
+			_, err := tr.Dial(t.Context(), &net.Dialer{}, addr)
+
+			// The server is verified against the system roots.
+			var certErr *tls.CertificateVerificationError
+			assert.ErrorAs(t, err, &certErr)
+		})
+	}
+}
+
+func TestTransport_ListenRequiresCertificate(t *testing.T) {
+	for name, cfg := range map[string]*tls.Config{"empty": {}, "nil": nil} {
+		t.Run(name, func(t *testing.T) {
+			err := NewTransport(cfg).Listen(t.Context(), "127.0.0.1:0", &echoHandler{})
+
+			assert.EqualError(t, err, "quic: server certificate required")
+		})
+	}
+}
+
+func newTestServer(t *testing.T, h transport.Handler, cfg *tls.Config) string {
+	t.Helper()
+
+	pcCh := make(chan net.PacketConn, 1)
+	testHookServerServe = func(pc net.PacketConn) {
+		pcCh <- pc
+	}
+	t.Cleanup(func() { testHookServerServe = nil })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- NewTransport(cfg).Listen(ctx, "127.0.0.1:0", h)
+	}()
+
+	select {
+	case pc := <-pcCh:
+		return pc.LocalAddr().String()
+	case err := <-errCh:
+		require.FailNow(t, "listening failed", err)
+		return ""
+	}
+}
+
+func assertEcho(t *testing.T, conn net.Conn) {
+	t.Helper()
+
+	_, err := io.WriteString(conn, "Hello")
+	require.NoError(t, err)
+
+	got := make([]byte, 512)
+	n, err := conn.Read(got)
+	require.NoError(t, err)
+
+	assert.Equal(t, "Hello", string(got[:n]))
+}
+
+// gatedDialer dials connections whose reads wait until the gate is closed.
+type gatedDialer struct {
+	gate chan struct{}
+}
+
+func (d *gatedDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	conn, err := (&net.Dialer{}).DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	return &gatedConn{Conn: conn, gate: d.gate}, nil
+}
+
+type gatedConn struct {
+	net.Conn
+
+	gate <-chan struct{}
+}
+
+func (c *gatedConn) Read(p []byte) (int, error) {
+	<-c.gate
+	return c.Conn.Read(p)
+}
+
+func certPool(cert tls.Certificate) *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(cert.Leaf)
+	return pool
+}
+
+type echoHandler struct {
+	stream chan bool
+}
+
+func (e *echoHandler) Serve(conn net.PacketConn) {
+	buf := make([]byte, 512)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+
+		if e.stream != nil {
+			_, ok := conn.(interface{ Stream() bool })
+			select {
+			case e.stream <- ok:
+			default:
+			}
+		}
+
+		if _, err = conn.WriteTo(buf[:n], addr); err != nil && !errors.Is(err, net.ErrClosed) {
+			return
+		}
+	}
+}
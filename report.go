@@ -0,0 +1,126 @@
+package connqc
+
+import (
+	"sync"
+	"time"
+
+	"github.com/nitrado/connqc/stats"
+)
+
+// Report summarises a client run, like the summary printed by ping.
+type Report struct {
+	// Totals contains the statistics of the whole run.
+	Totals stats.Summary
+	// Reconnects is the number of times the connection to the
+	// server was lost or could not be established.
+	Reconnects int
+	// LongestOutage is the longest duration between two responses
+	// with lost probes or connection failures in between, including
+	// an outage still ongoing.
+	LongestOutage time.Duration
+}
+
+// reportObserver tracks the reconnects and outages of a run.
+type reportObserver struct {
+	totals *stats.Totals
+
+	mu            sync.Mutex
+	reconnects    int
+	lastResponse  time.Time
+	outageSince   time.Time
+	longestOutage time.Duration
+}
+
+func newReportObserver() *reportObserver {
+	return &reportObserver{totals: stats.NewTotals()}
+}
+
+func (o *reportObserver) Observe(e Event) {
+	switch v := e.(type) {
+	case ProbeSent:
+		o.totals.Sent()
+		o.started(v.Time)
+	case ProbeReceived:
+		if v.Arrival == ArrivalReordered {
+			o.totals.Reordered(v.RTT)
+		} else {
+			o.totals.Received(v.RTT)
+		}
+		o.responded(v.Time)
+	case ProbeLost:
+		o.totals.Lost()
+		o.failed(v.Time)
+	case ProbeDuplicated:
+		o.totals.Duplicated()
+	case ProbeLate:
+		o.totals.Late()
+	case ProbeCorrupted:
+		o.totals.Corrupted()
+	case ConnectFailed:
+		o.reconnected()
+		o.failed(time.Now())
+	case Disconnected:
+		if v.Err != nil {
+			o.reconnected()
+			o.failed(time.Now())
+		}
+	}
+}
+
+// started records the start of the run, from which
+// outages before the first response are measured.
+func (o *reportObserver) started(at time.Time) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.lastResponse.IsZero() {
+		o.lastResponse = at
+	}
+}
+
+func (o *reportObserver) responded(at time.Time) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if !o.outageSince.IsZero() {
+		o.longestOutage = max(o.longestOutage, at.Sub(o.outageSince))
+		o.outageSince = time.Time{}
+	}
+	o.lastResponse = at
+}
+
+func (o *reportObserver) failed(at time.Time) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if !o.outageSince.IsZero() {
+		return
+	}
+	o.outageSince = o.lastResponse
+	if o.outageSince.IsZero() {
+		o.outageSince = at
+	}
+}
+
+func (o *reportObserver) reconnected() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.reconnects++
+}
+
+// Report returns the report of the run up to the given time.
+func (o *reportObserver) Report(now time.Time) Report {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	outage := o.longestOutage
+	if !o.outageSince.IsZero() {
+		outage = max(outage, now.Sub(o.outageSince))
+	}
+	return Report{
+		Totals:        o.totals.Summary(),
+		Reconnects:    o.reconnects,
+		LongestOutage: outage,
+	}
+}
@@ -0,0 +1,44 @@
+package connqc
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReportObserver(t *testing.T) {
+	start := time.Now()
+	at := func(ms int) time.Time { return start.Add(time.Duration(ms) * time.Millisecond) }
+
+	o := newReportObserver()
+	o.Observe(ProbeSent{ID: 1, Time: at(0)})
+	o.Observe(ProbeReceived{ID: 1, Time: at(10), RTT: 10 * time.Millisecond})
+	o.Observe(ProbeSent{ID: 2, Time: at(100)})
+	o.Observe(ProbeLost{ID: 2, Time: at(300)})
+	o.Observe(ProbeSent{ID: 3, Time: at(200)})
+	o.Observe(ProbeReceived{ID: 3, Time: at(410), RTT: 10 * time.Millisecond})
+	o.Observe(Disconnected{Err: errors.New("test")})
+	o.Observe(Disconnected{})
+
+	got := o.Report(at(500))
+
+	assert.Equal(t, uint64(3), got.Totals.Sent)
+	assert.Equal(t, uint64(2), got.Totals.Received)
+	assert.Equal(t, uint64(1), got.Totals.Lost)
+	assert.Equal(t, 1, got.Reconnects)
+	assert.Equal(t, 400*time.Millisecond, got.LongestOutage)
+}
+
+func TestReportObserver_IncludesOngoingOutage(t *testing.T) {
+	start := time.Now()
+
+	o := newReportObserver()
+	o.Observe(ProbeSent{ID: 1, Time: start})
+	o.Observe(ProbeLost{ID: 1, Time: start.Add(2 * time.Second)})
+
+	got := o.Report(start.Add(5 * time.Second))
+
+	assert.Equal(t, 5*time.Second, got.LongestOutage)
+}
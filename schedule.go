@@ -0,0 +1,130 @@
+package connqc
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"runtime"
+	"time"
+)
+
+// Pattern is the pattern in which a client spaces its probes.
+type Pattern int
+
+// Send patterns.
+const (
+	// PatternFixed sends probes at a fixed interval.
+	PatternFixed Pattern = iota
+	// PatternJitter sends probes at a fixed interval, each offset
+	// by a uniformly random duration of up to the send jitter.
+	PatternJitter
+	// PatternPoisson sends probes with exponentially distributed spacing
+	// averaging the send interval, forming a Poisson process as used
+	// for the sampling in RFC 2330.
+	PatternPoisson
+)
+
+// String returns the string representation of the pattern.
+func (p Pattern) String() string {
+	switch p {
+	case PatternFixed:
+		return "fixed"
+	case PatternJitter:
+		return "jitter"
+	case PatternPoisson:
+		return "poisson"
+	default:
+		return fmt.Sprintf("Pattern(%d)", int(p))
+	}
+}
+
+// spinWindow is the duration before a send that is busy-waited for rather than
+// slept for, as sleeping is too coarse for sub-millisecond intervals.
+const spinWindow = 200 * time.Microsecond
+
+// scheduler keeps the absolute timeline of probe sends, so the
+// send rate does not drift with the time spent between sends.
+type scheduler struct {
+	pattern  Pattern
+	interval time.Duration
+	jitter   time.Duration
+	spin     bool
+	rand     *rand.Rand
+
+	// slot is the undisturbed time of the next send. The next
+	// send is due at slot, offset by the jitter of the pattern.
+	slot time.Time
+	due  time.Time
+}
+
+// newScheduler returns a scheduler whose first send is due one interval after start.
+func newScheduler(pattern Pattern, interval, jitter time.Duration, start time.Time) *scheduler {
+	s := &scheduler{
+		pattern:  pattern,
+		interval: interval,
+		jitter:   jitter,
+		spin:     interval < time.Millisecond,
+		rand:     rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64())), //nolint:gosec // Not security sensitive.
+		slot:     start,
+	}
+	s.schedule()
+	return s
+}
+
+// Due returns the time the next send is due.
+func (s *scheduler) Due() time.Time {
+	return s.due
+}
+
+// Sleep returns the duration to sleep for before the next send.
+// For sub-millisecond intervals, it stops short of the send by
+// the spin window, which is left for Wait to busy-wait for.
+func (s *scheduler) Sleep(now time.Time) time.Duration {
+	d := s.due.Sub(now)
+	if s.spin {
+		d -= spinWindow
+	}
+	return max(d, 0)
+}
+
+// Wait busy-waits until the next send is due. It returns
+// immediately unless the interval is sub-millisecond.
+func (s *scheduler) Wait() {
+	if !s.spin {
+		return
+	}
+	for time.Now().Before(s.due) {
+		runtime.Gosched()
+	}
+}
+
+// Sent schedules the next send after the send due was made at the given time.
+//
+// It returns the lateness of the send, and the number of sends skipped. Sends
+// that are already late by a whole interval are skipped rather than caught
+// up with in a burst.
+func (s *scheduler) Sent(at time.Time) (time.Duration, int) {
+	lateness := max(at.Sub(s.due), 0)
+
+	s.schedule()
+	var skipped int
+	for at.Sub(s.due) >= s.interval {
+		s.schedule()
+		skipped++
+	}
+	return lateness, skipped
+}
+
+// schedule advances the timeline to the next send.
+func (s *scheduler) schedule() {
+	switch s.pattern {
+	case PatternPoisson:
+		s.slot = s.slot.Add(time.Duration(s.rand.ExpFloat64() * float64(s.interval)))
+		s.due = s.slot
+	case PatternJitter:
+		s.slot = s.slot.Add(s.interval)
+		s.due = s.slot.Add(time.Duration(s.rand.Int64N(2*int64(s.jitter)+1)) - s.jitter)
+	default:
+		s.slot = s.slot.Add(s.interval)
+		s.due = s.slot
+	}
+}
@@ -0,0 +1,82 @@
+package connqc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScheduler_Fixed(t *testing.T) {
+	start := time.Now()
+	s := newScheduler(PatternFixed, time.Second, 0, start)
+
+	for i := 1; i <= 5; i++ {
+		due := start.Add(time.Duration(i) * time.Second)
+		assert.Equal(t, due, s.Due())
+
+		// Sending late does not shift the following sends.
+		lateness, skipped := s.Sent(due.Add(300 * time.Millisecond))
+
+		assert.Equal(t, 300*time.Millisecond, lateness)
+		assert.Zero(t, skipped)
+	}
+}
+
+func TestScheduler_SkipsSendsLateByAnInterval(t *testing.T) {
+	start := time.Now()
+	s := newScheduler(PatternFixed, time.Second, 0, start)
+
+	lateness, skipped := s.Sent(start.Add(3500 * time.Millisecond))
+
+	assert.Equal(t, 2500*time.Millisecond, lateness)
+	assert.Equal(t, 1, skipped)
+	assert.Equal(t, start.Add(3*time.Second), s.Due())
+}
+
+func TestScheduler_Jitter(t *testing.T) {
+	start := time.Now()
+	s := newScheduler(PatternJitter, time.Second, 100*time.Millisecond, start)
+
+	var offsets []time.Duration
+	for i := 1; i <= 100; i++ {
+		offset := s.Due().Sub(start.Add(time.Duration(i) * time.Second))
+		assert.LessOrEqual(t, offset.Abs(), 100*time.Millisecond)
+		offsets = append(offsets, offset)
+
+		s.Sent(s.Due())
+	}
+	assert.NotEqual(t, offsets[0], offsets[1])
+}
+
+func TestScheduler_Poisson(t *testing.T) {
+	start := time.Now()
+	s := newScheduler(PatternPoisson, time.Second, 0, start)
+
+	const n = 10000
+	for range n - 1 {
+		s.Sent(s.Due())
+	}
+
+	mean := s.Due().Sub(start) / n
+	assert.InDelta(t, time.Second, mean, float64(50*time.Millisecond))
+}
+
+func TestScheduler_SpinsForSubMillisecondIntervals(t *testing.T) {
+	start := time.Now()
+	s := newScheduler(PatternFixed, 500*time.Microsecond, 0, start)
+
+	assert.Equal(t, 500*time.Microsecond-spinWindow, s.Sleep(start))
+
+	s.Wait()
+
+	assert.False(t, time.Now().Before(s.Due()))
+}
+
+func TestScheduler_SleepsUntilDue(t *testing.T) {
+	start := time.Now()
+	s := newScheduler(PatternFixed, time.Second, 0, start)
+
+	assert.Equal(t, time.Second, s.Sleep(start))
+	assert.Zero(t, s.Sleep(start.Add(2*time.Second)))
+}
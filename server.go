@@ -1,49 +1,147 @@
 package connqc
 
 import (
+	"bytes"
 	"errors"
+	"fmt"
 	"io"
 	"net"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/hamba/logger/v2"
 	lctx "github.com/hamba/logger/v2/ctx"
+	"github.com/nitrado/connqc/transport"
 )
 
+// Mode is the mode in which a server answers requests.
+type Mode int
+
+// Server modes.
+const (
+	// ModeEcho echoes every read back as is, only interpreting
+	// reads that hold a single message.
+	ModeEcho Mode = iota
+	// ModeMessage decodes the messages of each connection, answering them
+	// one by one. Malformed input is dropped instead of being echoed.
+	ModeMessage
+)
+
+// String returns the string representation of the mode.
+func (m Mode) String() string {
+	switch m {
+	case ModeEcho:
+		return "echo"
+	case ModeMessage:
+		return "message"
+	default:
+		return fmt.Sprintf("Mode(%d)", int(m))
+	}
+}
+
+// ServerStats contains the message counters of a server.
+type ServerStats struct {
+	// Received is the number of messages received.
+	Received uint64
+	// Malformed is the number of malformed messages dropped.
+	Malformed uint64
+	// Unauthenticated is the number of unauthenticated messages dropped.
+	Unauthenticated uint64
+	// Replayed is the number of replayed probes dropped, including plain
+	// probes which cannot be checked for replays when a secret is set.
+	Replayed uint64
+	// Corrupted is the number of probes received with an invalid checksum.
+	Corrupted uint64
+}
+
+type serverStats struct {
+	received        atomic.Uint64
+	malformed       atomic.Uint64
+	unauthenticated atomic.Uint64
+	replayed        atomic.Uint64
+	corrupted       atomic.Uint64
+}
+
+// streamConn is implemented by connections that report whether they are
+// backed by a stream, such as TCP connections, whose reads do not preserve
+// message boundaries.
+type streamConn interface {
+	Stream() bool
+}
+
 // Server handles connections from clients.
 type Server struct {
 	bufSize      int
 	readTimeout  time.Duration
 	writeTimeout time.Duration
+	mode         Mode
+	secret       []byte
+	replayWindow time.Duration
+
+	replays *replayCache
+	stats   serverStats
 
 	log *logger.Logger
 }
 
-// NewServer returns a server.
-func NewServer(bufSize int, readTimeout, writeTimeout time.Duration, log *logger.Logger) *Server {
+// NewServer returns a server, or an error if the options are invalid.
+func NewServer(log *logger.Logger, opts ...Option) (*Server, error) {
+	cfg := newConfig(opts)
+	if err := cfg.validateServer(); err != nil {
+		return nil, err
+	}
+
 	return &Server{
-		bufSize:      bufSize,
-		readTimeout:  readTimeout,
-		writeTimeout: writeTimeout,
+		bufSize:      cfg.bufferSize,
+		readTimeout:  cfg.readTimeout,
+		writeTimeout: cfg.writeTimeout,
+		mode:         cfg.mode,
+		secret:       cfg.secret,
+		replayWindow: cfg.replayWindow,
+		replays:      newReplayCache(cfg.replayWindow),
 		log:          log,
+	}, nil
+}
+
+// Stats returns the message counters of the server.
+func (s *Server) Stats() ServerStats {
+	return ServerStats{
+		Received:        s.stats.received.Load(),
+		Malformed:       s.stats.malformed.Load(),
+		Unauthenticated: s.stats.unauthenticated.Load(),
+		Replayed:        s.stats.replayed.Load(),
+		Corrupted:       s.stats.corrupted.Load(),
 	}
 }
 
 // Serve handles a connection from a client.
 //
-// The handler provides an identical response to every message it receives.
+// In echo mode, the handler provides an identical response to every message it receives,
+// with the exception of timed probes which get the server timestamps filled in,
+// and hellos which are answered with the supported version and capabilities.
+// In message mode, every message is decoded and answered in the same way, while
+// malformed input is dropped.
+// If a secret is configured, messages that are not authenticated or replayed are dropped,
+// as are plain probes, which cannot be checked for replays, and streams are decoded message by message in both modes.
 // The caller who initiated the connection is responsible for ensuring its closure.
 func (s *Server) Serve(conn net.PacketConn) { //nolint:cyclop // Simplify readability.
+	sc, ok := conn.(streamConn)
+	stream := ok && sc.Stream()
+	// Authenticated streams cannot be echoed read by read, as
+	// a read may hold part of a message or several messages.
+	if stream && (s.mode == ModeMessage || s.secret != nil) {
+		s.serveStream(conn)
+		return
+	}
+
 	buf := make([]byte, s.bufSize)
 	for {
-		log := s.log
-
 		_ = conn.SetReadDeadline(time.Now().Add(s.readTimeout))
 		n, addr, err := conn.ReadFrom(buf)
+		received := time.Now()
 
-		if addr != nil {
-			log = log.With(lctx.Str("protocol", addr.Network()), lctx.Str("addr", addr.String()))
-		}
+		log := s.connLog(conn, addr)
 
 		if err != nil {
 			var netErr net.Error
@@ -57,25 +155,336 @@ func (s *Server) Serve(conn net.PacketConn) { //nolint:cyclop // Simplify readab
 				continue
 			default:
 				s.log.Error("Could not read request", lctx.Err(err))
+				// Streams cannot recover from read errors, such as failed TLS handshakes.
+				if stream {
+					return
+				}
 				continue
 			}
 		}
 		log.Debug("Message received", lctx.Str("data", string(buf[:n])))
 
-		_ = conn.SetWriteDeadline(time.Now().Add(s.writeTimeout))
+		var resps [][]byte
+		switch s.mode {
+		case ModeMessage:
+			resps = s.answer(log, buf[:n], received)
+		default:
+			if resp := s.reply(log, buf[:n], received); resp != nil {
+				resps = append(resps, resp)
+			}
+		}
 
-		wn, err := conn.WriteTo(buf[:n], addr)
-		switch {
-		case err != nil && errors.Is(err, net.ErrClosed):
+		for _, resp := range resps {
+			if err = s.write(log, conn, addr, resp); errors.Is(err, net.ErrClosed) {
+				return
+			}
+		}
+	}
+}
+
+// serveStream answers the messages of a stream connection one by one.
+//
+// As the stream cannot be resynchronised after malformed input, or a read
+// timing out within a message, the connection is given up on, returning
+// to the caller who closes it.
+func (s *Server) serveStream(conn net.PacketConn) { //nolint:cyclop // Simplify readability.
+	r := &packetReader{conn: conn}
+	dec := NewDecoder(r, s.codecOpts()...)
+	for {
+		_ = conn.SetReadDeadline(time.Now().Add(s.readTimeout))
+		partial := dec.buffered() > 0
+		r.n = 0
+		msg, err := dec.Decode()
+		received := time.Now()
+
+		log := s.connLog(conn, r.addr)
+
+		if err != nil {
+			var netErr net.Error
+			switch {
+			case errors.Is(err, io.EOF):
+				return
+			case errors.Is(err, net.ErrClosed):
+				return
+			case errors.As(err, &netErr) && netErr.Timeout():
+				log.Error("Reading from connection timed out", lctx.Err(err))
+				// The part of the message read so far has been consumed.
+				if partial || r.n > 0 {
+					return
+				}
+				continue
+			case errors.Is(err, ErrUnauthenticated):
+				s.stats.unauthenticated.Add(1)
+				log.Debug("Unauthenticated message dropped", lctx.Err(err))
+				continue
+			default:
+				s.stats.malformed.Add(1)
+				log.Warn("Malformed message dropped", lctx.Str("reason", err.Error()))
+				return
+			}
+		}
+
+		resp, ok := s.handle(log, msg, received)
+		if !ok {
+			continue
+		}
+
+		var buf bytes.Buffer
+		if err = NewEncoder(&buf, s.codecOpts()...).Encode(resp); err != nil {
+			log.Error("Could not encode response", lctx.Err(err))
+			continue
+		}
+		if err = s.write(log, conn, r.addr, buf.Bytes()); errors.Is(err, net.ErrClosed) {
 			return
+		}
+	}
+}
+
+// codecOpts returns the options of the codecs reading requests and writing responses.
+func (s *Server) codecOpts() []CodecOption {
+	if s.secret == nil {
+		return nil
+	}
+	return append(codecOpts(s.secret), AsServer())
+}
+
+// connLog returns the logger for messages read from the given address,
+// identifying the client if the connection knows its identity.
+func (s *Server) connLog(conn net.PacketConn, addr net.Addr) *logger.Logger {
+	log := s.log
+	if addr != nil {
+		log = log.With(lctx.Str("protocol", addr.Network()), lctx.Str("addr", addr.String()))
+	}
+	if id, ok := conn.(transport.Identifier); ok {
+		if identity := id.Identity(); identity != "" {
+			log = log.With(lctx.Str("identity", identity))
+		}
+	}
+	return log
+}
+
+func (s *Server) write(log *logger.Logger, conn net.PacketConn, addr net.Addr, resp []byte) error {
+	_ = conn.SetWriteDeadline(time.Now().Add(s.writeTimeout))
+
+	wn, err := conn.WriteTo(resp, addr)
+	switch {
+	case err != nil && errors.Is(err, net.ErrClosed):
+		return err
+	case err != nil:
+		log.Error("Could not write response", lctx.Err(err))
+		return err
+	}
+	if wn != len(resp) {
+		log.Error("Unexpected write length", lctx.Int("expected", len(resp)), lctx.Int("actual", wn))
+		return io.ErrShortWrite
+	}
+	log.Debug("Message sent", lctx.Str("data", string(resp)))
+	return nil
+}
+
+// reply returns the response to the given request, or nil if the
+// request should be dropped.
+//
+// If the request cannot be interpreted as a single message, it is
+// echoed back as is, unless authentication is required.
+func (s *Server) reply(log *logger.Logger, req []byte, received time.Time) []byte {
+	echo := req
+	if s.secret != nil {
+		echo = nil
+	}
+
+	dec := NewDecoder(bytes.NewReader(req), s.codecOpts()...)
+	msg, err := dec.Decode()
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrUnauthenticated):
+			s.stats.unauthenticated.Add(1)
+			log.Debug("Unauthenticated message dropped", lctx.Err(err))
+		case s.secret != nil:
+			s.stats.malformed.Add(1)
+			log.Warn("Malformed message dropped", lctx.Str("reason", err.Error()))
+		}
+		return echo
+	}
+	// Do not interpret the request if it holds more than the message to avoid losing data.
+	if _, err = dec.Decode(); !errors.Is(err, io.EOF) {
+		if s.secret != nil {
+			s.stats.malformed.Add(1)
+			log.Warn("Malformed message dropped", lctx.Str("reason", "trailing data after message"))
+		}
+		return echo
+	}
+
+	switch msg.(type) {
+	case TimedProbe, Hello:
+	default:
+		// Authenticated requests must be encoded as responses, as the HMAC covers their direction.
+		if s.secret == nil {
+			s.stats.received.Add(1)
+			return req
+		}
+	}
+
+	resp, ok := s.handle(log, msg, received)
+	if !ok {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	if err = NewEncoder(&buf, s.codecOpts()...).Encode(resp); err != nil {
+		return echo
+	}
+	return buf.Bytes()
+}
+
+// answer returns the responses to the messages in the given packet.
+//
+// Malformed messages are dropped along with the rest of the packet.
+func (s *Server) answer(log *logger.Logger, pkt []byte, received time.Time) [][]byte {
+	var resps [][]byte
+
+	dec := NewDecoder(bytes.NewReader(pkt), s.codecOpts()...)
+	for {
+		msg, err := dec.Decode()
+		switch {
+		case errors.Is(err, io.EOF):
+			return resps
+		case errors.Is(err, ErrUnauthenticated):
+			s.stats.unauthenticated.Add(1)
+			log.Debug("Unauthenticated message dropped", lctx.Err(err))
+			continue
 		case err != nil:
-			log.Error("Could not write response", lctx.Err(err))
+			s.stats.malformed.Add(1)
+			log.Warn("Malformed message dropped", lctx.Str("reason", err.Error()))
+			return resps
+		}
+
+		resp, ok := s.handle(log, msg, received)
+		if !ok {
 			continue
 		}
-		if wn != n {
-			log.Error("Unexpected write length", lctx.Int("expected", n), lctx.Int("actual", wn))
+
+		var buf bytes.Buffer
+		if err = NewEncoder(&buf, s.codecOpts()...).Encode(resp); err != nil {
+			log.Error("Could not encode response", lctx.Err(err))
 			continue
 		}
-		log.Debug("Message sent", lctx.Str("data", string(buf[:n])))
+		resps = append(resps, buf.Bytes())
+	}
+}
+
+// handle returns the response to the given message, or false if
+// the message should be dropped.
+func (s *Server) handle(log *logger.Logger, msg Message, received time.Time) (Message, bool) {
+	s.stats.received.Add(1)
+
+	switch v := msg.(type) {
+	case Probe:
+		// Plain probes carry no send time to detect replays with, so they are
+		// only answered when messages are not authenticated.
+		if s.secret != nil {
+			s.stats.replayed.Add(1)
+			log.Debug("Plain probe dropped as it cannot be checked for replays", lctx.Uint64("id", v.ID))
+			return nil, false
+		}
+		return v, true
+	case TimedProbe:
+		if s.secret != nil && s.replayed(v, received) {
+			s.stats.replayed.Add(1)
+			log.Debug("Replayed message dropped", lctx.Uint64("id", v.ID))
+			return nil, false
+		}
+		// Corrupted probes are still answered so the client can account for them.
+		if v.Corrupted() {
+			s.stats.corrupted.Add(1)
+			log.Warn("Corrupted message received", lctx.Uint64("id", v.ID), lctx.Uint32("checksum", v.Checksum))
+		}
+		v.ServerRecv = received
+		v.ServerSend = time.Now()
+		return v, true
+	case Hello:
+		return HelloAck{
+			Version:      min(v.Version, ProtocolVersion),
+			Capabilities: v.Capabilities & SupportedCapabilities,
+		}, true
+	default:
+		s.stats.malformed.Add(1)
+		log.Warn("Malformed message dropped", lctx.Str("reason", fmt.Sprintf("unexpected message type %T", msg)))
+		return nil, false
+	}
+}
+
+// replayed determines if the probe is outside the replay window or has been seen before.
+//
+// Probes are not told apart by the address they are received from, which
+// can be spoofed, so a replayed probe is only answered once.
+func (s *Server) replayed(p TimedProbe, received time.Time) bool {
+	age := received.Sub(p.ClientSend)
+	if age > s.replayWindow || age < -s.replayWindow {
+		return true
+	}
+
+	return s.replays.Seen(replayKey{id: p.ID, sent: p.ClientSend.UnixNano()}, received)
+}
+
+type replayKey struct {
+	id   uint64
+	sent int64
+}
+
+// replayCache remembers the probes seen within the replay window.
+type replayCache struct {
+	window time.Duration
+
+	mu        sync.Mutex
+	seen      map[replayKey]time.Time
+	lastPrune time.Time
+}
+
+func newReplayCache(window time.Duration) *replayCache {
+	return &replayCache{
+		window: window,
+		seen:   map[replayKey]time.Time{},
+	}
+}
+
+// Seen records the key, returning true if it has been seen before.
+func (c *replayCache) Seen(key replayKey, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Probes are rejected based on their age once they are outside the window,
+	// so their keys no longer need to be remembered.
+	if now.Sub(c.lastPrune) > c.window {
+		for k, t := range c.seen {
+			if now.Sub(t) > 2*c.window {
+				delete(c.seen, k)
+			}
+		}
+		c.lastPrune = now
+	}
+
+	if _, ok := c.seen[key]; ok {
+		return true
+	}
+	c.seen[key] = now
+	return false
+}
+
+// packetReader reads a stream from a packet connection,
+// remembering the address of the last read.
+type packetReader struct {
+	conn net.PacketConn
+	addr net.Addr
+	// n is the number of bytes read, reset by the caller.
+	n int
+}
+
+func (r *packetReader) Read(p []byte) (int, error) {
+	n, addr, err := r.conn.ReadFrom(p)
+	if addr != nil {
+		r.addr = addr
 	}
+	r.n += n
+	return n, err
 }
@@ -0,0 +1,435 @@
+package connqc_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/hamba/logger/v2"
+	"github.com/nitrado/connqc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewServer_ValidatesOptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    []connqc.Option
+		wantErr string
+	}{
+		{
+			name:    "buffer smaller than probe header",
+			opts:    []connqc.Option{connqc.WithBufferSize(16)},
+			wantErr: "buffer size must be at least the probe header size of 41 bytes, got 16",
+		},
+		{
+			name:    "buffer smaller than authenticated probe header",
+			opts:    []connqc.Option{connqc.WithBufferSize(41), connqc.WithSecret([]byte("secret"))},
+			wantErr: "buffer size must be at least the probe header size of 57 bytes, got 41",
+		},
+		{
+			name:    "zero read timeout",
+			opts:    []connqc.Option{connqc.WithReadTimeout(0)},
+			wantErr: "read timeout must be greater than zero, got 0s",
+		},
+		{
+			name:    "negative write timeout",
+			opts:    []connqc.Option{connqc.WithWriteTimeout(-time.Second)},
+			wantErr: "write timeout must be greater than zero, got -1s",
+		},
+		{
+			name:    "unsupported mode",
+			opts:    []connqc.Option{connqc.WithMode(connqc.Mode(5))},
+			wantErr: "unsupported mode Mode(5)",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			log := logger.New(io.Discard, logger.LogfmtFormat(), logger.Error)
+
+			_, err := connqc.NewServer(log, test.opts...)
+
+			assert.EqualError(t, err, test.wantErr)
+		})
+	}
+}
+
+func TestServer_ServeFillsTimedProbe(t *testing.T) {
+	conn := newTestServer(t)
+
+	sent := time.Now()
+	err := connqc.NewEncoder(conn).Encode(connqc.TimedProbe{ID: 1, ClientSend: sent, Data: "Hello 1"})
+	require.NoError(t, err)
+
+	msg, err := connqc.NewDecoder(conn).Decode()
+	require.NoError(t, err)
+
+	require.IsType(t, connqc.TimedProbe{}, msg)
+	got := msg.(connqc.TimedProbe)
+	assert.Equal(t, uint64(1), got.ID)
+	assert.Equal(t, "Hello 1", got.Data)
+	assert.True(t, got.ClientSend.Equal(sent))
+	assert.False(t, got.ServerRecv.Before(sent))
+	assert.False(t, got.ServerSend.Before(got.ServerRecv))
+}
+
+func TestServer_ServeAnswersHello(t *testing.T) {
+	conn := newTestServer(t)
+
+	err := connqc.NewEncoder(conn).Encode(connqc.Hello{Version: 99, Capabilities: connqc.CapTimestamps | 1<<31})
+	require.NoError(t, err)
+
+	msg, err := connqc.NewDecoder(conn).Decode()
+	require.NoError(t, err)
+
+	want := connqc.HelloAck{Version: connqc.ProtocolVersion, Capabilities: connqc.CapTimestamps}
+	assert.Equal(t, want, msg)
+}
+
+func TestServer_ServeEchoesUnknownData(t *testing.T) {
+	conn := newTestServer(t)
+
+	_, err := conn.Write([]byte("Hello"))
+	require.NoError(t, err)
+
+	got := make([]byte, 512)
+	n, err := conn.Read(got)
+	require.NoError(t, err)
+
+	assert.Equal(t, "Hello", string(got[:n]))
+}
+
+func TestServer_ServeWithSecret(t *testing.T) {
+	secret := []byte("secret")
+	conn := newTestServer(t, connqc.WithSecret(secret))
+
+	// Unauthenticated messages are dropped.
+	_, err := conn.Write([]byte("Hello"))
+	require.NoError(t, err)
+
+	err = connqc.NewEncoder(conn, connqc.WithHMAC(secret)).Encode(connqc.TimedProbe{ID: 1, ClientSend: time.Now()})
+	require.NoError(t, err)
+
+	msg, err := connqc.NewDecoder(conn, connqc.WithHMAC(secret)).Decode()
+	require.NoError(t, err)
+
+	require.IsType(t, connqc.TimedProbe{}, msg)
+	assert.Equal(t, uint64(1), msg.(connqc.TimedProbe).ID)
+}
+
+func TestServer_ServeWithSecretDropsReplays(t *testing.T) {
+	secret := []byte("secret")
+	conn := newTestServer(t, connqc.WithSecret(secret), connqc.WithReplayWindow(time.Minute))
+
+	enc := connqc.NewEncoder(conn, connqc.WithHMAC(secret))
+	dec := connqc.NewDecoder(conn, connqc.WithHMAC(secret))
+
+	replayed := connqc.TimedProbe{ID: 1, ClientSend: time.Now()}
+	require.NoError(t, enc.Encode(replayed))
+	_, err := dec.Decode()
+	require.NoError(t, err)
+
+	require.NoError(t, enc.Encode(replayed))
+	require.NoError(t, enc.Encode(connqc.TimedProbe{ID: 2, ClientSend: time.Now().Add(-2 * time.Minute)}))
+	require.NoError(t, enc.Encode(connqc.TimedProbe{ID: 3, ClientSend: time.Now()}))
+
+	msg, err := dec.Decode()
+	require.NoError(t, err)
+
+	require.IsType(t, connqc.TimedProbe{}, msg)
+	assert.Equal(t, uint64(3), msg.(connqc.TimedProbe).ID)
+}
+
+func TestServer_ServeWithSecretDropsPlainProbes(t *testing.T) {
+	modes := []connqc.Mode{connqc.ModeEcho, connqc.ModeMessage}
+
+	for _, mode := range modes {
+		t.Run(mode.String(), func(t *testing.T) {
+			secret := []byte("secret")
+			log := logger.New(io.Discard, logger.LogfmtFormat(), logger.Error)
+			srv, err := connqc.NewServer(log, connqc.WithSecret(secret), connqc.WithMode(mode))
+			require.NoError(t, err)
+
+			pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+			require.NoError(t, err)
+			t.Cleanup(func() { _ = pc.Close() })
+			go srv.Serve(pc)
+
+			conn, err := net.Dial("udp", pc.LocalAddr().String())
+			require.NoError(t, err)
+			t.Cleanup(func() { _ = conn.Close() })
+			_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+			enc := connqc.NewEncoder(conn, connqc.WithHMAC(secret))
+			require.NoError(t, enc.Encode(connqc.Probe{ID: 1, Data: "Hello 1"}))
+			require.NoError(t, enc.Encode(connqc.TimedProbe{ID: 2, ClientSend: time.Now()}))
+
+			msg, err := connqc.NewDecoder(conn, connqc.WithHMAC(secret)).Decode()
+			require.NoError(t, err)
+
+			require.IsType(t, connqc.TimedProbe{}, msg)
+			assert.Equal(t, uint64(2), msg.(connqc.TimedProbe).ID)
+			assert.Equal(t, uint64(1), srv.Stats().Replayed)
+		})
+	}
+}
+
+func TestServer_ServeWithSecretDropsReplaysFromOtherAddresses(t *testing.T) {
+	secret := []byte("secret")
+	log := logger.New(io.Discard, logger.LogfmtFormat(), logger.Error)
+	srv, err := connqc.NewServer(log, connqc.WithSecret(secret), connqc.WithReplayWindow(time.Minute))
+	require.NoError(t, err)
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = pc.Close() })
+	go srv.Serve(pc)
+
+	var buf bytes.Buffer
+	err = connqc.NewEncoder(&buf, connqc.WithHMAC(secret)).Encode(connqc.TimedProbe{ID: 1, ClientSend: time.Now()})
+	require.NoError(t, err)
+
+	conn, err := net.Dial("udp", pc.LocalAddr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	_, err = conn.Write(buf.Bytes())
+	require.NoError(t, err)
+	_, err = connqc.NewDecoder(conn, connqc.WithHMAC(secret)).Decode()
+	require.NoError(t, err)
+
+	// The captured probe is replayed from another address.
+	other, err := net.Dial("udp", pc.LocalAddr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = other.Close() })
+	_ = other.SetDeadline(time.Now().Add(200 * time.Millisecond))
+
+	_, err = other.Write(buf.Bytes())
+	require.NoError(t, err)
+
+	_, err = other.Read(make([]byte, 512))
+	var netErr net.Error
+	require.ErrorAs(t, err, &netErr)
+	assert.True(t, netErr.Timeout())
+	assert.Equal(t, uint64(1), srv.Stats().Replayed)
+}
+
+func TestServer_ServeMessageMode(t *testing.T) {
+	log := logger.New(io.Discard, logger.LogfmtFormat(), logger.Error)
+	srv, err := connqc.NewServer(log, connqc.WithMode(connqc.ModeMessage))
+	require.NoError(t, err)
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = pc.Close() })
+	go srv.Serve(pc)
+
+	conn, err := net.Dial("udp", pc.LocalAddr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	_, err = conn.Write([]byte("Hello"))
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	enc := connqc.NewEncoder(&buf)
+	require.NoError(t, enc.Encode(connqc.Probe{ID: 1, Data: "Hello 1"}))
+	require.NoError(t, enc.Encode(connqc.Probe{ID: 2, Data: "Hello 2"}))
+	_, err = conn.Write(buf.Bytes())
+	require.NoError(t, err)
+
+	dec := connqc.NewDecoder(conn)
+	for _, want := range []connqc.Probe{{ID: 1, Data: "Hello 1"}, {ID: 2, Data: "Hello 2"}} {
+		msg, err := dec.Decode()
+		require.NoError(t, err)
+		assert.Equal(t, want, msg)
+	}
+
+	want := connqc.ServerStats{Received: 2, Malformed: 1}
+	assert.Equal(t, want, srv.Stats())
+}
+
+func TestServer_ServeMessageModeHandlesStreams(t *testing.T) {
+	log := logger.New(io.Discard, logger.LogfmtFormat(), logger.Error)
+	srv, err := connqc.NewServer(log, connqc.WithMode(connqc.ModeMessage))
+	require.NoError(t, err)
+
+	srvConn, conn := net.Pipe()
+	t.Cleanup(func() { _ = conn.Close() })
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		srv.Serve(&streamPacketConn{Conn: srvConn})
+	}()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	var buf bytes.Buffer
+	require.NoError(t, connqc.NewEncoder(&buf).Encode(connqc.Probe{ID: 1, Data: "Hello 1"}))
+	b := buf.Bytes()
+
+	// Write the probe in two parts, which must be answered as one message.
+	_, err = conn.Write(b[:5])
+	require.NoError(t, err)
+	_, err = conn.Write(b[5:])
+	require.NoError(t, err)
+
+	msg, err := connqc.NewDecoder(conn).Decode()
+	require.NoError(t, err)
+	assert.Equal(t, connqc.Probe{ID: 1, Data: "Hello 1"}, msg)
+
+	// Malformed input is not reflected, the connection is given up on.
+	_, err = conn.Write([]byte("Hello"))
+	require.NoError(t, err)
+
+	<-done
+	assert.Equal(t, uint64(1), srv.Stats().Malformed)
+}
+
+func TestServer_ServeWithSecretHandlesStreams(t *testing.T) {
+	secret := []byte("secret")
+	log := logger.New(io.Discard, logger.LogfmtFormat(), logger.Error)
+	srv, err := connqc.NewServer(log, connqc.WithSecret(secret))
+	require.NoError(t, err)
+
+	srvConn, conn := net.Pipe()
+	t.Cleanup(func() { _ = conn.Close() })
+	go srv.Serve(&streamPacketConn{Conn: srvConn})
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	var buf bytes.Buffer
+	enc := connqc.NewEncoder(&buf, connqc.WithHMAC(secret))
+	sent := time.Now()
+	require.NoError(t, enc.Encode(connqc.TimedProbe{ID: 1, ClientSend: sent, Data: "Hello 1"}))
+	require.NoError(t, enc.Encode(connqc.TimedProbe{ID: 2, ClientSend: sent, Data: "Hello 2"}))
+	b := buf.Bytes()
+
+	// Write the probes split across and merged into writes, which must be answered message by message.
+	go func() {
+		_, _ = conn.Write(b[:5])
+		_, _ = conn.Write(b[5:])
+	}()
+
+	dec := connqc.NewDecoder(conn, connqc.WithHMAC(secret))
+	for id := uint64(1); id <= 2; id++ {
+		msg, err := dec.Decode()
+		require.NoError(t, err)
+		require.IsType(t, connqc.TimedProbe{}, msg)
+		assert.Equal(t, id, msg.(connqc.TimedProbe).ID)
+		assert.Equal(t, fmt.Sprintf("Hello %d", id), msg.(connqc.TimedProbe).Data)
+	}
+	assert.Zero(t, srv.Stats().Malformed)
+	assert.Zero(t, srv.Stats().Unauthenticated)
+}
+
+func TestServer_ServeStopsStreamOnTimeoutWithinMessage(t *testing.T) {
+	log := logger.New(io.Discard, logger.LogfmtFormat(), logger.Error)
+	srv, err := connqc.NewServer(log, connqc.WithMode(connqc.ModeMessage), connqc.WithReadTimeout(20*time.Millisecond))
+	require.NoError(t, err)
+
+	srvConn, conn := net.Pipe()
+	t.Cleanup(func() { _ = conn.Close() })
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		srv.Serve(&streamPacketConn{Conn: srvConn})
+	}()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	var buf bytes.Buffer
+	require.NoError(t, connqc.NewEncoder(&buf).Encode(connqc.Probe{ID: 1, Data: "Hello 1"}))
+	b := buf.Bytes()
+
+	// Timing out between messages keeps the stream.
+	time.Sleep(50 * time.Millisecond)
+	_, err = conn.Write(b)
+	require.NoError(t, err)
+	msg, err := connqc.NewDecoder(conn).Decode()
+	require.NoError(t, err)
+	assert.Equal(t, connqc.Probe{ID: 1, Data: "Hello 1"}, msg)
+
+	// Timing out within a message gives up on the stream, as it cannot be resynchronised.
+	_, err = conn.Write(b[:5])
+	require.NoError(t, err)
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "serve did not return")
+	}
+}
+
+func TestServer_ServeStopsStreamOnReadError(t *testing.T) {
+	log := logger.New(io.Discard, logger.LogfmtFormat(), logger.Error)
+	srv, err := connqc.NewServer(log)
+	require.NoError(t, err)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		srv.Serve(&failingStreamConn{})
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "serve did not return")
+	}
+}
+
+func newTestServer(t *testing.T, opts ...connqc.Option) net.Conn {
+	t.Helper()
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = pc.Close() })
+
+	srv, err := connqc.NewServer(logger.New(io.Discard, logger.LogfmtFormat(), logger.Error), opts...)
+	require.NoError(t, err)
+	go srv.Serve(pc)
+
+	conn, err := net.Dial("udp", pc.LocalAddr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	return conn
+}
+
+type streamPacketConn struct {
+	net.Conn
+}
+
+func (c *streamPacketConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	n, err := c.Read(p)
+	return n, c.RemoteAddr(), err
+}
+
+func (c *streamPacketConn) WriteTo(p []byte, _ net.Addr) (int, error) {
+	return c.Write(p)
+}
+
+func (c *streamPacketConn) Stream() bool {
+	return true
+}
+
+// failingStreamConn is a stream whose reads fail, like a TLS connection
+// after a failed handshake.
+type failingStreamConn struct {
+	streamPacketConn
+}
+
+func (c *failingStreamConn) ReadFrom([]byte) (int, net.Addr, error) {
+	return 0, nil, errors.New("tls: client didn't provide a certificate")
+}
+
+func (c *failingStreamConn) SetReadDeadline(time.Time) error {
+	return nil
+}
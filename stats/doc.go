@@ -0,0 +1,2 @@
+// Package stats provides rolling probe statistics over sliding windows.
+package stats
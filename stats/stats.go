@@ -0,0 +1,259 @@
+package stats
+
+import (
+	"math"
+	"slices"
+	"sync"
+	"time"
+)
+
+type kind int
+
+const (
+	kindSent kind = iota
+	kindReceived
+	kindReordered
+	kindLost
+	kindDuplicated
+	kindLate
+	kindCorrupted
+)
+
+type event struct {
+	at   time.Time
+	kind kind
+	rtt  time.Duration
+}
+
+// Summary contains the statistics of a window.
+type Summary struct {
+	// Window is the duration the summary covers.
+	Window time.Duration
+
+	// Sent is the number of probes sent.
+	Sent uint64
+	// Received is the number of probes received, including reordered probes.
+	Received uint64
+	// Lost is the number of probes that timed out.
+	Lost uint64
+	// Reordered is the number of probes received out of order.
+	Reordered uint64
+	// Duplicated is the number of duplicate responses received.
+	Duplicated uint64
+	// Late is the number of responses received after the probe was considered lost.
+	Late uint64
+	// Corrupted is the number of responses received with an invalid checksum.
+	Corrupted uint64
+
+	// RTT contains the round-trip time statistics of the received probes.
+	RTT RTT
+	// Jitter is the interarrival jitter of the received probes, as described in RFC 3550.
+	Jitter time.Duration
+}
+
+// Loss returns the ratio of lost probes to the probes with a known outcome.
+func (s Summary) Loss() float64 {
+	total := s.Received + s.Lost
+	if total == 0 {
+		return 0
+	}
+	return float64(s.Lost) / float64(total)
+}
+
+// RTT contains round-trip time statistics.
+type RTT struct {
+	Min    time.Duration
+	Avg    time.Duration
+	Max    time.Duration
+	StdDev time.Duration
+	P50    time.Duration
+	P90    time.Duration
+	P99    time.Duration
+}
+
+// Stats collects probe statistics over sliding windows.
+//
+// Stats is safe for concurrent use.
+type Stats struct {
+	windows []time.Duration
+	maxWin  time.Duration
+
+	mu     sync.Mutex
+	events []event
+}
+
+// New returns a statistics collector for the given windows.
+func New(windows ...time.Duration) *Stats {
+	windows = slices.Clone(windows)
+	slices.Sort(windows)
+
+	var maxWin time.Duration
+	if len(windows) > 0 {
+		maxWin = windows[len(windows)-1]
+	}
+
+	return &Stats{
+		windows: windows,
+		maxWin:  maxWin,
+	}
+}
+
+// Windows returns the windows of the collector, in ascending order.
+func (s *Stats) Windows() []time.Duration {
+	return slices.Clone(s.windows)
+}
+
+// Sent records a sent probe.
+func (s *Stats) Sent(at time.Time) {
+	s.record(event{at: at, kind: kindSent})
+}
+
+// Received records a probe received in order with the given round-trip time.
+func (s *Stats) Received(at time.Time, rtt time.Duration) {
+	s.record(event{at: at, kind: kindReceived, rtt: rtt})
+}
+
+// Reordered records a probe received out of order with the given round-trip time.
+func (s *Stats) Reordered(at time.Time, rtt time.Duration) {
+	s.record(event{at: at, kind: kindReordered, rtt: rtt})
+}
+
+// Lost records a lost probe.
+func (s *Stats) Lost(at time.Time) {
+	s.record(event{at: at, kind: kindLost})
+}
+
+// Duplicated records a duplicate response.
+func (s *Stats) Duplicated(at time.Time) {
+	s.record(event{at: at, kind: kindDuplicated})
+}
+
+// Late records a response received after the probe was considered lost.
+func (s *Stats) Late(at time.Time) {
+	s.record(event{at: at, kind: kindLate})
+}
+
+// Corrupted records a response with an invalid checksum.
+func (s *Stats) Corrupted(at time.Time) {
+	s.record(event{at: at, kind: kindCorrupted})
+}
+
+func (s *Stats) record(e event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.events = append(s.events, e)
+
+	// Drop the events that are outside all windows.
+	var i int
+	for i < len(s.events) && e.at.Sub(s.events[i].at) > s.maxWin {
+		i++
+	}
+	if i > 0 {
+		s.events = slices.Delete(s.events, 0, i)
+	}
+}
+
+// Summaries returns the summaries of all windows at the given time.
+func (s *Stats) Summaries(now time.Time) []Summary {
+	sums := make([]Summary, 0, len(s.windows))
+	for _, w := range s.windows {
+		sums = append(sums, s.Summary(w, now))
+	}
+	return sums
+}
+
+// Summary returns the summary of the window ending at the given time.
+func (s *Stats) Summary(window time.Duration, now time.Time) Summary {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sum := Summary{Window: window}
+
+	var rtts []time.Duration
+	for _, e := range s.events {
+		if now.Sub(e.at) > window || e.at.After(now) {
+			continue
+		}
+
+		switch e.kind {
+		case kindSent:
+			sum.Sent++
+		case kindReceived:
+			sum.Received++
+			rtts = append(rtts, e.rtt)
+		case kindReordered:
+			sum.Received++
+			sum.Reordered++
+			rtts = append(rtts, e.rtt)
+		case kindLost:
+			sum.Lost++
+		case kindDuplicated:
+			sum.Duplicated++
+		case kindLate:
+			sum.Late++
+		case kindCorrupted:
+			sum.Corrupted++
+		}
+	}
+
+	sum.Jitter = jitter(rtts)
+	sum.RTT = rttStats(rtts)
+	return sum
+}
+
+// jitter computes the interarrival jitter of RFC 3550 over the
+// round-trip times, in order of arrival.
+//
+// The difference in transit time of consecutive probes equals the
+// difference of their round-trip times, as both were sent and received
+// by the same host.
+func jitter(rtts []time.Duration) time.Duration {
+	var j float64
+	for i := 1; i < len(rtts); i++ {
+		d := math.Abs(float64(rtts[i] - rtts[i-1]))
+		j += (d - j) / 16
+	}
+	return time.Duration(j)
+}
+
+func rttStats(rtts []time.Duration) RTT {
+	if len(rtts) == 0 {
+		return RTT{}
+	}
+
+	sorted := slices.Clone(rtts)
+	slices.Sort(sorted)
+
+	var sum float64
+	for _, rtt := range sorted {
+		sum += float64(rtt)
+	}
+	avg := sum / float64(len(sorted))
+
+	var variance float64
+	for _, rtt := range sorted {
+		d := float64(rtt) - avg
+		variance += d * d
+	}
+	variance /= float64(len(sorted))
+
+	return RTT{
+		Min:    sorted[0],
+		Avg:    time.Duration(avg),
+		Max:    sorted[len(sorted)-1],
+		StdDev: time.Duration(math.Sqrt(variance)),
+		P50:    percentile(sorted, 0.5),
+		P90:    percentile(sorted, 0.9),
+		P99:    percentile(sorted, 0.99),
+	}
+}
+
+// percentile returns the nearest-rank percentile of the sorted values.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	rank := int(math.Ceil(p * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
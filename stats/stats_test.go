@@ -0,0 +1,76 @@
+package stats_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nitrado/connqc/stats"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStats_Summary(t *testing.T) {
+	now := time.Now()
+	s := stats.New(time.Minute, 10*time.Second)
+
+	// Outside of the 10s window.
+	s.Sent(now.Add(-30 * time.Second))
+	s.Lost(now.Add(-28 * time.Second))
+
+	for i := 1; i <= 10; i++ {
+		at := now.Add(time.Duration(i-10) * time.Second)
+		s.Sent(at)
+		s.Received(at, time.Duration(i)*time.Millisecond)
+	}
+	s.Reordered(now, 20*time.Millisecond)
+	s.Duplicated(now)
+	s.Late(now)
+	s.Corrupted(now)
+
+	got := s.Summary(10*time.Second, now)
+
+	assert.Equal(t, 10*time.Second, got.Window)
+	assert.Equal(t, uint64(10), got.Sent)
+	assert.Equal(t, uint64(11), got.Received)
+	assert.Equal(t, uint64(0), got.Lost)
+	assert.Equal(t, uint64(1), got.Reordered)
+	assert.Equal(t, uint64(1), got.Duplicated)
+	assert.Equal(t, uint64(1), got.Late)
+	assert.Equal(t, uint64(1), got.Corrupted)
+	assert.Equal(t, time.Millisecond, got.RTT.Min)
+	assert.Equal(t, 20*time.Millisecond, got.RTT.Max)
+	assert.Equal(t, 6*time.Millisecond, got.RTT.P50)
+	assert.Equal(t, 10*time.Millisecond, got.RTT.P90)
+	assert.Equal(t, 20*time.Millisecond, got.RTT.P99)
+	assert.InDelta(t, 6.82e6, float64(got.RTT.Avg), 1e4)
+	assert.Greater(t, got.RTT.StdDev, time.Duration(0))
+	assert.Greater(t, got.Jitter, time.Duration(0))
+
+	got = s.Summary(time.Minute, now)
+
+	assert.Equal(t, uint64(11), got.Sent)
+	assert.Equal(t, uint64(1), got.Lost)
+	assert.InDelta(t, 1.0/12, got.Loss(), 1e-9)
+}
+
+func TestStats_SummariesReturnsAllWindows(t *testing.T) {
+	s := stats.New(time.Minute, 10*time.Second)
+
+	got := s.Summaries(time.Now())
+
+	if assert.Len(t, got, 2) {
+		assert.Equal(t, 10*time.Second, got[0].Window)
+		assert.Equal(t, time.Minute, got[1].Window)
+	}
+}
+
+func TestStats_DropsEventsOutsideWindows(t *testing.T) {
+	now := time.Now()
+	s := stats.New(10 * time.Second)
+
+	s.Sent(now.Add(-time.Minute))
+	s.Sent(now)
+
+	got := s.Summary(time.Hour, now)
+
+	assert.Equal(t, uint64(1), got.Sent)
+}
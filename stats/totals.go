@@ -0,0 +1,173 @@
+package stats
+
+import (
+	"math"
+	"math/bits"
+	"sync"
+	"time"
+)
+
+// subBuckets is the number of histogram buckets per power of two,
+// bounding the relative error of estimated percentiles to below 1%.
+const (
+	subBucketBits = 7
+	subBuckets    = 1 << subBucketBits
+)
+
+// Totals collects probe statistics over a whole run in constant memory.
+//
+// Unlike the window summaries, the round-trip time percentiles
+// are estimated from a histogram, with a relative error below 1%.
+//
+// Totals is safe for concurrent use.
+type Totals struct {
+	mu  sync.Mutex
+	sum Summary
+
+	// The mean and variance of the round-trip times are computed
+	// with Welford's online algorithm.
+	mean    float64
+	m2      float64
+	lastRTT time.Duration
+	jitter  float64
+	hist    []uint64
+}
+
+// NewTotals returns a statistics collector over a whole run.
+func NewTotals() *Totals {
+	return &Totals{
+		hist: make([]uint64, bucket(math.MaxInt64)+1),
+	}
+}
+
+// Sent records a sent probe.
+func (t *Totals) Sent() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.sum.Sent++
+}
+
+// Received records a probe received in order with the given round-trip time.
+func (t *Totals) Received(rtt time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.received(rtt)
+}
+
+// Reordered records a probe received out of order with the given round-trip time.
+func (t *Totals) Reordered(rtt time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.sum.Reordered++
+	t.received(rtt)
+}
+
+func (t *Totals) received(rtt time.Duration) {
+	rtt = max(rtt, 0)
+
+	t.sum.Received++
+	if t.sum.Received == 1 {
+		t.sum.RTT.Min, t.sum.RTT.Max = rtt, rtt
+	} else {
+		d := math.Abs(float64(rtt - t.lastRTT))
+		t.jitter += (d - t.jitter) / 16
+	}
+	t.lastRTT = rtt
+	t.sum.RTT.Min = min(t.sum.RTT.Min, rtt)
+	t.sum.RTT.Max = max(t.sum.RTT.Max, rtt)
+
+	delta := float64(rtt) - t.mean
+	t.mean += delta / float64(t.sum.Received)
+	t.m2 += delta * (float64(rtt) - t.mean)
+
+	t.hist[bucket(rtt)]++
+}
+
+// Lost records a lost probe.
+func (t *Totals) Lost() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.sum.Lost++
+}
+
+// Duplicated records a duplicate response.
+func (t *Totals) Duplicated() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.sum.Duplicated++
+}
+
+// Late records a response received after the probe was considered lost.
+func (t *Totals) Late() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.sum.Late++
+}
+
+// Corrupted records a response with an invalid checksum.
+func (t *Totals) Corrupted() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.sum.Corrupted++
+}
+
+// Summary returns the summary of the whole run. Its window is zero.
+func (t *Totals) Summary() Summary {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	sum := t.sum
+	if sum.Received == 0 {
+		return sum
+	}
+
+	sum.RTT.Avg = time.Duration(t.mean)
+	sum.RTT.StdDev = time.Duration(math.Sqrt(t.m2 / float64(sum.Received)))
+	sum.RTT.P50 = t.percentile(0.5)
+	sum.RTT.P90 = t.percentile(0.9)
+	sum.RTT.P99 = t.percentile(0.99)
+	sum.Jitter = time.Duration(t.jitter)
+	return sum
+}
+
+// percentile returns the estimated nearest-rank percentile of the round-trip times.
+func (t *Totals) percentile(p float64) time.Duration {
+	rank := max(uint64(math.Ceil(p*float64(t.sum.Received))), 1)
+
+	var n uint64
+	for i, count := range t.hist {
+		if n += count; n >= rank {
+			return min(max(bucketValue(i), t.sum.RTT.Min), t.sum.RTT.Max)
+		}
+	}
+	return t.sum.RTT.Max
+}
+
+// bucket returns the histogram bucket of the duration. Durations below
+// the number of sub-buckets have their own bucket, while larger durations
+// share each power of two between the sub-buckets.
+func bucket(d time.Duration) int {
+	v := uint64(d) //nolint:gosec // Durations are not negative.
+	if v < subBuckets {
+		return int(v)
+	}
+	shift := bits.Len64(v) - subBucketBits - 1
+	return subBuckets + shift*subBuckets + int(v>>shift) - subBuckets
+}
+
+// bucketValue returns the midpoint of the durations in the histogram bucket.
+func bucketValue(i int) time.Duration {
+	if i < subBuckets {
+		return time.Duration(i)
+	}
+	shift := (i - subBuckets) / subBuckets
+	lower := uint64(subBuckets+(i-subBuckets)%subBuckets) << shift
+	return time.Duration(lower + (uint64(1)<<shift)/2) //nolint:gosec // The buckets do not exceed durations.
+}
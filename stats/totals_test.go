@@ -0,0 +1,72 @@
+package stats_test
+
+import (
+	"math/rand/v2"
+	"testing"
+	"time"
+
+	"github.com/nitrado/connqc/stats"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTotals_Summary(t *testing.T) {
+	now := time.Now()
+	s := stats.New(time.Hour)
+	tot := stats.NewTotals()
+
+	rnd := rand.New(rand.NewPCG(1, 2))
+	for i := range 10000 {
+		at := now.Add(time.Duration(i-10000) * 100 * time.Millisecond)
+		rtt := 10*time.Millisecond + time.Duration(rnd.NormFloat64()*float64(2*time.Millisecond))
+
+		s.Sent(at)
+		tot.Sent()
+		switch {
+		case i%100 == 0:
+			s.Lost(at)
+			tot.Lost()
+		case i%50 == 0:
+			s.Reordered(at, rtt)
+			tot.Reordered(rtt)
+		default:
+			s.Received(at, rtt)
+			tot.Received(rtt)
+		}
+	}
+	tot.Duplicated()
+	tot.Late()
+	tot.Corrupted()
+
+	want := s.Summary(time.Hour, now)
+	got := tot.Summary()
+
+	assert.Zero(t, got.Window)
+	assert.Equal(t, want.Sent, got.Sent)
+	assert.Equal(t, want.Received, got.Received)
+	assert.Equal(t, want.Lost, got.Lost)
+	assert.Equal(t, want.Reordered, got.Reordered)
+	assert.Equal(t, uint64(1), got.Duplicated)
+	assert.Equal(t, uint64(1), got.Late)
+	assert.Equal(t, uint64(1), got.Corrupted)
+	assert.Equal(t, want.RTT.Min, got.RTT.Min)
+	assert.Equal(t, want.RTT.Max, got.RTT.Max)
+	assert.InDelta(t, float64(want.RTT.Avg), float64(got.RTT.Avg), 1e3)
+	assert.InDelta(t, float64(want.RTT.StdDev), float64(got.RTT.StdDev), 1e3)
+	assert.InEpsilon(t, float64(want.RTT.P50), float64(got.RTT.P50), 0.01)
+	assert.InEpsilon(t, float64(want.RTT.P90), float64(got.RTT.P90), 0.01)
+	assert.InEpsilon(t, float64(want.RTT.P99), float64(got.RTT.P99), 0.01)
+	assert.InDelta(t, float64(want.Jitter), float64(got.Jitter), 1e3)
+	assert.InDelta(t, want.Loss(), got.Loss(), 1e-9)
+}
+
+func TestTotals_SummaryWithoutResponses(t *testing.T) {
+	tot := stats.NewTotals()
+	tot.Sent()
+	tot.Lost()
+
+	got := tot.Summary()
+
+	assert.Equal(t, uint64(1), got.Sent)
+	assert.Equal(t, stats.RTT{}, got.RTT)
+	assert.InDelta(t, 1.0, got.Loss(), 1e-9)
+}
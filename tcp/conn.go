@@ -1,13 +1,21 @@
 package tcp
 
 import (
+	"context"
 	"fmt"
 	"net"
+
+	"github.com/nitrado/connqc/transport"
 )
 
 // Connect returns a new TCP connection.
 func Connect(addr string) (net.Conn, error) {
-	conn, err := net.Dial("tcp", addr)
+	return Transport{}.Dial(context.Background(), &net.Dialer{}, addr)
+}
+
+// Dial returns a new TCP connection established with the dialer.
+func (Transport) Dial(ctx context.Context, d transport.Dialer, addr string) (net.Conn, error) {
+	conn, err := d.DialContext(ctx, "tcp", addr)
 	if err != nil {
 		return nil, fmt.Errorf("dialing: %w", err)
 	}
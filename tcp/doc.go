@@ -1,2 +1,4 @@
-// Package tcp provides a TCP server implementation.
+// Package tcp provides the TCP transport, including a TCP server implementation.
+//
+// The transport is registered as "tcp".
 package tcp
@@ -6,6 +6,8 @@ import (
 	"fmt"
 	"net"
 	"time"
+
+	"github.com/nitrado/connqc/transport"
 )
 
 var testHookServerServe func(net.Listener)
@@ -39,6 +41,13 @@ func (s *Server) Listen(ctx context.Context, addr string) error {
 	if err != nil {
 		return fmt.Errorf("listening: %w", err)
 	}
+
+	return s.Serve(ctx, ln)
+}
+
+// Serve accepts connections from the listener, passing them off to the
+// handler in a goroutine. The listener is closed once the context is done.
+func (s *Server) Serve(ctx context.Context, ln net.Listener) error {
 	defer func() { _ = ln.Close() }()
 
 	if testHookServerServe != nil {
@@ -88,6 +97,21 @@ func (c *packetConn) WriteTo(p []byte, _ net.Addr) (n int, err error) {
 	return c.conn.Write(p)
 }
 
+// Stream reports that the connection is backed by a stream,
+// meaning reads do not preserve message boundaries.
+func (c *packetConn) Stream() bool {
+	return true
+}
+
+// Identity returns the identity of the peer, if the underlying
+// connection knows it.
+func (c *packetConn) Identity() string {
+	if id, ok := c.conn.(transport.Identifier); ok {
+		return id.Identity()
+	}
+	return ""
+}
+
 func (c *packetConn) Close() error {
 	return c.conn.Close()
 }
@@ -0,0 +1,25 @@
+package tcp
+
+import (
+	"context"
+
+	"github.com/nitrado/connqc/transport"
+)
+
+func init() {
+	transport.Register("tcp", Transport{})
+}
+
+var _ transport.Transport = Transport{}
+
+// Transport is the TCP transport, registered as "tcp".
+type Transport struct{}
+
+// Listen serves TCP connections on the address with the given handler.
+func (Transport) Listen(ctx context.Context, addr string, h transport.Handler) error {
+	srv, err := NewServer(h)
+	if err != nil {
+		return err
+	}
+	return srv.Listen(ctx, addr)
+}
@@ -0,0 +1,5 @@
+// Package tls provides the TLS transport, carrying probe messages over TLS
+// on top of TCP, optionally authenticating clients with certificates.
+//
+// The transport is registered as "tls".
+package tls
@@ -0,0 +1,146 @@
+package tls
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/nitrado/connqc/tcp"
+	"github.com/nitrado/connqc/transport"
+)
+
+var testHookServerServe func(net.Listener)
+
+func init() {
+	transport.Register("tls", NewTransport(&tls.Config{MinVersion: tls.VersionTLS12}))
+}
+
+var _ transport.Transport = &Transport{}
+
+// Transport is the TLS transport, registered as "tls".
+//
+// The registered transport verifies servers against the system roots and
+// cannot listen, as it has no certificate. Use NewTransport for a transport
+// with a configuration.
+type Transport struct {
+	config *tls.Config
+}
+
+// NewTransport returns a TLS transport with the given configuration.
+// A nil configuration is treated as the zero configuration.
+//
+// Clients require client certificates when the configuration sets ClientAuth.
+func NewTransport(cfg *tls.Config) *Transport {
+	return &Transport{config: cfg}
+}
+
+// tlsConfig returns the configuration of the transport, treating nil as the zero configuration.
+func (t *Transport) tlsConfig() *tls.Config {
+	if t.config == nil {
+		return &tls.Config{} //nolint:gosec // Matches the defaults of crypto/tls.
+	}
+	return t.config
+}
+
+// Dial returns a new TLS connection over a TCP connection established
+// with the dialer, once the handshake has completed.
+func (t *Transport) Dial(ctx context.Context, d transport.Dialer, addr string) (net.Conn, error) {
+	raw, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("dialing: %w", err)
+	}
+
+	cfg := t.tlsConfig().Clone()
+	if cfg.ServerName == "" {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			_ = raw.Close()
+			return nil, fmt.Errorf("parsing address: %w", err)
+		}
+		cfg.ServerName = host
+	}
+
+	conn := tls.Client(raw, cfg)
+
+	start := time.Now()
+	if err = conn.HandshakeContext(ctx); err != nil {
+		_ = raw.Close()
+		return nil, fmt.Errorf("handshake: %w", err)
+	}
+
+	return &clientConn{Conn: conn, handshake: time.Since(start)}, nil
+}
+
+// Listen serves TLS connections on the address with the given handler.
+//
+// The handshake is performed on the first read from a connection.
+func (t *Transport) Listen(ctx context.Context, addr string, h transport.Handler) error {
+	cfg := t.tlsConfig()
+	if len(cfg.Certificates) == 0 && cfg.GetCertificate == nil && cfg.GetConfigForClient == nil {
+		return errors.New("tls: server certificate required")
+	}
+
+	srv, err := tcp.NewServer(h)
+	if err != nil {
+		return err
+	}
+
+	lc := &net.ListenConfig{}
+	ln, err := lc.Listen(ctx, "tcp", addr)
+	if err != nil {
+		return fmt.Errorf("listening: %w", err)
+	}
+
+	if testHookServerServe != nil {
+		testHookServerServe(ln)
+	}
+
+	return srv.Serve(ctx, &listener{Listener: tls.NewListener(ln, cfg)})
+}
+
+var _ transport.HandshakeTimer = &clientConn{}
+
+// clientConn is a client TLS connection that knows the duration of its handshake.
+type clientConn struct {
+	*tls.Conn
+
+	handshake time.Duration
+}
+
+// HandshakeDuration returns the duration of the TLS handshake.
+func (c *clientConn) HandshakeDuration() time.Duration {
+	return c.handshake
+}
+
+// listener wraps accepted TLS connections, identifying clients by their certificate.
+type listener struct {
+	net.Listener
+}
+
+func (l *listener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &serverConn{Conn: conn.(*tls.Conn)}, nil
+}
+
+var _ transport.Identifier = &serverConn{}
+
+// serverConn is a server TLS connection.
+type serverConn struct {
+	*tls.Conn
+}
+
+// Identity returns the subject of the verified client certificate,
+// or an empty string if the client did not present one.
+func (c *serverConn) Identity() string {
+	state := c.ConnectionState()
+	if len(state.PeerCertificates) == 0 {
+		return ""
+	}
+	return state.PeerCertificates[0].Subject.String()
+}
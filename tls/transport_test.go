@@ -0,0 +1,169 @@
+package tls
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/nitrado/connqc/transport"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransport_DialWithClientCertificate(t *testing.T) {
+	srvCert, err := GenerateSelfSigned("127.0.0.1")
+	require.NoError(t, err)
+	clientCert, err := GenerateSelfSigned()
+	require.NoError(t, err)
+
+	h := &echoHandler{identity: make(chan string, 1)}
+	addr := newTestServer(t, h, &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{srvCert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    certPool(clientCert),
+	})
+
+	tr := NewTransport(&tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{clientCert},
+		RootCAs:      certPool(srvCert),
+	})
+	conn, err := tr.Dial(t.Context(), &net.Dialer{}, addr)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+
+	require.Implements(t, (*transport.HandshakeTimer)(nil), conn)
+	assert.Positive(t, conn.(transport.HandshakeTimer).HandshakeDuration())
+
+	_, err = io.WriteString(conn, "Hello")
+	require.NoError(t, err)
+	got := make([]byte, 512)
+	n, err := conn.Read(got)
+	require.NoError(t, err)
+
+	assert.Equal(t, "Hello", string(got[:n]))
+	assert.Equal(t, "CN=connqc self-signed", <-h.identity)
+}
+
+func TestTransport_DialVerifiesServer(t *testing.T) {
+	srvCert, err := GenerateSelfSigned("127.0.0.1")
+	require.NoError(t, err)
+
+	addr := newTestServer(t, &echoHandler{}, &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{srvCert},
+	})
+
+	_, err = NewTransport(&tls.Config{MinVersion: tls.VersionTLS12}).Dial(t.Context(), &net.Dialer{}, addr)
+
+	var certErr *tls.CertificateVerificationError
+	assert.ErrorAs(t, err, &certErr)
+}
+
+func TestTransport_DialInsecure(t *testing.T) {
+	srvCert, err := GenerateSelfSigned("127.0.0.1")
+	require.NoError(t, err)
+
+	addr := newTestServer(t, &echoHandler{}, &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{srvCert},
+	})
+
+	tr := NewTransport(&tls.Config{MinVersion: tls.VersionTLS12, InsecureSkipVerify: true}) //nolint:gosec // Testing.
+	conn, err := tr.Dial(t.Context(), &net.Dialer{}, addr)
+	require.NoError(t, err)
+
+	_ = conn.Close()
+}
+
+func TestTransport_DialWithNilConfig(t *testing.T) {
+	srvCert, err := GenerateSelfSigned("127.0.0.1")
+	require.NoError(t, err)
+
+	addr := newTestServer(t, &echoHandler{}, &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{srvCert},
+	})
+
+	for name, tr := range map[string]*Transport{"constructor": NewTransport(nil), "zero": {}} {
+		t.Run(name, func(t *testing.T) {
+			_, err := tr.Dial(t.Context(), &net.Dialer{}, addr)
+
+			// The server is verified against the system roots.
+			var certErr *tls.CertificateVerificationError
+			assert.ErrorAs(t, err, &certErr)
+		})
+	}
+}
+
+func TestTransport_ListenRequiresCertificate(t *testing.T) {
+	for name, cfg := range map[string]*tls.Config{"empty": {MinVersion: tls.VersionTLS12}, "nil": nil} {
+		t.Run(name, func(t *testing.T) {
+			err := NewTransport(cfg).Listen(t.Context(), "127.0.0.1:0", &echoHandler{})
+
+			assert.EqualError(t, err, "tls: server certificate required")
+		})
+	}
+}
+
+func newTestServer(t *testing.T, h transport.Handler, cfg *tls.Config) string {
+	t.Helper()
+
+	lnCh := make(chan net.Listener, 1)
+	testHookServerServe = func(ln net.Listener) {
+		lnCh <- ln
+	}
+	t.Cleanup(func() { testHookServerServe = nil })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- NewTransport(cfg).Listen(ctx, "127.0.0.1:0", h)
+	}()
+
+	select {
+	case ln := <-lnCh:
+		return ln.Addr().String()
+	case err := <-errCh:
+		require.FailNow(t, "listening failed", err)
+		return ""
+	}
+}
+
+func certPool(cert tls.Certificate) *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(cert.Leaf)
+	return pool
+}
+
+type echoHandler struct {
+	identity chan string
+}
+
+func (e *echoHandler) Serve(conn net.PacketConn) {
+	buf := make([]byte, 512)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+
+		if id, ok := conn.(transport.Identifier); ok && e.identity != nil {
+			select {
+			case e.identity <- id.Identity():
+			default:
+			}
+		}
+
+		if _, err = conn.WriteTo(buf[:n], addr); err != nil && !errors.Is(err, net.ErrClosed) {
+			return
+		}
+	}
+}
@@ -0,0 +1,3 @@
+// Package transport provides a registry of the transports connqc clients
+// and servers can use to communicate.
+package transport
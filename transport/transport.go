@@ -0,0 +1,99 @@
+package transport
+
+import (
+	"context"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Handler handles the connections of a listener.
+type Handler interface {
+	Serve(conn net.PacketConn)
+}
+
+// Dialer dials network connections, such as a net.Dialer or a proxy.
+type Dialer interface {
+	DialContext(ctx context.Context, network, addr string) (net.Conn, error)
+}
+
+// Transport dials and listens for connections.
+type Transport interface {
+	// Dial connects to the server at the given address, establishing
+	// the underlying network connections with the dialer.
+	Dial(ctx context.Context, d Dialer, addr string) (net.Conn, error)
+
+	// Listen listens on the given address, passing connections off to the
+	// handler until the context is cancelled.
+	Listen(ctx context.Context, addr string, h Handler) error
+}
+
+// Identifier is implemented by connections that know the identity
+// of their peer, such as TLS connections with a client certificate.
+type Identifier interface {
+	Identity() string
+}
+
+// HandshakeTimer is implemented by connections that perform a handshake
+// when connecting, such as TLS connections.
+type HandshakeTimer interface {
+	// HandshakeDuration returns the duration of the handshake.
+	HandshakeDuration() time.Duration
+}
+
+// Resumer is implemented by connections that can resume a previous session,
+// such as QUIC connections using 0-RTT.
+type Resumer interface {
+	// Resumed reports whether the connection resumed a previous session.
+	Resumed() bool
+}
+
+// ProxyTimer is implemented by connections established through a proxy.
+type ProxyTimer interface {
+	// ProxyDuration returns the duration of connecting through the proxy.
+	ProxyDuration() time.Duration
+}
+
+var (
+	mu         sync.RWMutex
+	transports = map[string]Transport{}
+)
+
+// Register makes a transport available under the given name.
+//
+// If Register is called twice with the same name or if the transport is nil, it panics.
+func Register(name string, t Transport) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if t == nil {
+		panic("transport: transport is nil")
+	}
+	if _, ok := transports[name]; ok {
+		panic("transport: transport " + name + " is already registered")
+	}
+	transports[name] = t
+}
+
+// Lookup returns the transport registered under the given name.
+func Lookup(name string) (Transport, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	t, ok := transports[name]
+	return t, ok
+}
+
+// Names returns the sorted names of all registered transports.
+func Names() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	names := make([]string, 0, len(transports))
+	for name := range transports {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
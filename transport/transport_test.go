@@ -0,0 +1,52 @@
+package transport_test
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/nitrado/connqc/transport"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegister(t *testing.T) {
+	tr := &testTransport{}
+
+	transport.Register("test-register", tr)
+
+	got, ok := transport.Lookup("test-register")
+	require.True(t, ok)
+	assert.Same(t, tr, got)
+	assert.Contains(t, transport.Names(), "test-register")
+}
+
+func TestRegister_PanicsOnDuplicateName(t *testing.T) {
+	transport.Register("test-duplicate", &testTransport{})
+
+	assert.PanicsWithValue(t, "transport: transport test-duplicate is already registered", func() {
+		transport.Register("test-duplicate", &testTransport{})
+	})
+}
+
+func TestRegister_PanicsOnNilTransport(t *testing.T) {
+	assert.PanicsWithValue(t, "transport: transport is nil", func() {
+		transport.Register("test-nil", nil)
+	})
+}
+
+func TestLookup_UnknownName(t *testing.T) {
+	_, ok := transport.Lookup("test-unknown")
+
+	assert.False(t, ok)
+}
+
+type testTransport struct{}
+
+func (t *testTransport) Dial(context.Context, transport.Dialer, string) (net.Conn, error) {
+	return nil, nil //nolint:nilnil // Not used in tests.
+}
+
+func (t *testTransport) Listen(context.Context, string, transport.Handler) error {
+	return nil
+}
@@ -1,21 +1,30 @@
 package udp
 
 import (
+	"context"
 	"fmt"
 	"net"
+
+	"github.com/nitrado/connqc/transport"
 )
 
 // Connect returns a new UDP connection.
+//
+// The connection transparently handles the cookie exchange with
+// servers that require cookies.
 func Connect(addr string) (net.Conn, error) {
-	raddr, err := net.ResolveUDPAddr("udp", addr)
-	if err != nil {
-		return nil, fmt.Errorf("resolving address: %w", err)
-	}
+	return (&Transport{}).Dial(context.Background(), &net.Dialer{}, addr)
+}
 
-	conn, err := net.DialUDP("udp", nil, raddr)
+// Dial returns a new UDP connection established with the dialer.
+//
+// The connection transparently handles the cookie exchange with
+// servers that require cookies.
+func (t *Transport) Dial(ctx context.Context, d transport.Dialer, addr string) (net.Conn, error) {
+	conn, err := d.DialContext(ctx, "udp", addr)
 	if err != nil {
 		return nil, fmt.Errorf("dialing: %w", err)
 	}
 
-	return conn, err
+	return &cookieConn{Conn: conn}, nil
 }
@@ -0,0 +1,181 @@
+package udp
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"net"
+	"sync"
+	"time"
+)
+
+// The cookie exchange prevents the server from being used as a reflector
+// for datagrams with a spoofed source address, similar to a DTLS HelloVerifyRequest.
+//
+// Clients send datagrams as is until the server challenges them with a cookie,
+// after which they wrap every datagram in an envelope carrying the cookie.
+// Datagrams smaller than a challenge are always wrapped, with an empty cookie
+// until the server challenges the client.
+// The cookie is derived from the client address and a server secret, so
+// the server does not need to keep any state per client.
+const (
+	cookieLen = 16
+
+	envelopeType  = "CKE"
+	challengeType = "CKC"
+	headerLen     = len(envelopeType) + cookieLen
+
+	// cookieLifetime is the duration a cookie is valid for.
+	// Cookies of the previous period are still accepted.
+	cookieLifetime = 5 * time.Minute
+)
+
+// cookies generates and verifies address cookies.
+type cookies struct {
+	secret []byte
+}
+
+func (c cookies) generate(addr net.Addr, now time.Time) [cookieLen]byte {
+	return c.cookie(addr, now.Unix()/int64(cookieLifetime/time.Second))
+}
+
+func (c cookies) valid(addr net.Addr, cookie []byte, now time.Time) bool {
+	period := now.Unix() / int64(cookieLifetime/time.Second)
+	for _, p := range []int64{period, period - 1} {
+		want := c.cookie(addr, p)
+		if hmac.Equal(cookie, want[:]) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c cookies) cookie(addr net.Addr, period int64) [cookieLen]byte {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], uint64(period)) //nolint:gosec // Only used as hash input.
+
+	mac := hmac.New(sha256.New, c.secret)
+	_, _ = mac.Write(b[:])
+	_, _ = mac.Write([]byte(addr.String()))
+
+	var cookie [cookieLen]byte
+	copy(cookie[:], mac.Sum(nil))
+	return cookie
+}
+
+var _ net.Conn = &cookieConn{}
+
+// maxUnconfirmed is the number of datagrams kept to be resent when the server
+// challenges the client. Older datagrams are not resent, and are lost like
+// datagrams lost on the path.
+const maxUnconfirmed = 64
+
+// cookieConn is a client connection that transparently handles the cookie exchange.
+//
+// Datagrams are sent as is until the server challenges the client, after which
+// they are wrapped in an envelope carrying the cookie. The datagrams written
+// since the last response are resent with the cookie of every new challenge,
+// as the server dropped them. Servers that only echo datagrams send the
+// envelope back, which is stripped on read.
+type cookieConn struct {
+	net.Conn
+
+	mu          sync.Mutex
+	cookie      []byte
+	unconfirmed [][]byte
+
+	buf []byte
+}
+
+func (c *cookieConn) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.unconfirmed) == maxUnconfirmed {
+		copy(c.unconfirmed, c.unconfirmed[1:])
+		c.unconfirmed = c.unconfirmed[:maxUnconfirmed-1]
+	}
+	c.unconfirmed = append(c.unconfirmed, bytes.Clone(p))
+
+	if _, err := c.Conn.Write(c.wrap(p)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// wrap wraps the datagram in an envelope once the client has a cookie.
+//
+// Until then, datagrams smaller than a challenge are wrapped in an envelope
+// with an empty cookie, so servers requiring cookies can challenge them
+// without amplifying traffic.
+func (c *cookieConn) wrap(p []byte) []byte {
+	if c.cookie == nil && len(p) >= headerLen {
+		return p
+	}
+
+	var cookie [cookieLen]byte
+	copy(cookie[:], c.cookie)
+
+	b := make([]byte, 0, headerLen+len(p))
+	b = append(b, envelopeType...)
+	b = append(b, cookie[:]...)
+	return append(b, p...)
+}
+
+func (c *cookieConn) Read(p []byte) (int, error) {
+	if len(c.buf) < headerLen+len(p) {
+		c.buf = make([]byte, headerLen+len(p))
+	}
+
+	for {
+		n, err := c.Conn.Read(c.buf)
+		if err != nil {
+			return 0, err
+		}
+		b := c.buf[:n]
+
+		if n == headerLen && bytes.HasPrefix(b, []byte(challengeType)) {
+			if err = c.challenged(b[len(challengeType):]); err != nil {
+				return 0, err
+			}
+			continue
+		}
+
+		// The server answered, so the datagrams written so far passed the cookie check.
+		c.mu.Lock()
+		c.unconfirmed = c.unconfirmed[:0]
+		c.mu.Unlock()
+
+		if n >= headerLen && bytes.HasPrefix(b, []byte(envelopeType)) {
+			b = b[headerLen:]
+		}
+		return copy(p, b), nil
+	}
+}
+
+// Stream reports that the connection is not backed by a stream,
+// meaning each read returns one datagram.
+func (c *cookieConn) Stream() bool {
+	return false
+}
+
+// challenged adopts the cookie of a challenge, resending the unconfirmed
+// datagrams the server dropped. Further challenges with the same cookie
+// answer datagrams that have already been resent, and are ignored.
+func (c *cookieConn) challenged(cookie []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if bytes.Equal(cookie, c.cookie) {
+		return nil
+	}
+	c.cookie = bytes.Clone(cookie)
+
+	for _, p := range c.unconfirmed {
+		if _, err := c.Conn.Write(c.wrap(p)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
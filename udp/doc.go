@@ -1,2 +1,4 @@
-// Package udp provides a UDP server implementation.
+// Package udp provides the UDP transport, including a UDP server implementation.
+//
+// The transport is registered as "udp".
 package udp
@@ -1,6 +1,7 @@
 package udp
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
@@ -15,20 +16,39 @@ type Handler interface {
 	Serve(conn net.PacketConn)
 }
 
+// Option configures a server.
+type Option func(*Server)
+
+// WithCookies requires clients to complete a stateless cookie exchange before
+// their datagrams are passed to the handler, keyed with the given secret.
+//
+// This prevents the server from reflecting responses to spoofed source addresses.
+// Servers sharing an address must share the secret.
+func WithCookies(secret []byte) Option {
+	return func(s *Server) {
+		s.cookies = &cookies{secret: secret}
+	}
+}
+
 // Server serves UDP connections.
 type Server struct {
 	handler Handler
+	cookies *cookies
 }
 
 // NewServer returns a server with the given handler.
-func NewServer(h Handler) (*Server, error) {
+func NewServer(h Handler, opts ...Option) (*Server, error) {
 	if h == nil {
 		return nil, errors.New("udp: handler cannot be nil")
 	}
 
-	return &Server{
+	srv := &Server{
 		handler: h,
-	}, nil
+	}
+	for _, opt := range opts {
+		opt(srv)
+	}
+	return srv, nil
 }
 
 // Listen listens to an address for new connections, passing them
@@ -43,13 +63,20 @@ func (s *Server) Listen(ctx context.Context, addr string) error {
 	if err != nil {
 		return fmt.Errorf("listening: %w", err)
 	}
+
+	return s.Serve(ctx, ln)
+}
+
+// Serve passes the connection off to the handler in a goroutine.
+// The connection is closed once the context is done.
+func (s *Server) Serve(ctx context.Context, ln *net.UDPConn) error {
 	defer func() { _ = ln.Close() }()
 
 	if testHookServerServe != nil {
 		testHookServerServe(ln)
 	}
 
-	go s.handler.Serve(&gracefulRead{conn: ln})
+	go s.handler.Serve(&gracefulRead{conn: ln, cookies: s.cookies})
 
 	<-ctx.Done()
 
@@ -62,10 +89,14 @@ var _ net.PacketConn = &gracefulRead{}
 //
 // To avoid a recurring read timeout when the UDP connection is unused, we store the activity (active = true)
 // whenever we successfully read. This way we can escalate read errors only once and after we have seen activity.
+//
+// Cookie envelopes are stripped from the datagrams read. If cookies are required,
+// datagrams without a valid cookie are answered with a cookie challenge instead.
 type gracefulRead struct {
 	active       bool
 	readDeadline time.Duration
 	conn         *net.UDPConn
+	cookies      *cookies
 }
 
 func (g *gracefulRead) ReadFrom(p []byte) (n int, addr net.Addr, err error) {
@@ -85,8 +116,36 @@ func (g *gracefulRead) ReadFrom(p []byte) (n int, addr net.Addr, err error) {
 
 		g.active = true
 
-		return n, addr, err
+		var ok bool
+		if n, ok = g.unwrap(p[:n], addr); !ok {
+			continue
+		}
+		return n, addr, nil
+	}
+}
+
+// unwrap strips the cookie envelope from the datagram in place, returning
+// the length of the payload and whether the datagram should be handled.
+func (g *gracefulRead) unwrap(p []byte, addr net.Addr) (int, bool) {
+	hasEnvelope := len(p) >= headerLen && bytes.HasPrefix(p, []byte(envelopeType))
+	switch {
+	case g.cookies == nil && !hasEnvelope:
+		return len(p), true
+	case g.cookies == nil:
+		return copy(p, p[headerLen:]), true
+	case !hasEnvelope && len(p) < headerLen:
+		// Datagrams smaller than a challenge are dropped to avoid amplifying traffic.
+		return 0, false
+	}
+
+	now := time.Now()
+	if !hasEnvelope || !g.cookies.valid(addr, p[len(envelopeType):headerLen], now) {
+		cookie := g.cookies.generate(addr, now)
+		challenge := append([]byte(challengeType), cookie[:]...)
+		_, _ = g.conn.WriteTo(challenge, addr)
+		return 0, false
 	}
+	return copy(p, p[headerLen:]), true
 }
 
 func (g *gracefulRead) WriteTo(p []byte, addr net.Addr) (n int, err error) {
@@ -7,6 +7,7 @@ import (
 	"io"
 	"net"
 	"testing"
+	"time"
 
 	"github.com/hamba/testutils/retry"
 	"github.com/stretchr/testify/assert"
@@ -37,7 +38,131 @@ func TestServer_Listen(t *testing.T) {
 	}
 }
 
-func newTestServer(t testing.TB, h Handler) (*Server, net.Conn) {
+func TestServer_ListenWithCookies(t *testing.T) {
+	_, raw := newTestServer(t, &echoHandler{}, WithCookies([]byte("secret")))
+	t.Cleanup(func() { _ = raw.Close() })
+
+	conn, err := Connect(raw.RemoteAddr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+
+	for i := 0; i < 3; i++ {
+		msg := fmt.Sprintf("Hello %d", i)
+
+		_, err = io.WriteString(conn, msg)
+		require.NoError(t, err, "write error")
+
+		got := make([]byte, 1024)
+		_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+		n, err := conn.Read(got)
+		require.NoError(t, err, "read error")
+
+		assert.Equal(t, msg, string(got[:n]))
+	}
+}
+
+func TestServer_ListenWithCookiesChallengesDatagramsWithoutCookie(t *testing.T) {
+	_, conn := newTestServer(t, &echoHandler{}, WithCookies([]byte("secret")))
+	t.Cleanup(func() { _ = conn.Close() })
+
+	_, err := io.WriteString(conn, "Hello with a spoofed source address")
+	require.NoError(t, err, "write error")
+
+	got := make([]byte, 1024)
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := conn.Read(got)
+	require.NoError(t, err, "read error")
+
+	assert.Equal(t, headerLen, n)
+	assert.Equal(t, challengeType, string(got[:len(challengeType)]))
+}
+
+func TestServer_ListenWithCookiesDropsSmallDatagramsWithoutCookie(t *testing.T) {
+	_, conn := newTestServer(t, &echoHandler{}, WithCookies([]byte("secret")))
+	t.Cleanup(func() { _ = conn.Close() })
+
+	_, err := io.WriteString(conn, "Hello")
+	require.NoError(t, err, "write error")
+
+	got := make([]byte, 1024)
+	_ = conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	_, err = conn.Read(got)
+
+	var netErr net.Error
+	require.ErrorAs(t, err, &netErr)
+	assert.True(t, netErr.Timeout())
+}
+
+func TestConnect_ResendsDatagramsDroppedBeforeChallenge(t *testing.T) {
+	_, raw := newTestServer(t, &echoHandler{}, WithCookies([]byte("secret")))
+	t.Cleanup(func() { _ = raw.Close() })
+
+	conn, err := Connect(raw.RemoteAddr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+
+	// All datagrams written before the challenge arrives are dropped by the server.
+	want := map[string]bool{}
+	for i := 0; i < 3; i++ {
+		msg := fmt.Sprintf("Hello from before the challenge %d", i)
+		want[msg] = true
+
+		_, err = io.WriteString(conn, msg)
+		require.NoError(t, err, "write error")
+	}
+
+	got := map[string]bool{}
+	buf := make([]byte, 1024)
+	for range want {
+		_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+		n, err := conn.Read(buf)
+		require.NoError(t, err, "read error")
+		got[string(buf[:n])] = true
+	}
+	assert.Equal(t, want, got)
+}
+
+func TestConnect_HandlesServerWithoutCookies(t *testing.T) {
+	_, raw := newTestServer(t, &echoHandler{})
+	t.Cleanup(func() { _ = raw.Close() })
+
+	conn, err := Connect(raw.RemoteAddr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+
+	_, err = io.WriteString(conn, "Hello")
+	require.NoError(t, err, "write error")
+
+	got := make([]byte, 1024)
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := conn.Read(got)
+	require.NoError(t, err, "read error")
+
+	assert.Equal(t, "Hello", string(got[:n]))
+}
+
+func TestConnect_SendsDatagramsWithoutEnvelopeUntilChallenged(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = pc.Close() })
+
+	conn, err := Connect(pc.LocalAddr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+
+	msg := "Hello, as large as a probe"
+	_, err = io.WriteString(conn, msg)
+	require.NoError(t, err, "write error")
+
+	got := make([]byte, 1024)
+	_ = pc.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := pc.ReadFrom(got)
+	require.NoError(t, err, "read error")
+
+	assert.Equal(t, msg, string(got[:n]))
+}
+
+func newTestServer(t testing.TB, h Handler, opts ...Option) (*Server, net.Conn) {
 	t.Helper()
 
 	lnCh := make(chan *net.UDPConn, 1)
@@ -46,7 +171,7 @@ func newTestServer(t testing.TB, h Handler) (*Server, net.Conn) {
 	})
 	t.Cleanup(func() { setTestHookServerServe(nil) })
 
-	srv, err := NewServer(h)
+	srv, err := NewServer(h, opts...)
 	require.NoError(t, err)
 
 	go func() {
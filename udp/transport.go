@@ -0,0 +1,35 @@
+package udp
+
+import (
+	"context"
+
+	"github.com/nitrado/connqc/transport"
+)
+
+func init() {
+	transport.Register("udp", &Transport{})
+}
+
+var _ transport.Transport = &Transport{}
+
+// Transport is the UDP transport, registered as "udp".
+//
+// The registered transport does not require cookies. Use
+// NewTransport for a transport with server options.
+type Transport struct {
+	opts []Option
+}
+
+// NewTransport returns a UDP transport whose servers use the given options.
+func NewTransport(opts ...Option) *Transport {
+	return &Transport{opts: opts}
+}
+
+// Listen serves UDP connections on the address with the given handler.
+func (t *Transport) Listen(ctx context.Context, addr string, h transport.Handler) error {
+	srv, err := NewServer(h, t.opts...)
+	if err != nil {
+		return err
+	}
+	return srv.Listen(ctx, addr)
+}
@@ -0,0 +1,65 @@
+package unix
+
+import (
+	"errors"
+	"net"
+	"os"
+	"time"
+)
+
+// datagramConn is a client datagram connection bound to a temporary socket file.
+type datagramConn struct {
+	*net.UnixConn
+
+	local string
+}
+
+// Close closes the connection and removes its socket file.
+func (c *datagramConn) Close() error {
+	err := c.UnixConn.Close()
+	_ = os.Remove(c.local)
+	return err
+}
+
+// Stream reports that the connection is not backed by a stream,
+// meaning each read returns one packet.
+func (c *datagramConn) Stream() bool {
+	return false
+}
+
+var _ net.PacketConn = &gracefulRead{}
+
+// gracefulRead represents a datagram socket where read timeouts are only
+// escalated once after activity, avoiding recurring read timeouts
+// while the socket is unused.
+type gracefulRead struct {
+	net.PacketConn
+
+	active       bool
+	readDeadline time.Duration
+}
+
+func (g *gracefulRead) ReadFrom(p []byte) (n int, addr net.Addr, err error) {
+	var netErr net.Error
+	for {
+		n, addr, err = g.PacketConn.ReadFrom(p)
+		if err != nil {
+			switch {
+			case errors.As(err, &netErr) && netErr.Timeout() && !g.active:
+				_ = g.PacketConn.SetReadDeadline(time.Now().Add(g.readDeadline))
+				continue
+			case errors.As(err, &netErr) && netErr.Timeout() && g.active:
+				g.active = false
+			}
+			return n, addr, err
+		}
+
+		g.active = true
+		return n, addr, nil
+	}
+}
+
+func (g *gracefulRead) SetReadDeadline(t time.Time) error {
+	g.readDeadline = time.Until(t)
+	return g.PacketConn.SetReadDeadline(t)
+}
@@ -0,0 +1,8 @@
+// Package unix provides the Unix domain socket transports, carrying probe
+// messages over stream sockets, comparable to TCP, or datagram sockets,
+// comparable to UDP. They suit sidecars sharing a socket file with the
+// service they check.
+//
+// The transports are registered as "unix" and "unixgram". Addresses are
+// socket file paths.
+package unix
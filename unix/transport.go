@@ -0,0 +1,104 @@
+package unix
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/nitrado/connqc/tcp"
+	"github.com/nitrado/connqc/transport"
+)
+
+var testHookServerServe func(net.Addr)
+
+func init() {
+	transport.Register("unix", Transport{})
+	transport.Register("unixgram", Transport{Datagram: true})
+}
+
+var _ transport.Transport = Transport{}
+
+// Transport is the Unix domain socket transport, registered as "unix"
+// for stream sockets and as "unixgram" for datagram sockets.
+type Transport struct {
+	// Datagram uses datagram sockets instead of stream sockets.
+	Datagram bool
+}
+
+// Dial returns a new Unix domain socket connection to the socket file.
+//
+// Stream connections are established with the dialer. Datagram connections
+// are bound to a temporary socket file to receive responses on, which is
+// removed when the connection is closed.
+func (t Transport) Dial(ctx context.Context, d transport.Dialer, addr string) (net.Conn, error) {
+	if !t.Datagram {
+		conn, err := d.DialContext(ctx, "unix", addr)
+		if err != nil {
+			return nil, fmt.Errorf("dialing: %w", err)
+		}
+		return conn, nil
+	}
+
+	f, err := os.CreateTemp("", "connqc-*.sock")
+	if err != nil {
+		return nil, fmt.Errorf("creating local socket file: %w", err)
+	}
+	local := f.Name()
+	_ = f.Close()
+	_ = os.Remove(local)
+
+	conn, err := net.DialUnix("unixgram", &net.UnixAddr{Name: local, Net: "unixgram"}, &net.UnixAddr{Name: addr, Net: "unixgram"})
+	if err != nil {
+		_ = os.Remove(local)
+		return nil, fmt.Errorf("dialing: %w", err)
+	}
+	return &datagramConn{UnixConn: conn, local: local}, nil
+}
+
+// Listen serves Unix domain socket connections on the socket file
+// with the given handler. The socket file is removed once the context is done.
+func (t Transport) Listen(ctx context.Context, addr string, h transport.Handler) error {
+	if h == nil {
+		return errors.New("unix: handler cannot be nil")
+	}
+
+	lc := &net.ListenConfig{}
+	if !t.Datagram {
+		srv, err := tcp.NewServer(h)
+		if err != nil {
+			return err
+		}
+
+		ln, err := lc.Listen(ctx, "unix", addr)
+		if err != nil {
+			return fmt.Errorf("listening: %w", err)
+		}
+
+		if testHookServerServe != nil {
+			testHookServerServe(ln.Addr())
+		}
+
+		return srv.Serve(ctx, ln)
+	}
+
+	pc, err := lc.ListenPacket(ctx, "unixgram", addr)
+	if err != nil {
+		return fmt.Errorf("listening: %w", err)
+	}
+	defer func() {
+		_ = pc.Close()
+		_ = os.Remove(addr)
+	}()
+
+	if testHookServerServe != nil {
+		testHookServerServe(pc.LocalAddr())
+	}
+
+	go h.Serve(&gracefulRead{PacketConn: pc})
+
+	<-ctx.Done()
+
+	return nil
+}
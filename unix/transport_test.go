@@ -0,0 +1,130 @@
+package unix
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransport_Dial(t *testing.T) {
+	tests := []struct {
+		name     string
+		datagram bool
+	}{
+		{
+			name: "stream",
+		},
+		{
+			name:     "datagram",
+			datagram: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tr := Transport{Datagram: test.datagram}
+			addr := newTestServer(t, tr)
+
+			conn, err := tr.Dial(t.Context(), &net.Dialer{}, addr)
+			require.NoError(t, err)
+			t.Cleanup(func() { _ = conn.Close() })
+
+			_, err = io.WriteString(conn, "Hello")
+			require.NoError(t, err)
+
+			got := make([]byte, 512)
+			n, err := conn.Read(got)
+			require.NoError(t, err)
+
+			assert.Equal(t, "Hello", string(got[:n]))
+		})
+	}
+}
+
+func TestTransport_DialDatagramRemovesSocketFile(t *testing.T) {
+	tr := Transport{Datagram: true}
+	addr := newTestServer(t, tr)
+
+	conn, err := tr.Dial(t.Context(), &net.Dialer{}, addr)
+	require.NoError(t, err)
+	local := conn.LocalAddr().String()
+	require.FileExists(t, local)
+
+	require.NoError(t, conn.Close())
+
+	assert.NoFileExists(t, local)
+}
+
+func TestTransport_ListenRemovesSocketFile(t *testing.T) {
+	for _, tr := range []Transport{{}, {Datagram: true}} {
+		path := filepath.Join(t.TempDir(), "connqc.sock")
+		ctx, cancel := context.WithCancel(t.Context())
+		errCh := make(chan error, 1)
+		go func() { errCh <- tr.Listen(ctx, path, &echoHandler{}) }()
+		require.Eventually(t, func() bool {
+			_, err := os.Stat(path)
+			return err == nil
+		}, time.Second, time.Millisecond)
+
+		cancel()
+		<-errCh
+
+		assert.NoFileExists(t, path)
+	}
+}
+
+func TestTransport_ListenErrorsOnNilHandler(t *testing.T) {
+	err := Transport{}.Listen(t.Context(), filepath.Join(t.TempDir(), "connqc.sock"), nil)
+
+	assert.EqualError(t, err, "unix: handler cannot be nil")
+}
+
+func newTestServer(t *testing.T, tr Transport) string {
+	t.Helper()
+
+	addrCh := make(chan net.Addr, 1)
+	testHookServerServe = func(addr net.Addr) {
+		addrCh <- addr
+	}
+	t.Cleanup(func() { testHookServerServe = nil })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- tr.Listen(ctx, filepath.Join(t.TempDir(), "connqc.sock"), &echoHandler{})
+	}()
+
+	select {
+	case addr := <-addrCh:
+		return addr.String()
+	case err := <-errCh:
+		require.FailNow(t, "listening failed", err)
+		return ""
+	}
+}
+
+type echoHandler struct{}
+
+func (e *echoHandler) Serve(conn net.PacketConn) {
+	buf := make([]byte, 512)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+
+		if _, err = conn.WriteTo(buf[:n], addr); err != nil && !errors.Is(err, net.ErrClosed) {
+			return
+		}
+	}
+}
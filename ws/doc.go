@@ -0,0 +1,5 @@
+// Package ws provides the WebSocket transport, carrying probe messages in
+// binary WebSocket frames to pass through HTTP proxies and L7 load balancers.
+//
+// The transport is registered as "ws", and over TLS as "wss".
+package ws
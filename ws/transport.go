@@ -0,0 +1,308 @@
+package ws
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/nitrado/connqc/transport"
+	"golang.org/x/net/websocket"
+)
+
+// DefaultPath is the default HTTP path on which connections are upgraded.
+const DefaultPath = "/connqc"
+
+var testHookServerServe func(net.Listener)
+
+func init() {
+	transport.Register("ws", NewTransport())
+	transport.Register("wss", NewTransport(WithTLSConfig(&tls.Config{MinVersion: tls.VersionTLS12})))
+}
+
+// Option configures a transport.
+type Option func(*Transport)
+
+// WithPath sets the HTTP path on which connections are upgraded.
+func WithPath(path string) Option {
+	return func(t *Transport) {
+		t.path = path
+	}
+}
+
+// WithTLSConfig carries the WebSocket connections over TLS with the given configuration.
+//
+// Servers require a certificate in the configuration.
+func WithTLSConfig(cfg *tls.Config) Option {
+	return func(t *Transport) {
+		t.tlsConfig = cfg
+	}
+}
+
+// WithReadHeaderTimeout sets the duration a server waits for the
+// headers of the upgrade request. The default is 10 seconds.
+func WithReadHeaderTimeout(d time.Duration) Option {
+	return func(t *Transport) {
+		t.readHeaderTimeout = d
+	}
+}
+
+var _ transport.Transport = &Transport{}
+
+// Transport is the WebSocket transport.
+type Transport struct {
+	path              string
+	tlsConfig         *tls.Config
+	readHeaderTimeout time.Duration
+}
+
+// NewTransport returns a WebSocket transport.
+func NewTransport(opts ...Option) *Transport {
+	t := &Transport{
+		path:              DefaultPath,
+		readHeaderTimeout: 10 * time.Second,
+	}
+	for _, opt := range opts {
+		opt(t)
+	}
+	return t
+}
+
+// Dial returns a new WebSocket connection over a TCP connection established
+// with the dialer, once the upgrade has completed.
+//
+// The address is either a host and port, connecting on the configured path,
+// or a ws:// or wss:// URL.
+func (t *Transport) Dial(ctx context.Context, d transport.Dialer, addr string) (net.Conn, error) {
+	loc, err := t.location(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	origin := &url.URL{Scheme: "http", Host: loc.Host}
+	if loc.Scheme == "wss" {
+		origin.Scheme = "https"
+	}
+
+	cfg, err := websocket.NewConfig(loc.String(), origin.String())
+	if err != nil {
+		return nil, fmt.Errorf("creating config: %w", err)
+	}
+
+	hostport := loc.Host
+	if loc.Port() == "" {
+		port := "80"
+		if loc.Scheme == "wss" {
+			port = "443"
+		}
+		hostport = net.JoinHostPort(loc.Hostname(), port)
+	}
+
+	raw, err := d.DialContext(ctx, "tcp", hostport)
+	if err != nil {
+		return nil, fmt.Errorf("dialing: %w", err)
+	}
+
+	// The upgrade does not support contexts, the connection is closed instead.
+	stop := context.AfterFunc(ctx, func() { _ = raw.Close() })
+	defer stop()
+
+	start := time.Now()
+	conn, err := t.upgrade(ctx, cfg, raw, loc)
+	if err != nil {
+		_ = raw.Close()
+		return nil, err
+	}
+	conn.PayloadType = websocket.BinaryFrame
+
+	return &clientConn{Conn: conn, handshake: time.Since(start)}, nil
+}
+
+// upgrade performs the TLS handshake for secure locations and upgrades the connection.
+func (t *Transport) upgrade(ctx context.Context, cfg *websocket.Config, raw net.Conn, loc *url.URL) (*websocket.Conn, error) {
+	var rwc net.Conn = raw
+	if loc.Scheme == "wss" {
+		tlsCfg := &tls.Config{MinVersion: tls.VersionTLS12}
+		if t.tlsConfig != nil {
+			tlsCfg = t.tlsConfig.Clone()
+		}
+		if tlsCfg.ServerName == "" {
+			tlsCfg.ServerName = loc.Hostname()
+		}
+
+		tlsConn := tls.Client(raw, tlsCfg)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			return nil, fmt.Errorf("tls handshake: %w", err)
+		}
+		rwc = tlsConn
+	}
+
+	conn, err := websocket.NewClient(cfg, rwc)
+	if err != nil {
+		return nil, fmt.Errorf("upgrading: %w", err)
+	}
+	return conn, nil
+}
+
+// location returns the WebSocket URL of the address.
+func (t *Transport) location(addr string) (*url.URL, error) {
+	if strings.Contains(addr, "://") {
+		loc, err := url.Parse(addr)
+		if err != nil {
+			return nil, fmt.Errorf("parsing address: %w", err)
+		}
+		if loc.Scheme != "ws" && loc.Scheme != "wss" {
+			return nil, fmt.Errorf("unsupported scheme %q", loc.Scheme)
+		}
+		return loc, nil
+	}
+
+	loc := &url.URL{Scheme: "ws", Host: addr, Path: t.path}
+	if t.tlsConfig != nil {
+		loc.Scheme = "wss"
+	}
+	return loc, nil
+}
+
+// Listen serves WebSocket connections upgraded on the configured path
+// of the address with the given handler.
+func (t *Transport) Listen(ctx context.Context, addr string, h transport.Handler) error {
+	if h == nil {
+		return errors.New("ws: handler cannot be nil")
+	}
+	if t.tlsConfig != nil && len(t.tlsConfig.Certificates) == 0 && t.tlsConfig.GetCertificate == nil {
+		return errors.New("ws: server certificate required")
+	}
+
+	lc := &net.ListenConfig{}
+	ln, err := lc.Listen(ctx, "tcp", addr)
+	if err != nil {
+		return fmt.Errorf("listening: %w", err)
+	}
+	if t.tlsConfig != nil {
+		ln = tls.NewListener(ln, t.tlsConfig)
+	}
+
+	if testHookServerServe != nil {
+		testHookServerServe(ln)
+	}
+
+	network := "ws"
+	if t.tlsConfig != nil {
+		network = "wss"
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(t.path, websocket.Server{
+		// Probes are not sent by browsers, the origin is not checked.
+		Handshake: func(*websocket.Config, *http.Request) error { return nil },
+		Handler: func(conn *websocket.Conn) {
+			conn.PayloadType = websocket.BinaryFrame
+			h.Serve(&packetConn{conn: conn, network: network})
+		},
+	})
+	srv := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: t.readHeaderTimeout,
+	}
+
+	go func() {
+		<-ctx.Done()
+		_ = srv.Close()
+	}()
+
+	if err = srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+var _ transport.HandshakeTimer = &clientConn{}
+
+// clientConn is a client WebSocket connection that knows the duration of its handshake.
+type clientConn struct {
+	*websocket.Conn
+
+	handshake time.Duration
+}
+
+// HandshakeDuration returns the duration of the connection upgrade,
+// including the TLS handshake for secure connections.
+func (c *clientConn) HandshakeDuration() time.Duration {
+	return c.handshake
+}
+
+var _ net.PacketConn = &packetConn{}
+
+// packetConn makes a WebSocket connection act like an unbound connection
+// to support the same interface that a UDP connection offers.
+type packetConn struct {
+	conn    *websocket.Conn
+	network string
+}
+
+func (c *packetConn) ReadFrom(p []byte) (n int, addr net.Addr, err error) {
+	n, err = c.conn.Read(p)
+	return n, c.remoteAddr(), err
+}
+
+func (c *packetConn) WriteTo(p []byte, _ net.Addr) (n int, err error) {
+	return c.conn.Write(p)
+}
+
+// remoteAddr returns the address of the client, as the WebSocket
+// connection reports the origin instead.
+func (c *packetConn) remoteAddr() net.Addr {
+	return addr{network: c.network, addr: c.conn.Request().RemoteAddr}
+}
+
+// Stream reports that the connection is backed by a stream,
+// meaning reads do not preserve message boundaries.
+func (c *packetConn) Stream() bool {
+	return true
+}
+
+// Identity returns the subject of the verified client certificate,
+// or an empty string if the client did not present one.
+func (c *packetConn) Identity() string {
+	state := c.conn.Request().TLS
+	if state == nil || len(state.PeerCertificates) == 0 {
+		return ""
+	}
+	return state.PeerCertificates[0].Subject.String()
+}
+
+func (c *packetConn) Close() error {
+	return c.conn.Close()
+}
+
+func (c *packetConn) LocalAddr() net.Addr {
+	return c.conn.LocalAddr()
+}
+
+func (c *packetConn) SetDeadline(t time.Time) error {
+	return c.conn.SetDeadline(t)
+}
+
+func (c *packetConn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+func (c *packetConn) SetWriteDeadline(t time.Time) error {
+	return c.conn.SetWriteDeadline(t)
+}
+
+// addr is the address of a WebSocket client.
+type addr struct {
+	network string
+	addr    string
+}
+
+func (a addr) Network() string { return a.network }
+
+func (a addr) String() string { return a.addr }
@@ -0,0 +1,154 @@
+package ws
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"net"
+	"testing"
+
+	tlstransport "github.com/nitrado/connqc/tls"
+	"github.com/nitrado/connqc/transport"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransport_Dial(t *testing.T) {
+	h := &echoHandler{addrs: make(chan net.Addr, 1)}
+	addr := newTestServer(t, NewTransport(), h)
+
+	conn, err := NewTransport().Dial(t.Context(), &net.Dialer{}, addr)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+
+	assertEcho(t, conn)
+
+	got := <-h.addrs
+	assert.Equal(t, "ws", got.Network())
+	host, _, err := net.SplitHostPort(got.String())
+	require.NoError(t, err)
+	assert.Equal(t, "127.0.0.1", host)
+}
+
+func TestTransport_DialURL(t *testing.T) {
+	addr := newTestServer(t, NewTransport(WithPath("/probe")), &echoHandler{})
+
+	conn, err := NewTransport().Dial(t.Context(), &net.Dialer{}, "ws://"+addr+"/probe")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+
+	assertEcho(t, conn)
+}
+
+func TestTransport_DialWrongPath(t *testing.T) {
+	addr := newTestServer(t, NewTransport(), &echoHandler{})
+
+	_, err := NewTransport(WithPath("/other")).Dial(t.Context(), &net.Dialer{}, addr)
+
+	assert.Error(t, err)
+}
+
+func TestTransport_DialUnsupportedScheme(t *testing.T) {
+	_, err := NewTransport().Dial(t.Context(), &net.Dialer{}, "http://127.0.0.1:0/connqc")
+
+	assert.EqualError(t, err, `unsupported scheme "http"`)
+}
+
+func TestTransport_DialSecure(t *testing.T) {
+	cert, err := tlstransport.GenerateSelfSigned("127.0.0.1")
+	require.NoError(t, err)
+	pool := x509.NewCertPool()
+	pool.AddCert(cert.Leaf)
+
+	srvTransport := NewTransport(WithTLSConfig(&tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+	}))
+	h := &echoHandler{addrs: make(chan net.Addr, 1)}
+	addr := newTestServer(t, srvTransport, h)
+
+	tr := NewTransport(WithTLSConfig(&tls.Config{MinVersion: tls.VersionTLS12, RootCAs: pool}))
+	conn, err := tr.Dial(t.Context(), &net.Dialer{}, addr)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+
+	require.Implements(t, (*transport.HandshakeTimer)(nil), conn)
+	assert.Positive(t, conn.(transport.HandshakeTimer).HandshakeDuration())
+
+	assertEcho(t, conn)
+	assert.Equal(t, "wss", (<-h.addrs).Network())
+}
+
+func TestTransport_ListenSecureRequiresCertificate(t *testing.T) {
+	tr := NewTransport(WithTLSConfig(&tls.Config{MinVersion: tls.VersionTLS12}))
+
+	err := tr.Listen(t.Context(), "127.0.0.1:0", &echoHandler{})
+
+	assert.EqualError(t, err, "ws: server certificate required")
+}
+
+func assertEcho(t *testing.T, conn net.Conn) {
+	t.Helper()
+
+	_, err := io.WriteString(conn, "Hello")
+	require.NoError(t, err)
+
+	got := make([]byte, 512)
+	n, err := conn.Read(got)
+	require.NoError(t, err)
+
+	assert.Equal(t, "Hello", string(got[:n]))
+}
+
+func newTestServer(t *testing.T, tr *Transport, h transport.Handler) string {
+	t.Helper()
+
+	lnCh := make(chan net.Listener, 1)
+	testHookServerServe = func(ln net.Listener) {
+		lnCh <- ln
+	}
+	t.Cleanup(func() { testHookServerServe = nil })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- tr.Listen(ctx, "127.0.0.1:0", h)
+	}()
+
+	select {
+	case ln := <-lnCh:
+		return ln.Addr().String()
+	case err := <-errCh:
+		require.FailNow(t, "listening failed", err)
+		return ""
+	}
+}
+
+type echoHandler struct {
+	addrs chan net.Addr
+}
+
+func (e *echoHandler) Serve(conn net.PacketConn) {
+	buf := make([]byte, 512)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+
+		if e.addrs != nil {
+			select {
+			case e.addrs <- addr:
+			default:
+			}
+		}
+
+		if _, err = conn.WriteTo(buf[:n], addr); err != nil && !errors.Is(err, net.ErrClosed) {
+			return
+		}
+	}
+}